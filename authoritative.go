@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/alissonbk/dns-server/client"
+	"github.com/alissonbk/dns-server/notify"
+	"github.com/alissonbk/dns-server/secondary"
+	"github.com/alissonbk/dns-server/server"
+	"github.com/alissonbk/dns-server/tsig"
+	"github.com/alissonbk/dns-server/zone"
+)
+
+// authoritativeConfig holds the flags of the zones served authoritatively
+type authoritativeConfig struct {
+	zones       assignments
+	secondaries assignments
+	notify      assignments
+	// where the secondary zones are saved, nothing is saved when empty
+	secondaryDir string
+	transferACL  string
+	updateACL    string
+	keyring      tsig.Keyring
+	// name of the key signing what is sent to the other servers
+	key          string
+	transferKeys string
+	updateKeys   string
+	// answers the names outside every zone, nil refuses them
+	next server.Handler
+}
+
+// handler loads the primary zones, starts refreshing the secondary ones and
+// returns the handler serving them. SIGHUP reloads the primary zone files
+func (c *authoritativeConfig) handler(ctx context.Context) (server.Handler, error) {
+	store := zone.NewStore()
+	zoneClient := &client.Client{}
+	if c.key != "" {
+		key, ok := c.keyring.Get(c.key)
+		if !ok {
+			return nil, fmt.Errorf("the TSIG key %s is not in the keys file", c.key)
+		}
+		zoneClient.Key = key
+	}
+
+	notifier := &notify.Notifier{Client: zoneClient, Secondaries: map[string][]string{}}
+	for _, assignment := range c.notify {
+		origin, addrs, _ := strings.Cut(assignment, "=")
+		notifier.Secondaries[zone.New(origin, "IN").Origin] = strings.Split(addrs, ",")
+	}
+
+	files := map[*zone.Zone]string{}
+	for _, assignment := range c.zones {
+		origin, path, _ := strings.Cut(assignment, "=")
+		z, err := zone.Load(path, origin, "IN")
+		if err != nil {
+			return nil, err
+		}
+		notifier.Watch(z)
+		store.Add(z)
+		files[z] = path
+	}
+	go reloadOnHangup(ctx, files)
+
+	authoritative := &zone.Handler{Store: store, Next: c.next}
+	var err error
+	if authoritative.TransferACL, err = parsePrefixes(c.transferACL); err != nil {
+		return nil, fmt.Errorf("invalid transfer ACL, cause: %w", err)
+	}
+	if authoritative.UpdateACL, err = parsePrefixes(c.updateACL); err != nil {
+		return nil, fmt.Errorf("invalid update ACL, cause: %w", err)
+	}
+	if authoritative.TransferKeys, err = c.keyNames(c.transferKeys); err != nil {
+		return nil, err
+	}
+	if authoritative.UpdateKeys, err = c.keyNames(c.updateKeys); err != nil {
+		return nil, err
+	}
+
+	notifications := &notify.Handler{Next: authoritative, Primaries: map[string][]netip.Addr{}}
+	if zoneClient.Key != nil {
+		notifications.Keys = []string{zoneClient.Key.Name}
+	}
+	copies := map[string]*secondary.Zone{}
+	for _, assignment := range c.secondaries {
+		origin, primaries, _ := strings.Cut(assignment, "=")
+		s := &secondary.Zone{
+			Origin:    zone.New(origin, "IN").Origin,
+			Class:     "IN",
+			Primaries: strings.Split(primaries, ","),
+			Store:     store,
+			Client:    zoneClient,
+		}
+		for _, primary := range s.Primaries {
+			addrs, err := primaryAddrs(primary)
+			if err != nil {
+				return nil, err
+			}
+			notifications.Primaries[s.Origin] = append(notifications.Primaries[s.Origin], addrs...)
+		}
+		if c.secondaryDir != "" {
+			s.File = filepath.Join(c.secondaryDir, s.Origin+"zone")
+		}
+		if err := s.Load(); err != nil {
+			log.Printf("failed to load the saved copy of %s, transferring it again, cause: %s", s.Origin, err)
+		}
+		copies[s.Origin] = s
+		go s.Run(ctx)
+	}
+	notifications.Refresh = func(origin string) {
+		copies[origin].Notified()
+	}
+
+	return notifications, nil
+}
+
+// keyNames splits a comma separated list of key names, every key must be in the keyring
+func (c *authoritativeConfig) keyNames(list string) ([]string, error) {
+	if list == "" {
+		return nil, nil
+	}
+	names := strings.Split(list, ",")
+	for _, name := range names {
+		if _, ok := c.keyring.Get(name); !ok {
+			return nil, fmt.Errorf("the TSIG key %s is not in the keys file", name)
+		}
+	}
+	return names, nil
+}
+
+// parsePrefixes parses a comma separated list of prefixes, nil for an empty list
+func parsePrefixes(list string) ([]netip.Prefix, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var prefixes []netip.Prefix
+	for _, prefix := range strings.Split(list, ",") {
+		parsed, err := netip.ParsePrefix(prefix)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, parsed)
+	}
+	return prefixes, nil
+}
+
+// primaryAddrs resolves the addresses a primary may send NOTIFY from
+func primaryAddrs(primary string) ([]netip.Addr, error) {
+	host, _, err := net.SplitHostPort(primary)
+	if err != nil {
+		return nil, fmt.Errorf("invalid primary %s, cause: %w", primary, err)
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve the primary %s, cause: %w", primary, err)
+	}
+	addrs := make([]netip.Addr, 0, len(ips))
+	for _, ip := range ips {
+		if addr, ok := netip.AddrFromSlice(ip); ok {
+			addrs = append(addrs, addr.Unmap())
+		}
+	}
+	return addrs, nil
+}
+
+// reloadOnHangup reads the zone files again on every SIGHUP, the changes are
+// journaled and notified when the serial was increased
+func reloadOnHangup(ctx context.Context, files map[*zone.Zone]string) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+		}
+		for z, path := range files {
+			records, err := zone.ParseFile(path, z.Origin, z.Class)
+			if err == nil {
+				err = z.Replace(records)
+			}
+			if err != nil {
+				log.Printf("failed to reload %s, cause: %s", path, err)
+				continue
+			}
+			serial, _ := z.Serial()
+			log.Printf("reloaded %s serial %d", z.Origin, serial)
+		}
+	}
+}
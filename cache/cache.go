@@ -0,0 +1,305 @@
+package cache
+
+import (
+	"container/list"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+const (
+	// responses are never kept longer than this, whatever their TTLs say
+	maxTTL = 24 * time.Hour
+	// cap of negative responses (RFC 2308 section 5)
+	maxNegativeTTL = 3 * time.Hour
+	// memory taken by an entry besides its encoded message: key, list element, map bucket
+	entryOverhead = 256
+	// TTL of the records of stale responses (RFC 8767 section 4)
+	staleTTL = 30
+	// after failing to refresh a stale entry it's served without trying again for this long (RFC 8767 section 5)
+	staleRefreshTime = 30 * time.Second
+)
+
+type key struct {
+	name   string
+	qtype  string
+	qclass string
+}
+
+func newKey(name string, qtype string, qclass string) key {
+	return key{name: strings.ToLower(dns.Fqdn(name)), qtype: qtype, qclass: qclass}
+}
+
+type entry struct {
+	key key
+	// only RCODE, RA and the three record sections are kept
+	message *dns.Message
+	stored  time.Time
+	expires time.Time
+	// estimated memory taken by the entry
+	size int
+	// fresh lookups since it was stored
+	hits int
+	// a query to refresh the entry is in flight
+	refreshing bool
+	// when the last refresh failed, zero if it didn't
+	failed time.Time
+}
+
+// Cache keeps responses by question for as long as their records may be cached.
+// When the memory taken goes over MaxBytes the least recently used entries are evicted
+type Cache struct {
+	MaxBytes int
+	// how long expired responses are kept to be served when they can't be refreshed
+	// (RFC 8767), 0 drops them as soon as they expire
+	MaxStale time.Duration
+
+	mutex   sync.Mutex
+	entries map[key]*list.Element
+	// front is the most recently used
+	lru  *list.List
+	used int
+	// replaceable clock
+	now func() time.Time
+}
+
+func New(maxBytes int) *Cache {
+	return &Cache{
+		MaxBytes: maxBytes,
+		entries:  map[key]*list.Element{},
+		lru:      list.New(),
+		now:      time.Now,
+	}
+}
+
+// lookup is the result of looking for a key in the cache
+type lookup struct {
+	found bool
+	// the entry expired, message has the stale TTL
+	stale bool
+	// a refresh of the stale entry failed recently, it shouldn't be retried yet
+	recentlyFailed bool
+	message        *dns.Message
+	// fresh lookups of the entry, including this one
+	hits      int
+	remaining time.Duration
+	ttl       time.Duration
+}
+
+// Get returns a copy of the cached response with every TTL decremented by the time
+// it spent in the cache, false when there is none or it expired
+func (c *Cache) Get(name string, qtype string, qclass string) (*dns.Message, bool) {
+	l := c.lookup(newKey(name, qtype, qclass))
+	if !l.found || l.stale {
+		return nil, false
+	}
+	return l.message, true
+}
+
+// GetStale returns a copy of an expired response still within MaxStale,
+// its TTLs are set to 30 seconds (RFC 8767 section 4)
+func (c *Cache) GetStale(name string, qtype string, qclass string) (*dns.Message, bool) {
+	l := c.lookup(newKey(name, qtype, qclass))
+	if !l.found || !l.stale {
+		return nil, false
+	}
+	return l.message, true
+}
+
+func (c *Cache) lookup(k key) lookup {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[k]
+	if !ok {
+		return lookup{}
+	}
+	e := element.Value.(*entry)
+	now := c.now()
+	if !now.Before(e.expires.Add(c.MaxStale)) {
+		c.remove(element)
+		return lookup{}
+	}
+	c.lru.MoveToFront(element)
+
+	if !now.Before(e.expires) {
+		return lookup{
+			found:          true,
+			stale:          true,
+			recentlyFailed: !e.failed.IsZero() && now.Sub(e.failed) < staleRefreshTime,
+			message:        withTTL(e.message, staleTTL),
+		}
+	}
+
+	e.hits++
+	return lookup{
+		found:     true,
+		message:   withElapsed(e.message, now.Sub(e.stored)),
+		hits:      e.hits,
+		remaining: e.expires.Sub(now),
+		ttl:       e.expires.Sub(e.stored),
+	}
+}
+
+// startRefresh marks the entry as being refreshed, false when it's already being refreshed or is gone
+func (c *Cache) startRefresh(k key) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[k]
+	if !ok {
+		return false
+	}
+	e := element.Value.(*entry)
+	if e.refreshing {
+		return false
+	}
+	e.refreshing = true
+	return true
+}
+
+// endRefresh clears the refreshing mark, failed records when the entry couldn't be refreshed
+func (c *Cache) endRefresh(k key, failed bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[k]; ok {
+		e := element.Value.(*entry)
+		e.refreshing = false
+		if failed {
+			e.failed = c.now()
+		}
+	}
+}
+
+// Set caches the response to the question. Only responses that can be cached are kept:
+// positive answers for as long as their smallest TTL, NXDOMAIN and NODATA for as long
+// as the SOA in the authority section says (RFC 2308 section 5). Anything else is ignored
+func (c *Cache) Set(name string, qtype string, qclass string, response *dns.Message) {
+	ttl, ok := cacheTTL(response)
+	if !ok || ttl <= 0 {
+		return
+	}
+
+	authority := response.Authority
+	if response.Header.RCODE == dns.RcodeNXDomain || len(response.Answers) == 0 {
+		// the SOA of a negative response counts down from the negative TTL, otherwise the
+		// caches downstream would keep the response longer than they should (RFC 2308 section 5)
+		authority = slices.Clone(authority)
+		for i, record := range authority {
+			if record.TYPE == "SOA" {
+				authority[i].TTL = min(record.TTL, int32(ttl/time.Second))
+			}
+		}
+	}
+	message := &dns.Message{
+		Header:     dns.Header{RCODE: response.Header.RCODE, RA: response.Header.RA},
+		Answers:    response.Answers,
+		Authority:  authority,
+		Additional: response.Additional,
+	}
+	size := entryOverhead
+	if payload, err := message.EncodeMessage(); err == nil {
+		size += len(payload)
+	}
+	if size > c.MaxBytes {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	k := newKey(name, qtype, qclass)
+	if element, ok := c.entries[k]; ok {
+		c.remove(element)
+	}
+	now := c.now()
+	e := &entry{key: k, message: message, stored: now, expires: now.Add(ttl), size: size}
+	c.entries[k] = c.lru.PushFront(e)
+	c.used += size
+
+	for c.used > c.MaxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Len returns the number of cached responses, including expired ones not evicted yet
+func (c *Cache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len()
+}
+
+// caller must hold the mutex
+func (c *Cache) remove(element *list.Element) {
+	e := c.lru.Remove(element).(*entry)
+	delete(c.entries, e.key)
+	c.used -= e.size
+}
+
+// cacheTTL returns for how long the response can be cached
+func cacheTTL(response *dns.Message) (time.Duration, bool) {
+	if response.Header.TC {
+		return 0, false
+	}
+
+	switch {
+	case response.Header.RCODE == dns.RcodeNoError && len(response.Answers) > 0:
+		ttl := maxTTL
+		for _, sections := range [][]dns.Answer{response.Answers, response.Authority, response.Additional} {
+			for _, record := range sections {
+				ttl = min(ttl, seconds(record.TTL))
+			}
+		}
+		return ttl, true
+	case response.Header.RCODE == dns.RcodeNoError, response.Header.RCODE == dns.RcodeNXDomain:
+		// the TTL of a negative response is the smaller of the SOA TTL and its MINIMUM field,
+		// without a SOA it must not be cached (RFC 2308 section 5)
+		for _, record := range response.Authority {
+			if soa, ok := record.RDATA.(*dns.SOA); ok {
+				return min(maxNegativeTTL, seconds(record.TTL), time.Duration(soa.MINIMUM)*time.Second), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func seconds(ttl int32) time.Duration {
+	return time.Duration(max(ttl, 0)) * time.Second
+}
+
+// withElapsed copies the message decrementing the TTLs, the RDATA are shared as they're never modified
+func withElapsed(message *dns.Message, elapsed time.Duration) *dns.Message {
+	decrement := int32(elapsed / time.Second)
+	return copyMessage(message, func(ttl int32) int32 { return max(ttl-decrement, 0) })
+}
+
+// withTTL copies the message setting every TTL to the same value
+func withTTL(message *dns.Message, ttl int32) *dns.Message {
+	return copyMessage(message, func(int32) int32 { return ttl })
+}
+
+func copyMessage(message *dns.Message, ttl func(int32) int32) *dns.Message {
+	copySection := func(section []dns.Answer) []dns.Answer {
+		if section == nil {
+			return nil
+		}
+		records := make([]dns.Answer, len(section))
+		for i, record := range section {
+			record.TTL = ttl(record.TTL)
+			records[i] = record
+		}
+		return records
+	}
+
+	return &dns.Message{
+		Header:     message.Header,
+		Answers:    copySection(message.Answers),
+		Authority:  copySection(message.Authority),
+		Additional: copySection(message.Additional),
+	}
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+// clock is a fake time source moved forward by the tests, background refreshes read it too
+type clock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestCache(maxBytes int) (*Cache, *clock) {
+	c := New(maxBytes)
+	fake := &clock{now: time.Unix(1_700_000_000, 0)}
+	c.now = fake.Now
+	return c, fake
+}
+
+func answer(name string, ttl int32) *dns.Message {
+	return &dns.Message{
+		Header: dns.Header{QR: true, RA: true},
+		Answers: []dns.Answer{{
+			NAME: name, TYPE: "A", CLASS: "IN", TTL: ttl,
+			RDATA: &dns.A{ADDRESS: netip.MustParseAddr("192.0.2.1")},
+		}},
+	}
+}
+
+func negative(rcode uint16, soaTTL int32, minimum uint32) *dns.Message {
+	return &dns.Message{
+		Header: dns.Header{QR: true, RCODE: rcode},
+		Authority: []dns.Answer{{
+			NAME: "example.com.", TYPE: "SOA", CLASS: "IN", TTL: soaTTL,
+			RDATA: &dns.SOA{MNAME: "ns.example.com.", RNAME: "hostmaster.example.com.", SERIAL: 1, MINIMUM: minimum},
+		}},
+	}
+}
+
+func TestCacheTTLDecrement(t *testing.T) {
+	c, clock := newTestCache(1 << 20)
+	c.Set("www.example.com.", "A", "IN", answer("www.example.com.", 300))
+
+	clock.Add(100 * time.Second)
+	cached, ok := c.Get("WWW.example.com", "A", "IN")
+	if !ok {
+		t.Fatal("expected a cached response")
+	}
+	if ttl := cached.Answers[0].TTL; ttl != 200 {
+		t.Fatalf("expected the TTL to be 200 after 100s, got %d", ttl)
+	}
+
+	clock.Add(200 * time.Second)
+	if _, ok := c.Get("www.example.com.", "A", "IN"); ok {
+		t.Fatal("the response is still cached once its TTL is over")
+	}
+	if c.Len() != 0 {
+		t.Fatalf("expected the expired entry to be removed, %d left", c.Len())
+	}
+}
+
+func TestCacheLRUEviction(t *testing.T) {
+	c, _ := newTestCache(1 << 20)
+	c.Set("a.example.com.", "A", "IN", answer("a.example.com.", 300))
+	size := c.used
+	// room for three entries of the same size
+	c.MaxBytes = 3*size + size/2
+
+	c.Set("b.example.com.", "A", "IN", answer("b.example.com.", 300))
+	c.Set("c.example.com.", "A", "IN", answer("c.example.com.", 300))
+	// a becomes the most recently used, b is the least recently used
+	if _, ok := c.Get("a.example.com.", "A", "IN"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	c.Set("d.example.com.", "A", "IN", answer("d.example.com.", 300))
+
+	for name, cached := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if _, ok := c.Get(name+".example.com.", "A", "IN"); ok != cached {
+			t.Errorf("expected %s cached %t, got %t", name, cached, ok)
+		}
+	}
+	if c.used > c.MaxBytes {
+		t.Fatalf("%d bytes used, over the %d allowed", c.used, c.MaxBytes)
+	}
+}
+
+func TestCacheNegative(t *testing.T) {
+	tests := []struct {
+		name     string
+		response *dns.Message
+		// how long it's cached and the SOA TTL served right away, 0 when it's not cached
+		ttl    time.Duration
+		soaTTL int32
+	}{
+		{"NXDOMAIN capped by MINIMUM", negative(dns.RcodeNXDomain, 3600, 60), 60 * time.Second, 60},
+		{"NODATA capped by the SOA TTL", negative(dns.RcodeNoError, 30, 600), 30 * time.Second, 30},
+		{"capped to 3 hours", negative(dns.RcodeNXDomain, 86400, 86400), 3 * time.Hour, 3 * 3600},
+		{"without a SOA", &dns.Message{Header: dns.Header{QR: true, RCODE: dns.RcodeNXDomain}}, 0, 0},
+		{"SERVFAIL", negative(dns.RcodeServFail, 3600, 60), 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, clock := newTestCache(1 << 20)
+			var originalTTL int32
+			if len(test.response.Authority) > 0 {
+				originalTTL = test.response.Authority[0].TTL
+			}
+			c.Set("missing.example.com.", "A", "IN", test.response)
+
+			cached, ok := c.Get("missing.example.com.", "A", "IN")
+			if test.ttl == 0 {
+				if ok {
+					t.Fatal("the response must not be cached")
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("expected a cached response")
+			}
+			if cached.Header.RCODE != test.response.Header.RCODE {
+				t.Fatalf("expected %s, got %s", dns.RcodeString(test.response.Header.RCODE), dns.RcodeString(cached.Header.RCODE))
+			}
+			if ttl := cached.Authority[0].TTL; ttl != test.soaTTL {
+				t.Fatalf("expected the SOA TTL to be %d, got %d", test.soaTTL, ttl)
+			}
+			if test.response.Authority[0].TTL != originalTTL {
+				t.Fatal("the response given to Set was modified")
+			}
+
+			clock.Add(test.ttl - time.Second)
+			cached, ok = c.Get("missing.example.com.", "A", "IN")
+			if !ok || cached.Authority[0].TTL != 1 {
+				t.Fatalf("expected the SOA to count down to 1 just before expiring, got %v", cached)
+			}
+			clock.Add(time.Second)
+			if _, ok := c.Get("missing.example.com.", "A", "IN"); ok {
+				t.Fatal("the response is still cached after its negative TTL")
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"log"
+	"time"
+
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/server"
+)
+
+const (
+	// how long a client waits for a fresh response before a stale one is sent (RFC 8767 section 5)
+	defaultClientResponseTimeout = 1800 * time.Millisecond
+	defaultPrefetchThreshold     = 0.1
+)
+
+// Handler answers from the Cache when it can, every other query goes to Next and its response is cached.
+//
+// When Cache.MaxStale is set and Next fails or takes longer than ClientResponseTimeout,
+// the expired response is served instead (RFC 8767), Next is still awaited in the
+// background to refresh the cache
+type Handler struct {
+	Cache *Cache
+	Next  server.Handler
+	// defaults to 1.8s
+	ClientResponseTimeout time.Duration
+	// an entry looked up at least PrefetchHits times is refreshed in the background once
+	// its remaining TTL drops below PrefetchThreshold (a fraction of its original TTL).
+	// 0 disables prefetching
+	PrefetchHits int
+	// defaults to 0.1
+	PrefetchThreshold float64
+}
+
+// outcome of a query sent to Next
+type result struct {
+	response *dns.Message
+	err      error
+}
+
+func (h *Handler) ServeDNS(req *server.Request) (*dns.Message, error) {
+	// only standard queries with a single question can be keyed
+	if req.Message.Header.OPCODE != dns.OpcodeQuery || len(req.Message.Questions) != 1 {
+		return h.Next.ServeDNS(req)
+	}
+
+	question := req.Message.Questions[0]
+	k := newKey(question.QNAME, question.QTYPE, question.QCLASS)
+	cached := h.Cache.lookup(k)
+	if cached.found && !cached.stale {
+		if h.shouldPrefetch(cached) && h.Cache.startRefresh(k) {
+			log.Printf("prefetching %s %s", question.QNAME, question.QTYPE)
+			go h.fetch(backgroundRequest(req), k)
+		}
+		return cached.message, nil
+	}
+	// the last refresh failed, the upstreams are not bothered again so soon
+	if cached.stale && cached.recentlyFailed {
+		return cached.message, nil
+	}
+
+	if !cached.stale {
+		r := h.fetch(req, k)
+		return r.response, r.err
+	}
+
+	if !h.Cache.startRefresh(k) {
+		// a refresh is already running, the stale response is all there is for now
+		return cached.message, nil
+	}
+	done := make(chan result, 1)
+	go func() { done <- h.fetch(backgroundRequest(req), k) }()
+
+	timer := time.NewTimer(h.clientResponseTimeout())
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		if failed(r) {
+			return cached.message, nil
+		}
+		return r.response, nil
+	case <-timer.C:
+		return cached.message, nil
+	}
+}
+
+// fetch queries Next and caches the response
+func (h *Handler) fetch(req *server.Request, k key) result {
+	response, err := h.Next.ServeDNS(req)
+	r := result{response: response, err: err}
+	if failed(r) {
+		h.Cache.endRefresh(k, true)
+		return r
+	}
+	question := req.Message.Questions[0]
+	// a cacheable response replaces the entry, the old one is only left when it isn't
+	h.Cache.Set(question.QNAME, question.QTYPE, question.QCLASS, response)
+	h.Cache.endRefresh(k, false)
+	return r
+}
+
+// failed reports responses that must not replace a stale one (RFC 8767 section 4)
+func failed(r result) bool {
+	if r.err != nil || r.response == nil {
+		return true
+	}
+	rcode := r.response.Header.RCODE
+	return rcode == dns.RcodeServFail || rcode == dns.RcodeRefused
+}
+
+func (h *Handler) shouldPrefetch(cached lookup) bool {
+	if h.PrefetchHits <= 0 || cached.hits < h.PrefetchHits {
+		return false
+	}
+	threshold := h.PrefetchThreshold
+	if threshold <= 0 {
+		threshold = defaultPrefetchThreshold
+	}
+	return float64(cached.remaining) < threshold*float64(cached.ttl)
+}
+
+// backgroundRequest copies the question of the client request for queries that may
+// outlive the reply, the client message belongs to the server once the reply is sent
+func backgroundRequest(req *server.Request) *server.Request {
+	question := *req.Message.Questions[0]
+	return &server.Request{
+		Message: &dns.Message{
+			Header:    dns.Header{RD: true},
+			Questions: []*dns.Question{&question},
+		},
+		RemoteAddr: req.RemoteAddr,
+		Network:    req.Network,
+	}
+}
+
+func (h *Handler) clientResponseTimeout() time.Duration {
+	if h.ClientResponseTimeout <= 0 {
+		return defaultClientResponseTimeout
+	}
+	return h.ClientResponseTimeout
+}
@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/server"
+)
+
+// upstream stands for Next, the nth query is answered by the function of the test
+type upstream struct {
+	queries atomic.Int32
+	answer  func(n int32) (*dns.Message, error)
+}
+
+func newUpstream(answer func(n int32) (*dns.Message, error)) *upstream {
+	return &upstream{answer: answer}
+}
+
+func (u *upstream) ServeDNS(req *server.Request) (*dns.Message, error) {
+	return u.answer(u.queries.Add(1))
+}
+
+// waitRefreshed waits for a background refresh to replace the entry of www.example.com.,
+// the new one is recognized by its TTL
+func waitRefreshed(t *testing.T, c *Cache, ttl int32) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if cached, ok := c.Get("www.example.com.", "A", "IN"); ok && cached.Answers[0].TTL == ttl {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("the entry was never refreshed")
+}
+
+func query(name string) *server.Request {
+	return &server.Request{Message: &dns.Message{
+		Header:    dns.Header{RD: true},
+		Questions: []*dns.Question{{QNAME: name, QTYPE: "A", QCLASS: "IN"}},
+	}}
+}
+
+func TestHandlerServesStaleWhenTheUpstreamFails(t *testing.T) {
+	c, clock := newTestCache(1 << 20)
+	c.MaxStale = time.Hour
+	next := newUpstream(func(n int32) (*dns.Message, error) {
+		if n == 1 {
+			return answer("www.example.com.", 60), nil
+		}
+		return nil, errors.New("upstream down")
+	})
+	h := &Handler{Cache: c, Next: next}
+
+	if _, err := h.ServeDNS(query("www.example.com.")); err != nil {
+		t.Fatal(err)
+	}
+	clock.Add(2 * time.Minute)
+
+	response, err := h.ServeDNS(query("www.example.com."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Answers) != 1 || response.Answers[0].TTL != staleTTL {
+		t.Fatalf("expected the stale answer with a TTL of %d, got %v", staleTTL, response.Answers)
+	}
+	if next.queries.Load() != 2 {
+		t.Fatalf("expected a refresh to be tried, the upstream got %d queries", next.queries.Load())
+	}
+
+	// right after a failed refresh the upstream is left alone
+	if response, _ := h.ServeDNS(query("www.example.com.")); len(response.Answers) != 1 {
+		t.Fatalf("expected the stale answer again, got %v", response.Answers)
+	}
+	if next.queries.Load() != 2 {
+		t.Fatalf("the refresh was retried right after failing, the upstream got %d queries", next.queries.Load())
+	}
+
+	// past MaxStale there is nothing left to serve
+	clock.Add(2 * time.Hour)
+	if _, err := h.ServeDNS(query("www.example.com.")); err == nil {
+		t.Fatal("expected the upstream error once the entry is gone")
+	}
+}
+
+func TestHandlerServesStaleWhenTheUpstreamIsSlow(t *testing.T) {
+	c, clock := newTestCache(1 << 20)
+	c.MaxStale = time.Hour
+	release := make(chan struct{})
+	next := newUpstream(func(n int32) (*dns.Message, error) {
+		if n == 2 {
+			<-release
+		}
+		return answer("www.example.com.", 60), nil
+	})
+	h := &Handler{Cache: c, Next: next, ClientResponseTimeout: 20 * time.Millisecond}
+
+	h.ServeDNS(query("www.example.com."))
+	clock.Add(2 * time.Minute)
+
+	start := time.Now()
+	response, err := h.ServeDNS(query("www.example.com."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Answers[0].TTL != staleTTL {
+		t.Fatalf("expected the stale answer, got %v", response.Answers)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("the stale answer took %s", elapsed)
+	}
+
+	// the refresh still completes in the background and replaces the entry
+	close(release)
+	waitRefreshed(t, c, 60)
+}
+
+func TestHandlerPrefetch(t *testing.T) {
+	c, clock := newTestCache(1 << 20)
+	next := newUpstream(func(n int32) (*dns.Message, error) {
+		return answer("www.example.com.", 100), nil
+	})
+	h := &Handler{Cache: c, Next: next, PrefetchHits: 2, PrefetchThreshold: 0.1}
+
+	h.ServeDNS(query("www.example.com."))
+
+	// popular enough but far from expiring
+	h.ServeDNS(query("www.example.com."))
+	h.ServeDNS(query("www.example.com."))
+	clock.Add(50 * time.Second)
+	h.ServeDNS(query("www.example.com."))
+	if next.queries.Load() != 1 {
+		t.Fatalf("prefetched with half of the TTL left, the upstream got %d queries", next.queries.Load())
+	}
+
+	// within the last 10% of the TTL the cached answer is served and refreshed in the background
+	clock.Add(45 * time.Second)
+	response, _ := h.ServeDNS(query("www.example.com."))
+	if response.Answers[0].TTL != 5 {
+		t.Fatalf("expected the cached answer with 5s left, got %v", response.Answers)
+	}
+	waitRefreshed(t, c, 100)
+}
+
+func TestHandlerPrefetchNeedsHits(t *testing.T) {
+	c, clock := newTestCache(1 << 20)
+	next := newUpstream(func(n int32) (*dns.Message, error) {
+		return answer("www.example.com.", 100), nil
+	})
+	h := &Handler{Cache: c, Next: next, PrefetchHits: 3}
+
+	h.ServeDNS(query("www.example.com."))
+	clock.Add(95 * time.Second)
+	// a single lookup of the entry isn't enough
+	h.ServeDNS(query("www.example.com."))
+	time.Sleep(20 * time.Millisecond)
+	if next.queries.Load() != 1 {
+		t.Fatalf("prefetched an unpopular entry, the upstream got %d queries", next.queries.Load())
+	}
+}
@@ -0,0 +1,293 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/tsig"
+)
+
+const (
+	defaultTimeout = 2 * time.Second
+	defaultUDPSize = 1232
+)
+
+// ErrMismatch is returned when the response doesn't belong to the query (different ID or question)
+var ErrMismatch = errors.New("response doesn't match the query")
+
+// Client sends queries to other DNS servers
+type Client struct {
+	// time allowed for a whole exchange (UDP and the TCP retry), defaults to 2s
+	Timeout time.Duration
+	// UDP payload size advertised when the query carries EDNS without one, defaults to 1232
+	UDPSize uint16
+	// signs every query with the key and only accepts responses signed with it (RFC 8945), nil sends them unsigned
+	Key *tsig.Key
+}
+
+// Exchange sends the query to addr (host:port) over UDP and retries over TCP when the response has TC set.
+// The query is sent with a random ID, which is replaced by the original one in the returned response,
+// so the caller's message is never modified. Responses that don't match the question are discarded.
+// returns the response and the round trip time of the exchange
+func (c *Client) Exchange(ctx context.Context, query *dns.Message, addr string) (*dns.Message, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	start := time.Now()
+	response, err := c.ExchangeUDP(ctx, query, addr)
+	if err != nil {
+		return nil, time.Since(start), err
+	}
+	if response.Header.TC {
+		response, err = c.ExchangeTCP(ctx, query, addr)
+		if err != nil {
+			return nil, time.Since(start), fmt.Errorf("failed to retry the truncated response over TCP, cause: %w", err)
+		}
+	}
+	return response, time.Since(start), nil
+}
+
+// ExchangeUDP sends the query once over UDP and waits for a matching response
+func (c *Client) ExchangeUDP(ctx context.Context, query *dns.Message, addr string) (*dns.Message, error) {
+	wire, id, session, err := c.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s, cause: %w", addr, err)
+	}
+	defer conn.Close()
+	c.setDeadline(ctx, conn)
+
+	if _, err := conn.Write(wire); err != nil {
+		return nil, fmt.Errorf("failed to send the query to %s, cause: %w", addr, err)
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the response from %s, cause: %w", addr, err)
+		}
+		// anything that doesn't match may be a late response or a spoofing attempt, keep waiting
+		response, err := matchResponse(buf[:n], query, id)
+		if err != nil {
+			continue
+		}
+		if _, err := verify(session, buf[:n], response); err != nil {
+			return nil, fmt.Errorf("failed to verify the response from %s, cause: %w", addr, err)
+		}
+		return response, nil
+	}
+}
+
+// ExchangeTCP sends the query over a new TCP connection
+func (c *Client) ExchangeTCP(ctx context.Context, query *dns.Message, addr string) (*dns.Message, error) {
+	conn, err := c.DialTCP(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	wire, id, session, err := c.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if err := dns.WriteTCP(conn, wire); err != nil {
+		return nil, fmt.Errorf("failed to send the query to %s, cause: %w", addr, err)
+	}
+
+	payload, err := dns.ReadTCP(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the response from %s, cause: %w", addr, err)
+	}
+	response, err := matchResponse(payload, query, id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := verify(session, payload, response); err != nil {
+		return nil, fmt.Errorf("failed to verify the response from %s, cause: %w", addr, err)
+	}
+	return response, nil
+}
+
+// Transfer sends the query over a new TCP connection and passes every message of the response
+// to receive until it reports the response is complete, as zone transfers answer with a sequence
+// of messages. The client timeout applies to each message instead of the whole transfer.
+// with a Key some messages may be unsigned, but not the first nor the last (RFC 8945 section 5.3.1)
+func (c *Client) Transfer(ctx context.Context, query *dns.Message, addr string, receive func(*dns.Message) (bool, error)) error {
+	conn, err := c.DialTCP(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	wire, id, session, err := c.prepare(query)
+	if err != nil {
+		return err
+	}
+	if err := dns.WriteTCP(conn, wire); err != nil {
+		return fmt.Errorf("failed to send the query to %s, cause: %w", addr, err)
+	}
+
+	for first := true; ; first = false {
+		c.setDeadline(ctx, conn)
+		payload, err := dns.ReadTCP(conn)
+		if err != nil {
+			return fmt.Errorf("failed to read the response from %s, cause: %w", addr, err)
+		}
+		response, err := dns.DecodeMessage(payload)
+		if err != nil {
+			return fmt.Errorf("failed to decode the response from %s, cause: %w", addr, err)
+		}
+		// only the first message must carry the question (RFC 5936 section 2.2)
+		if !response.Header.QR || response.Header.ID != id || (first || len(response.Questions) > 0) && !SameQuestions(query, response) {
+			return ErrMismatch
+		}
+		response.Header.ID = query.Header.ID
+		signed, err := verify(session, payload, response)
+		if err != nil {
+			return fmt.Errorf("failed to verify the response from %s, cause: %w", addr, err)
+		}
+
+		done, err := receive(response)
+		if err != nil {
+			return err
+		}
+		if done {
+			if session != nil && !signed {
+				return fmt.Errorf("the transfer from %s ended with an unverified message, cause: %w", addr, tsig.ErrUnsigned)
+			}
+			return nil
+		}
+	}
+}
+
+// DialTCP opens a TCP connection whose deadline follows the context (or the client timeout)
+func (c *Client) DialTCP(ctx context.Context, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s, cause: %w", addr, err)
+	}
+	c.setDeadline(ctx, conn)
+	return conn, nil
+}
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return defaultTimeout
+	}
+	return c.Timeout
+}
+
+// setDeadline sets the earliest of the context deadline and the client timeout from now
+func (c *Client) setDeadline(ctx context.Context, conn net.Conn) {
+	deadline := time.Now().Add(c.timeout())
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+}
+
+// prepare encodes a copy of the query with a random ID, signed when the client has a Key.
+// the returned session verifies the responses, nil without a Key
+func (c *Client) prepare(query *dns.Message) ([]byte, uint16, *tsig.Session, error) {
+	q := *query
+	q.Header.ID = uint16(rand.Uint32())
+	q.Header.QR = false
+	if q.EDNS != nil && q.EDNS.UDPSIZE == 0 {
+		edns := *q.EDNS
+		edns.UDPSIZE = c.UDPSize
+		if edns.UDPSIZE == 0 {
+			edns.UDPSIZE = defaultUDPSize
+		}
+		q.EDNS = &edns
+	}
+
+	wire, err := q.EncodeMessage()
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to encode the query, cause: %w", err)
+	}
+	if c.Key == nil {
+		return wire, q.Header.ID, nil, nil
+	}
+	session := tsig.NewSession(c.Key)
+	if wire, err = session.Sign(wire, time.Now()); err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to sign the query, cause: %w", err)
+	}
+	return wire, q.Header.ID, session, nil
+}
+
+// verify checks the TSIG of a response and removes it from the decoded message,
+// returns whether the response was signed
+func verify(session *tsig.Session, payload []byte, response *dns.Message) (bool, error) {
+	if session == nil {
+		return false, nil
+	}
+	signed, err := session.Verify(payload, time.Now())
+	if err != nil {
+		return signed, err
+	}
+	response.Additional = slices.DeleteFunc(response.Additional, func(record dns.Answer) bool {
+		return record.TYPE == "TSIG"
+	})
+	return signed, nil
+}
+
+// matchResponse decodes the payload and checks it answers the query sent with id,
+// the original query ID is restored in the returned message
+func matchResponse(payload []byte, query *dns.Message, id uint16) (*dns.Message, error) {
+	response, err := dns.DecodeMessage(payload)
+	if err != nil {
+		return nil, err
+	}
+	if !response.Header.QR || response.Header.ID != id || !SameQuestions(query, response) {
+		return nil, ErrMismatch
+	}
+	response.Header.ID = query.Header.ID
+	return response, nil
+}
+
+// SameQuestions compares the question sections, names are case insensitive
+func SameQuestions(a *dns.Message, b *dns.Message) bool {
+	if len(a.Questions) != len(b.Questions) {
+		return false
+	}
+	for i, qa := range a.Questions {
+		qb := b.Questions[i]
+		if !dns.EqualNames(qa.QNAME, qb.QNAME) || qa.QTYPE != qb.QTYPE || qa.QCLASS != qb.QCLASS {
+			return false
+		}
+	}
+	return true
+}
+
+// Retry calls fn until it succeeds or was called attempts times, waiting backoff before
+// the first retry and twice as long before each of the next ones. returns the last error
+func Retry(ctx context.Context, attempts int, backoff time.Duration, fn func() error) error {
+	var err error
+	for attempt := range attempts {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return fmt.Errorf("%w, last failure: %w", ctx.Err(), err)
+			}
+			backoff *= 2
+		}
+		if err = fn(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
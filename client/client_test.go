@@ -0,0 +1,214 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+// respond gives the messages a fake server sends back to a query received over network,
+// they're written as they are so they can be wrong in any way
+type respond func(network string, query *dns.Message) []*dns.Message
+
+// startServer serves respond over UDP and TCP on the same loopback port, returns its address
+func startServer(t *testing.T, respond respond) string {
+	t.Helper()
+	// the UDP socket takes the port of the TCP listener, which may be taken already
+	var l net.Listener
+	var conn *net.UDPConn
+	for range 10 {
+		var err error
+		if l, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		if conn, err = net.ListenUDP("udp", net.UDPAddrFromAddrPort(l.Addr().(*net.TCPAddr).AddrPort())); err == nil {
+			break
+		}
+		l.Close()
+	}
+	if conn == nil {
+		t.Skip("can't bind UDP and TCP to the same loopback port")
+	}
+	t.Cleanup(func() {
+		l.Close()
+		conn.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, source, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			query, err := dns.DecodeMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, response := range respond("udp", query) {
+				if payload, err := response.EncodeMessage(); err == nil {
+					conn.WriteToUDP(payload, source)
+				}
+			}
+		}
+	}()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				payload, err := dns.ReadTCP(c)
+				if err != nil {
+					return
+				}
+				query, err := dns.DecodeMessage(payload)
+				if err != nil {
+					return
+				}
+				for _, response := range respond("tcp", query) {
+					if payload, err := response.EncodeMessage(); err == nil {
+						dns.WriteTCP(c, payload)
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func testQuery() *dns.Message {
+	return &dns.Message{
+		Header:    dns.Header{ID: 0xBEEF, RD: true},
+		Questions: []*dns.Question{{QNAME: "www.example.com.", QTYPE: "A", QCLASS: "IN"}},
+	}
+}
+
+// reply answers the query with an A record, keeping its ID and question
+func reply(query *dns.Message, address string) *dns.Message {
+	return &dns.Message{
+		Header:    dns.Header{ID: query.Header.ID, QR: true, RD: query.Header.RD},
+		Questions: query.Questions,
+		Answers: []dns.Answer{{
+			NAME: query.Questions[0].QNAME, TYPE: "A", CLASS: "IN", TTL: 60,
+			RDATA: &dns.A{ADDRESS: netip.MustParseAddr(address)},
+		}},
+	}
+}
+
+func address(response *dns.Message) string {
+	if len(response.Answers) != 1 {
+		return ""
+	}
+	return response.Answers[0].RDATA.String()
+}
+
+// responses that don't belong to the query are skipped, the matching one is returned
+func TestExchangeSkipsMismatches(t *testing.T) {
+	queries := make(chan *dns.Message, 10)
+	addr := startServer(t, func(network string, query *dns.Message) []*dns.Message {
+		queries <- query
+		otherID := reply(query, "192.0.2.1")
+		otherID.Header.ID++
+		otherQuestion := reply(query, "192.0.2.2")
+		otherQuestion.Questions = []*dns.Question{{QNAME: "mail.example.com.", QTYPE: "A", QCLASS: "IN"}}
+		otherType := reply(query, "192.0.2.3")
+		otherType.Questions = []*dns.Question{{QNAME: "www.example.com.", QTYPE: "AAAA", QCLASS: "IN"}}
+		notResponse := reply(query, "192.0.2.4")
+		notResponse.Header.QR = false
+		matching := reply(query, "192.0.2.5")
+		// names are case insensitive
+		matching.Questions = []*dns.Question{{QNAME: "WWW.example.COM.", QTYPE: "A", QCLASS: "IN"}}
+		return []*dns.Message{otherID, otherQuestion, otherType, notResponse, matching}
+	})
+
+	query := testQuery()
+	response, _, err := (&Client{Timeout: time.Second}).Exchange(context.Background(), query, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address(response) != "192.0.2.5" {
+		t.Fatalf("expected the matching response, got %v", response.Answers)
+	}
+	// the query goes out with its own ID, the response gets the caller's one
+	if response.Header.ID != 0xBEEF || query.Header.ID != 0xBEEF {
+		t.Fatalf("expected ID 0xBEEF in the query and the response, got %#x and %#x", query.Header.ID, response.Header.ID)
+	}
+	if len(queries) != 1 {
+		t.Fatalf("expected a single query, got %d", len(queries))
+	}
+}
+
+func TestExchangeOnlyMismatches(t *testing.T) {
+	addr := startServer(t, func(network string, query *dns.Message) []*dns.Message {
+		response := reply(query, "192.0.2.1")
+		response.Header.ID++
+		return []*dns.Message{response}
+	})
+	if _, _, err := (&Client{Timeout: 200 * time.Millisecond}).Exchange(context.Background(), testQuery(), addr); err == nil {
+		t.Fatal("expected the exchange to time out")
+	}
+
+	// over TCP nothing else can come, the mismatch is an error
+	if _, err := (&Client{Timeout: time.Second}).ExchangeTCP(context.Background(), testQuery(), addr); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("expected ErrMismatch, got %v", err)
+	}
+}
+
+func TestExchangeTruncated(t *testing.T) {
+	networks := make(chan string, 10)
+	addr := startServer(t, func(network string, query *dns.Message) []*dns.Message {
+		networks <- network
+		if network == "udp" {
+			truncated := reply(query, "192.0.2.1")
+			truncated.Header.TC = true
+			truncated.Answers = nil
+			return []*dns.Message{truncated}
+		}
+		return []*dns.Message{reply(query, "192.0.2.2")}
+	})
+
+	response, _, err := (&Client{Timeout: time.Second}).Exchange(context.Background(), testQuery(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Header.TC || address(response) != "192.0.2.2" || response.Header.ID != 0xBEEF {
+		t.Fatalf("expected the response over TCP, got TC %t with %v", response.Header.TC, response.Answers)
+	}
+	if len(networks) != 2 || <-networks != "udp" || <-networks != "tcp" {
+		t.Fatal("expected a query over UDP then one over TCP")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var calls []time.Time
+	err := Retry(context.Background(), 3, 20*time.Millisecond, func() error {
+		calls = append(calls, time.Now())
+		return errors.New("failed")
+	})
+	if err == nil || len(calls) != 3 {
+		t.Fatalf("expected 3 failed attempts, got %d with %v", len(calls), err)
+	}
+	if first, second := calls[1].Sub(calls[0]), calls[2].Sub(calls[1]); first < 20*time.Millisecond || second < 40*time.Millisecond {
+		t.Fatalf("expected the backoff to double, waited %s then %s", first, second)
+	}
+
+	calls = nil
+	err = Retry(context.Background(), 3, time.Millisecond, func() error {
+		calls = append(calls, time.Now())
+		if len(calls) < 2 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	if err != nil || len(calls) != 2 {
+		t.Fatalf("expected to stop after the first success, got %d attempts with %v", len(calls), err)
+	}
+}
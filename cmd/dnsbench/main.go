@@ -0,0 +1,253 @@
+// dnsbench measures the throughput of the dns encode/decode path and of the server loop.
+//
+//	dnsbench -mode codec                            encode/decode a typical response in a loop
+//	dnsbench -mode server -reuseport 4 -workers 64  start an in-process server and load it over loopback
+//	dnsbench -mode load -target 127.0.0.1:2053      load an already running server
+//
+// The encode/decode path and the UDP server loop also have benchmarks: go test -bench . ./dns ./server
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/server"
+)
+
+func main() {
+	mode := flag.String("mode", "codec", "codec, server or load")
+	duration := flag.Duration("duration", 10*time.Second, "how long to run")
+	target := flag.String("target", "127.0.0.1:2053", "server to load in load mode")
+	addr := flag.String("addr", "127.0.0.1:5399", "address of the in-process server in server mode")
+	clients := flag.Int("clients", 64, "concurrent clients of the load generator, each with one query in flight")
+	workers := flag.Int("workers", 64, "workers of the in-process server")
+	reusePort := flag.Int("reuseport", 1, "SO_REUSEPORT sockets of the in-process server")
+	flag.Parse()
+
+	switch *mode {
+	case "codec":
+		benchCodec(*duration)
+	case "server":
+		s := &server.Server{
+			Addr:      *addr,
+			Handler:   server.HandlerFunc(answerHandler),
+			Workers:   *workers,
+			ReusePort: *reusePort,
+		}
+		go func() {
+			if err := s.ListenAndServe(); err != nil && !errors.Is(err, server.ErrServerClosed) {
+				fmt.Println("Server stopped:", err)
+				os.Exit(1)
+			}
+		}()
+		if err := waitForServer(*addr, 5*time.Second); err != nil {
+			fmt.Println("Server not ready:", err)
+			os.Exit(1)
+		}
+		load(*addr, *clients, *duration)
+		s.Shutdown(context.Background())
+	case "load":
+		load(*target, *clients, *duration)
+	default:
+		fmt.Println("unknown mode", *mode)
+		os.Exit(2)
+	}
+}
+
+// query asks for the addresses of a host, the load generator goes through 1000 of them
+func query(id uint16) *dns.Message {
+	return &dns.Message{
+		Header:    dns.Header{ID: id, RD: true},
+		Questions: []*dns.Question{{QNAME: fmt.Sprintf("host%d.example.com.", id%1000), QTYPE: "A", QCLASS: "IN"}},
+		EDNS:      &dns.EDNS{UDPSIZE: 1232},
+	}
+}
+
+// answer is what an authoritative server would send for the query: the host is an alias
+// of a web server with a few addresses, plus the servers of the zone and their addresses,
+// so compression and the common RDATA types are exercised
+func answer(query *dns.Message) *dns.Message {
+	qname := query.Questions[0].QNAME
+	m := &dns.Message{
+		Header:    dns.Header{ID: query.Header.ID, QR: true, AA: true, RD: query.Header.RD},
+		Questions: query.Questions,
+		Answers: []dns.Answer{
+			{NAME: qname, TYPE: "CNAME", CLASS: "IN", TTL: 300, RDATA: &dns.CNAME{CNAME: "web.example.com."}},
+		},
+		EDNS: &dns.EDNS{UDPSIZE: 1232},
+	}
+	for i := range 4 {
+		m.Answers = append(m.Answers, dns.Answer{
+			NAME: "web.example.com.", TYPE: "A", CLASS: "IN", TTL: 300,
+			RDATA: &dns.A{ADDRESS: netip.AddrFrom4([4]byte{198, 51, 100, byte(1 + i)})},
+		})
+	}
+	m.Authority = []dns.Answer{
+		{NAME: "example.com.", TYPE: "NS", CLASS: "IN", TTL: 3600, RDATA: &dns.NS{NSDNAME: "ns1.example.com."}},
+		{NAME: "example.com.", TYPE: "NS", CLASS: "IN", TTL: 3600, RDATA: &dns.NS{NSDNAME: "ns2.example.com."}},
+	}
+	m.Additional = []dns.Answer{
+		{NAME: "ns1.example.com.", TYPE: "A", CLASS: "IN", TTL: 3600, RDATA: &dns.A{ADDRESS: netip.MustParseAddr("198.51.100.53")}},
+		{NAME: "ns2.example.com.", TYPE: "AAAA", CLASS: "IN", TTL: 3600, RDATA: &dns.AAAA{ADDRESS: netip.MustParseAddr("2001:db8:53::1")}},
+	}
+	return m
+}
+
+func benchCodec(duration time.Duration) {
+	m := answer(query(1))
+	encoded, err := m.EncodeMessage()
+	if err != nil {
+		fmt.Println("failed to encode the sample message:", err)
+		os.Exit(1)
+	}
+
+	half := duration / 2
+	ops := 0
+	start := time.Now()
+	for time.Since(start) < half {
+		for range 1000 {
+			if _, err := m.EncodeMessage(); err != nil {
+				fmt.Println("encode failed:", err)
+				os.Exit(1)
+			}
+		}
+		ops += 1000
+	}
+	report("encode", ops, time.Since(start), len(encoded))
+
+	ops = 0
+	start = time.Now()
+	for time.Since(start) < half {
+		for range 1000 {
+			if _, err := dns.DecodeMessage(encoded); err != nil {
+				fmt.Println("decode failed:", err)
+				os.Exit(1)
+			}
+		}
+		ops += 1000
+	}
+	report("decode", ops, time.Since(start), len(encoded))
+}
+
+func report(name string, ops int, elapsed time.Duration, size int) {
+	if ops == 0 {
+		fmt.Printf("%-7s no operation completed in %s\n", name, elapsed)
+		return
+	}
+	perOp := elapsed / time.Duration(ops)
+	fmt.Printf("%-7s %10.0f ops/s  %8s/op  %d bytes message\n", name, float64(ops)/elapsed.Seconds(), perOp, size)
+}
+
+func answerHandler(req *server.Request) (*dns.Message, error) {
+	if len(req.Message.Questions) != 1 {
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeFormErr}}, nil
+	}
+	return answer(req.Message), nil
+}
+
+// waitForServer queries the server until it answers, ListenAndServe doesn't tell when its sockets are bound
+func waitForServer(addr string, timeout time.Duration) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to dial %s, cause: %w", addr, err)
+	}
+	defer conn.Close()
+	payload, err := query(0).EncodeMessage()
+	if err != nil {
+		return fmt.Errorf("failed to encode the query, cause: %w", err)
+	}
+
+	buf := make([]byte, 65535)
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := conn.Write(payload); err == nil {
+			conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+			if _, err := conn.Read(buf); err == nil {
+				return nil
+			}
+		}
+		// nothing listens yet, the port is unreachable right away
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("no response from %s after %s", addr, timeout)
+}
+
+// load runs closed loop clients, each one waits for the response (or a 1s timeout) before the next query
+func load(target string, clients int, duration time.Duration) {
+	var sent, received, timeouts atomic.Int64
+	latencies := make([][]time.Duration, clients)
+	deadline := time.Now().Add(duration)
+
+	var wg sync.WaitGroup
+	for c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("udp", target)
+			if err != nil {
+				fmt.Println("failed to dial:", err)
+				return
+			}
+			defer conn.Close()
+
+			buf := make([]byte, 65535)
+			for id := uint16(0); time.Now().Before(deadline); id++ {
+				payload, err := query(id).EncodeMessage()
+				if err != nil {
+					fmt.Println("failed to encode the query:", err)
+					return
+				}
+
+				start := time.Now()
+				if _, err := conn.Write(payload); err != nil {
+					continue
+				}
+				sent.Add(1)
+
+				conn.SetReadDeadline(start.Add(time.Second))
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						timeouts.Add(1)
+						break
+					}
+					// ignore late responses of queries that already timed out
+					header, err := dns.DecodeHeader(buf[:n])
+					if err != nil || header.ID != id {
+						continue
+					}
+					received.Add(1)
+					latencies[c] = append(latencies[c], time.Since(start))
+					break
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	all := slices.Concat(latencies...)
+	slices.Sort(all)
+	percentile := func(p float64) time.Duration {
+		if len(all) == 0 {
+			return 0
+		}
+		return all[int(float64(len(all)-1)*p)]
+	}
+
+	fmt.Printf("sent %d, received %d, timeouts %d in %s\n", sent.Load(), received.Load(), timeouts.Load(), duration)
+	if duration <= 0 {
+		return
+	}
+	fmt.Printf("%.0f queries/s  p50 %s  p99 %s  max %s\n",
+		float64(received.Load())/duration.Seconds(), percentile(0.5), percentile(0.99), percentile(1))
+}
@@ -3,9 +3,6 @@ package dns
 import (
 	"encoding/binary"
 	"fmt"
-	"slices"
-	"strconv"
-	"strings"
 )
 
 /*
@@ -32,187 +29,149 @@ type Answer struct {
 		Question: why do they use signed 32 bit if it only accepts positive values?
 	*/
 	TTL int32
-	// an unsigned 16 bit integer that specifies the length in octets of the RDATA field.
-	RDLENGTH uint16
 	// a variable length string of octets that describes the resource.  The format of this information varies according to the TYPE and CLASS of the resource record.
-	// for an A record would be a 4byte ipv4 address
-	RDATA string
-	// Makes compression easier as NAME and RDATA has a dynamic size
-	Size int
-	// Should compress domain name
-	Compress bool
+	// for an A record would be a 4byte ipv4 address, see { rdata.go }
+	// RDLENGTH (an unsigned 16 bit integer that specifies the length in octets of the RDATA field) is computed when encoding.
+	// nil means an empty RDATA (RDLENGTH 0)
+	RDATA RData
 }
 
-// Useful for debugging and testing, but the client will never send an answer...
-func DecodeAnswers(payload []byte, questions []*Question, ancount int) ([]Answer, error) {
-	answers := make([]Answer, ancount)
+// the fixed part of a resource record, before interpreting TYPE, CLASS and TTL
+type recordHeader struct {
+	name     string
+	rtype    uint16
+	class    uint16
+	ttl      uint32
+	rdlength uint16
+}
 
-	for i := range ancount {
-		previousPayloadOffset := sumAnswerPayloadOffsetUntilIdx(answers, questions, i)
-		startPos := previousPayloadOffset
+func decodeRecordHeader(r *reader) (recordHeader, error) {
+	var h recordHeader
+	var err error
+	if h.name, err = r.name(); err != nil {
+		return h, fmt.Errorf("failed to decoded the domain, cause: %w", err)
+	}
+	if h.rtype, err = r.uint16(); err != nil {
+		return h, err
+	}
+	if h.class, err = r.uint16(); err != nil {
+		return h, err
+	}
+	if h.ttl, err = r.uint32(); err != nil {
+		return h, err
+	}
+	if h.rdlength, err = r.uint16(); err != nil {
+		return h, err
+	}
+	return h, nil
+}
 
-		domain, domainSize, useCompression, err := decodeDomainName(payload, startPos)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decoded the domain, cause: %s", err)
-		}
+func decodeAnswer(r *reader) (Answer, error) {
+	h, err := decodeRecordHeader(r)
+	if err != nil {
+		return Answer{}, err
+	}
+	return decodeAnswerBody(r, h)
+}
 
-		typePosition := previousPayloadOffset + domainSize
-		ttype, err := getRecordTypeString(binary.BigEndian.Uint16(payload[typePosition:]))
-		if err != nil {
-			return nil, fmt.Errorf("could not parse the QTYPE, cause: %s", err)
-		}
+// decodeAnswerBody reads the RDATA of a record whose fixed part was already read
+func decodeAnswerBody(r *reader, h recordHeader) (Answer, error) {
+	ttype := getRecordTypeString(h.rtype)
 
-		classPosition := previousPayloadOffset + domainSize + 2
-		class, err := getRecordClassString(binary.BigEndian.Uint16(payload[classPosition:]))
-		if err != nil {
-			return nil, fmt.Errorf("could not parse the QCLASS, cause: %s", err)
-		}
+	ttl := h.ttl
+	// RFC 2181 section 8: a TTL with the most significant bit set is treated as zero
+	if ttl>>31 == 1 {
+		ttl = 0
+	}
 
-		ttlPosition := previousPayloadOffset + domainSize + 4
-		ttl := binary.BigEndian.Uint32(payload[ttlPosition:])
-
-		rdlengthPosition := previousPayloadOffset + domainSize + 8
-		rdlength := binary.BigEndian.Uint16(payload[rdlengthPosition:])
-
-		rdataPosition := previousPayloadOffset + domainSize + 10
-		endRdataPosition := rdataPosition + int(rdlength)
-		rdata := decodeData(payload[rdataPosition:endRdataPosition], int(rdlength))
-
-		answers[i] = Answer{
-			NAME:     domain,
-			TYPE:     ttype,
-			CLASS:    class,
-			TTL:      int32(ttl),
-			RDLENGTH: rdlength,
-			RDATA:    rdata,
-			Size:     domainSize + 2 + 2 + 4 + 2 + 4,
-			Compress: useCompression,
-		}
+	rdata, err := decodeRData(r, ttype, int(h.rdlength))
+	if err != nil {
+		return Answer{}, fmt.Errorf("failed to decode the %s RDATA, cause: %w", ttype, err)
 	}
-	return answers, nil
+
+	return Answer{
+		NAME:  h.name,
+		TYPE:  ttype,
+		CLASS: getRecordClassString(h.class),
+		TTL:   int32(ttl),
+		RDATA: rdata,
+	}, nil
 }
 
-func EncodeAnswers(answers []Answer, questions []*Question) ([]byte, error) {
-	var buf []byte
-	for i, answer := range answers {
-		if answer.Compress {
-			filteredAnswers := filterIndexOut(answers, i)
-			parentAnswerIdx := slices.IndexFunc(filteredAnswers, func(a Answer) bool {
-				return strings.Contains(a.NAME, answer.NAME)
-			})
-			parentAnswer := answers[parentAnswerIdx]
-
-			pointerOffset := findCompressionPointerOffset(
-				strings.SplitSeq(parentAnswer.NAME, "."),
-				strings.Split(answer.NAME, "."),
-				sumAnswerPayloadOffsetUntilIdx(answers, questions, parentAnswerIdx),
-			)
-
-			// set 2 first bits to 1 as the flag to identify a compression pointer
-			pointerOffset |= 1 << 7
-			pointerOffset |= 1 << 6
-
-			b, err := answer.EncodeAnswer([]byte{byte(pointerOffset)})
-			if err != nil {
-				return []byte{}, err
-			}
-
-			buf = append(buf, b...)
-			continue
-		}
-		encoded, err := answer.EncodeAnswer([]byte{})
-		if err != nil {
-			return []byte{}, err
-		}
-		buf = append(buf, encoded...)
+// Len returns the size of the record on the wire without compression,
+// an upper bound of what it takes in a message
+func (a *Answer) Len() int {
+	size := len(Fqdn(a.NAME)) + 1 + 10
+	if a.NAME == "." || a.NAME == "" {
+		size = 1 + 10
 	}
-
-	return buf, nil
+	if a.RDATA != nil {
+		rdata, _ := packRData(a.RDATA)
+		size += len(rdata)
+	}
+	return size
 }
 
-func (a *Answer) EncodeAnswer(compressedDomain []byte) ([]byte, error) {
-	var buf []byte
-	domainSize := 0
+// NAME is compressed against every name already written in the message
+func (a *Answer) encode(e *encoder) error {
 	// DOMAIN
-	if len(compressedDomain) > 0 {
-		buf = compressedDomain
-		domainSize = 1
-	} else {
-		b, err := encodeDomainName(a.NAME)
-		if err != nil {
-			return buf, err
-		}
-		domainSize = len(b)
-		buf = b
+	if err := e.writeName(a.NAME, true); err != nil {
+		return err
 	}
 
-	fmt.Println("encoded domainsize: ", domainSize)
-
 	// TYPE
 	recordType, err := getRecordTypeUint16(a.TYPE)
 	if err != nil {
-		return []byte{}, err
+		return err
 	}
-	buf = binary.BigEndian.AppendUint16(buf, recordType)
+	e.writeUint16(recordType)
 
 	// CLASS
 	recordClass, err := getRecordClassUint16(a.CLASS)
 	if err != nil {
-		return []byte{}, err
+		return err
 	}
-	buf = binary.BigEndian.AppendUint16(buf, recordClass)
+	e.writeUint16(recordClass)
 
 	// TTL
-	// not sure about this, this need a signed 32 bit, but the sign should allways be positive (think in binary level can just change the LSB to 1)
-	uintTTL := uint32(a.TTL)
-	uintTTL |= 1 << 31
-	buf = binary.BigEndian.AppendUint32(buf, uint32(a.TTL))
+	e.writeUint32(uint32(a.TTL))
 
-	// RDLENGTH
-	buf = binary.BigEndian.AppendUint16(buf, a.RDLENGTH)
+	// RDLENGTH, written after the RDATA as names inside it may be compressed
+	lengthPosition := len(e.buf)
+	e.writeUint16(0)
 
 	// RDATA
-	rdata, err := a.buildData()
-	if err != nil {
-		return []byte{}, err
+	if a.RDATA != nil {
+		if err := a.RDATA.pack(e); err != nil {
+			return err
+		}
+	}
+	rdlength := len(e.buf) - lengthPosition - 2
+	if rdlength > 0xFFFF {
+		return fmt.Errorf("the RDATA has %d octets, more than RDLENGTH can represent", rdlength)
 	}
-	buf = append(buf, rdata...)
+	binary.BigEndian.PutUint16(e.buf[lengthPosition:], uint16(rdlength))
 
-	return buf, nil
+	return nil
 }
 
-// just checks if the size matches
-func (a *Answer) buildData() ([]byte, error) {
-	buf, err := bytesFromIPAdress(a.RDATA)
-	if err != nil {
-		return []byte{}, err
+func decodeRData(r *reader, recordType string, length int) (RData, error) {
+	if r.remaining() < length {
+		return nil, ErrTruncated
 	}
-
-	if len(buf) > int(a.RDLENGTH) {
-		return []byte{}, fmt.Errorf("the data section has a bigger size than the specified RDLENGTH")
+	// empty RDATA is used by UPDATE messages to delete records
+	if length == 0 {
+		return nil, nil
 	}
 
-	return buf, nil
-}
-
-func decodeData(buf []byte, length int) string {
-	str := ""
-	for i := range length {
-		str += strconv.Itoa(int(buf[i]))
-		if i < length-1 {
-			str += "."
-		}
+	rdata := newRData(recordType)
+	end := r.off + length
+	if err := rdata.unpack(r, length); err != nil {
+		return nil, err
 	}
-
-	return str
-}
-
-func sumAnswerPayloadOffsetUntilIdx(answers []Answer, questions []*Question, idx int) int {
-	questionsSize := sumQuestionPayloadOffsetUntilIdx(questions, len(questions))
-	s := 0
-	for i := range idx {
-		s += answers[i].Size
+	if r.off != end {
+		return nil, ErrBadRdata
 	}
 
-	return s + questionsSize
+	return rdata, nil
 }
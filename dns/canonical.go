@@ -0,0 +1,49 @@
+package dns
+
+import (
+	"bytes"
+	"cmp"
+	"strings"
+)
+
+// CompareNames orders names canonically (RFC 4034 section 6.1): label by label starting from
+// the rightmost one, each compared as lower case octets, so a name sorts right after its parent
+func CompareNames(a string, b string) int {
+	labelsA := canonicalLabels(a)
+	labelsB := canonicalLabels(b)
+	for i := 0; i < len(labelsA) && i < len(labelsB); i++ {
+		if c := strings.Compare(labelsA[len(labelsA)-1-i], labelsB[len(labelsB)-1-i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(labelsA), len(labelsB))
+}
+
+func canonicalLabels(name string) []string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, ".")
+}
+
+// CompareRecords orders records canonically: by owner name, then by TYPE code and
+// then by the RDATA octets (RFC 4034 section 6.3)
+func CompareRecords(a Answer, b Answer) int {
+	if c := CompareNames(a.NAME, b.NAME); c != 0 {
+		return c
+	}
+	typeA, _ := getRecordTypeUint16(a.TYPE)
+	typeB, _ := getRecordTypeUint16(b.TYPE)
+	if c := cmp.Compare(typeA, typeB); c != 0 {
+		return c
+	}
+	var wireA, wireB []byte
+	if a.RDATA != nil {
+		wireA, _ = packRData(a.RDATA)
+	}
+	if b.RDATA != nil {
+		wireB, _ = packRData(b.RDATA)
+	}
+	return bytes.Compare(wireA, wireB)
+}
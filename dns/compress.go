@@ -0,0 +1,96 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// a compression pointer has 14 bits to address the message, anything written after it can't be a target
+const maxPointerOffset = 0x3FFF
+
+// encoder builds the whole message in a single buffer and remembers the offset of every
+// domain name suffix written so far, so any later name sharing a suffix is written as
+// its remaining labels followed by a pointer (RFC 1035 section 4.1.4), e.g. with google.com
+// already in the message something.google.com becomes \x09something\xC0\x0C
+type encoder struct {
+	buf []byte
+	// lower case suffix (without the final dot) -> offset in buf, nil disables compression
+	names map[string]int
+}
+
+func newEncoder(header []byte) *encoder {
+	buf := make([]byte, 0, 512)
+	buf = append(buf, header...)
+	return &encoder{buf: buf, names: map[string]int{}}
+}
+
+// writeName encodes a domain name, compress=false still registers the suffixes as
+// targets but never emits a pointer (needed for RDATA of types that must not be compressed)
+func (e *encoder) writeName(name string, compress bool) error {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		e.buf = append(e.buf, 0x00)
+		return nil
+	}
+
+	labels := strings.Split(name, ".")
+	if err := validateLabels(name, labels); err != nil {
+		return err
+	}
+
+	for i, label := range labels {
+		suffix := strings.ToLower(strings.Join(labels[i:], "."))
+		if offset, ok := e.names[suffix]; ok && compress {
+			// set 2 first bits to 1 as the flag to identify a compression pointer
+			e.buf = binary.BigEndian.AppendUint16(e.buf, 0xC000|uint16(offset))
+			return nil
+		}
+
+		if _, ok := e.names[suffix]; !ok && e.names != nil && len(e.buf) <= maxPointerOffset {
+			e.names[suffix] = len(e.buf)
+		}
+		e.buf = append(e.buf, byte(len(label)))
+		e.buf = append(e.buf, label...)
+	}
+
+	e.buf = append(e.buf, 0x00)
+	return nil
+}
+
+// <character-string> is a single length octet followed by that number of characters
+func (e *encoder) writeCharacterString(s string) error {
+	if len(s) > 255 {
+		return fmt.Errorf("the character string %q has more than 255 octets", s)
+	}
+	e.buf = append(e.buf, byte(len(s)))
+	e.buf = append(e.buf, s...)
+	return nil
+}
+
+func (e *encoder) writeUint16(v uint16) {
+	e.buf = binary.BigEndian.AppendUint16(e.buf, v)
+}
+
+func (e *encoder) writeUint32(v uint32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, v)
+}
+
+// DNS labels have a 63 octets limit and names have a 255 octets limit (counting length bytes and the null byte)
+func validateLabels(name string, labels []string) error {
+	size := 1
+	for _, label := range labels {
+		if len(label) == 0 {
+			return fmt.Errorf("the domain %s has an empty label", name)
+		}
+		if len(label) > 63 {
+			return fmt.Errorf("the domain part %s has more than 63 octets", label)
+		}
+		size += len(label) + 1
+	}
+	if size > 255 {
+		return fmt.Errorf("the domain %s has more than 255 octets", name)
+	}
+
+	return nil
+}
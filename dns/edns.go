@@ -0,0 +1,124 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+)
+
+// the OPT pseudo-record type code (RFC 6891)
+const optType = 41
+
+// EDNS(0) option codes (https://www.iana.org/assignments/dns-parameters)
+const (
+	EDNSOptionNSID          = 3
+	EDNSOptionClientSubnet  = 8
+	EDNSOptionCookie        = 10
+	EDNSOptionTCPKeepalive  = 11
+	EDNSOptionPadding       = 12
+	EDNSOptionExtendedError = 15
+)
+
+// ErrMultipleOPT is returned when a message carries more than one OPT record, which makes it malformed
+var ErrMultipleOPT = errors.New("message has more than one OPT record")
+
+// EDNS is the content of the OPT pseudo-record (RFC 6891 section 6.1).
+// it lives outside the additional section in Message, the encoder appends it there.
+// The extended RCODE (upper 8 bits of the 12 bit RCODE) is merged into Header.RCODE
+type EDNS struct {
+	// requestor's UDP payload size, carried in the OPT CLASS
+	UDPSIZE uint16
+	// EDNS version, only 0 is defined
+	VERSION uint8
+	// DNSSEC OK bit (RFC 3225)
+	DO bool
+	// remaining 15 flag bits, must be zero
+	Z       uint16
+	OPTIONS []EDNSOption
+}
+
+// EDNSOption is a single {attribute, value} pair of the OPT RDATA
+type EDNSOption struct {
+	CODE uint16
+	DATA []byte
+}
+
+// Option returns the first option with the code, or nil
+func (e *EDNS) Option(code uint16) *EDNSOption {
+	for i := range e.OPTIONS {
+		if e.OPTIONS[i].CODE == code {
+			return &e.OPTIONS[i]
+		}
+	}
+	return nil
+}
+
+// decodeEDNS interprets the OPT record fields, the RDATA is read from r.
+// returns the extended RCODE bits as well
+func decodeEDNS(r *reader, h recordHeader) (*EDNS, uint16, error) {
+	if h.name != "." {
+		return nil, 0, fmt.Errorf("the OPT record must be owned by the root, got %s", h.name)
+	}
+	if r.remaining() < int(h.rdlength) {
+		return nil, 0, ErrTruncated
+	}
+
+	edns := &EDNS{
+		UDPSIZE: h.class,
+		VERSION: uint8(h.ttl >> 16),
+		DO:      (h.ttl>>15)&1 == 1,
+		Z:       uint16(h.ttl & 0x7FFF),
+	}
+	extendedRcode := uint16(h.ttl >> 24)
+
+	end := r.off + int(h.rdlength)
+	for r.off < end {
+		code, err := r.uint16()
+		if err != nil {
+			return nil, 0, err
+		}
+		length, err := r.uint16()
+		if err != nil {
+			return nil, 0, err
+		}
+		data, err := r.bytes(int(length))
+		if err != nil {
+			return nil, 0, err
+		}
+		edns.OPTIONS = append(edns.OPTIONS, EDNSOption{CODE: code, DATA: data})
+	}
+	if r.off != end {
+		return nil, 0, ErrBadRdata
+	}
+
+	return edns, extendedRcode, nil
+}
+
+// encode writes the OPT record, rcode is the full 12 bit RCODE from the header
+func (edns *EDNS) encode(e *encoder, rcode uint16) error {
+	// the root name
+	e.buf = append(e.buf, 0x00)
+	e.writeUint16(optType)
+	e.writeUint16(edns.UDPSIZE)
+
+	ttl := uint32(rcode>>4&0xFF)<<24 | uint32(edns.VERSION)<<16 | uint32(edns.Z&0x7FFF)
+	if edns.DO {
+		ttl |= 1 << 15
+	}
+	e.writeUint32(ttl)
+
+	size := 0
+	for _, option := range edns.OPTIONS {
+		size += 4 + len(option.DATA)
+	}
+	if size > 0xFFFF {
+		return fmt.Errorf("the OPT options have %d octets, more than RDLENGTH can represent", size)
+	}
+	e.writeUint16(uint16(size))
+	for _, option := range edns.OPTIONS {
+		e.writeUint16(option.CODE)
+		e.writeUint16(uint16(len(option.DATA)))
+		e.buf = append(e.buf, option.DATA...)
+	}
+
+	return nil
+}
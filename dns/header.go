@@ -8,6 +8,41 @@ import (
 // Header is always 12 bytes long (BigEndian encoding)
 type EndodedHeader = [12]byte
 
+// Response codes (RCODE)
+const (
+	RcodeNoError  = 0
+	RcodeFormErr  = 1
+	RcodeServFail = 2
+	RcodeNXDomain = 3
+	RcodeNotImp   = 4
+	RcodeRefused  = 5
+	// name exists when it should not (RFC 2136, RFC 6672)
+	RcodeYXDomain = 6
+	// RRset exists when it should not (RFC 2136)
+	RcodeYXRRSet = 7
+	// RRset that should exist does not (RFC 2136)
+	RcodeNXRRSet = 8
+	// the server is not authoritative for the zone (RFC 2136, RFC 5936)
+	RcodeNotAuth = 9
+	// name not contained in the zone (RFC 2136)
+	RcodeNotZone = 10
+	// extended RCODEs, only representable with EDNS (RFC 6891)
+	RcodeBadVers = 16
+	// TSIG errors, carried in the ERROR field of the TSIG record (RFC 8945 section 3)
+	RcodeBadSig  = 16
+	RcodeBadKey  = 17
+	RcodeBadTime = 18
+)
+
+// Operation codes (OPCODE)
+const (
+	OpcodeQuery = 0
+	// zone change notification (RFC 1996)
+	OpcodeNotify = 4
+	// dynamic update (RFC 2136)
+	OpcodeUpdate = 5
+)
+
 type Header struct {
 	// Packet identifier (16 bits)
 	ID uint16
@@ -25,7 +60,7 @@ type Header struct {
 	RA bool
 	// Reserved (3 bits)
 	Z uint16
-	// Response Code (4 bits)
+	// Response Code (4 bits), holds the full 12 bits when the message has EDNS
 	RCODE uint16
 	// Question Count (16 bits)
 	QDCOUNT uint16
@@ -38,6 +73,14 @@ type Header struct {
 }
 
 func DecodeHeader(payload []byte) (Header, error) {
+	return decodeHeader(&reader{buf: payload})
+}
+
+func decodeHeader(r *reader) (Header, error) {
+	if r.remaining() < len(EndodedHeader{}) {
+		return Header{}, ErrTruncated
+	}
+	payload := r.buf[r.off:]
 	header := Header{
 		ID:      binary.BigEndian.Uint16(payload),
 		QDCOUNT: binary.BigEndian.Uint16(payload[4:6]),
@@ -46,6 +89,7 @@ func DecodeHeader(payload []byte) (Header, error) {
 		ARCOUNT: binary.BigEndian.Uint16(payload[10:12]),
 	}
 	header.decodeFlags(payload[2:4])
+	r.off += len(EndodedHeader{})
 	return header, nil
 }
 
@@ -75,15 +119,15 @@ func (h *Header) decodeFlags(flags []byte) {
 	// OPCODE (4bit)
 	h.OPCODE = (num >> 11 & 0xF)
 	// AA (1bit)
-	h.AA = num>>10 == 1
+	h.AA = (num>>10)&1 == 1
 	// TC (1bit)
-	h.TC = num>>9 == 1
+	h.TC = (num>>9)&1 == 1
 	// RD (1bit)
-	h.RD = num>>8 == 1
+	h.RD = (num>>8)&1 == 1
 	// RA (1bit)
-	h.RA = num>>7 == 1
+	h.RA = (num>>7)&1 == 1
 	// Z (3 bits)
-	h.Z = (num >> 4 & 0x8)
+	h.Z = (num >> 4 & 0x7)
 	// RCODE (4 bits)
 	h.RCODE = (num & 0xF)
 }
@@ -117,8 +161,8 @@ func (h *Header) encodeFlags() (uint16, error) {
 	}
 
 	// Set Reserved (Z)
-	// Only from 0-8 (3 bits)
-	flags |= uint16(h.Z&0x8) << 4
+	// Only from 0-7 (3 bits)
+	flags |= uint16(h.Z&0x7) << 4
 
 	// Set Response code (RCODE)
 	// Only from 0-15 (4 bits)
@@ -1,79 +1,138 @@
 package dns
 
 import (
-	"encoding/hex"
 	"fmt"
 )
 
+// Message is a DNS message. UPDATE messages (RFC 2136 section 2) keep the same layout with other
+// meanings: Questions holds the zone, Answers the prerequisites and Authority the updates
 type Message struct {
 	Header    Header
 	Questions []*Question
 	Answers   []Answer
+	// RRs pointing toward an authority (NS referrals, SOA for negative answers)
+	Authority []Answer
+	// RRs which relate to the query but are not strictly answers for it (glue)
+	Additional []Answer
+	// the OPT pseudo-record of the additional section, nil when the message doesn't use EDNS(0)
+	EDNS *EDNS
 }
 
+// DecodeMessage never panics on malformed payloads, every problem is reported
+// as an error wrapping one of the Err* values from reader.go
 func DecodeMessage(payload []byte) (*Message, error) {
+	r := &reader{buf: payload}
 	message := &Message{}
-	decodedHeader, err := DecodeHeader(payload)
+
+	decodedHeader, err := decodeHeader(r)
 	if err != nil {
 		return message, err
 	}
 	message.Header = decodedHeader
-	fmt.Printf("decoded header %v\n", decodedHeader)
 
-	decodedQuestions, err := DecodeQuestions(payload, int(decodedHeader.QDCOUNT))
-	if err != nil {
-		return message, err
+	for i := range int(decodedHeader.QDCOUNT) {
+		question, err := decodeQuestion(r)
+		if err != nil {
+			return message, fmt.Errorf("failed to decode question %d, cause: %w", i, err)
+		}
+		message.Questions = append(message.Questions, question)
 	}
-	message.Questions = decodedQuestions
-	fmt.Println("decoded questions ", decodedQuestions)
 
-	// FIXME: handle multiple and fix start size from multiple questions
-	decodedAnswers, err := DecodeAnswers(payload, decodedQuestions, int(decodedHeader.ANCOUNT))
-	if err != nil {
-		return message, err
+	sections := []struct {
+		name    string
+		count   uint16
+		records *[]Answer
+	}{
+		{"answer", decodedHeader.ANCOUNT, &message.Answers},
+		{"authority", decodedHeader.NSCOUNT, &message.Authority},
+		{"additional", decodedHeader.ARCOUNT, &message.Additional},
+	}
+	for _, section := range sections {
+		for i := range int(section.count) {
+			h, err := decodeRecordHeader(r)
+			if err != nil {
+				return message, fmt.Errorf("failed to decode %s record %d, cause: %w", section.name, i, err)
+			}
+
+			if h.rtype == optType && section.records == &message.Additional {
+				if message.EDNS != nil {
+					return message, ErrMultipleOPT
+				}
+				edns, extendedRcode, err := decodeEDNS(r, h)
+				if err != nil {
+					return message, fmt.Errorf("failed to decode the OPT record, cause: %w", err)
+				}
+				message.EDNS = edns
+				message.Header.RCODE |= extendedRcode << 4
+				continue
+			}
+
+			record, err := decodeAnswerBody(r, h)
+			if err != nil {
+				return message, fmt.Errorf("failed to decode %s record %d, cause: %w", section.name, i, err)
+			}
+			*section.records = append(*section.records, record)
+		}
 	}
-	fmt.Println("decoded answers ", decodedAnswers)
-	message.Answers = decodedAnswers
 
 	return message, nil
 }
 
+// EncodeMessage builds the wire format of the message,
+// every domain name is compressed against the names written before it.
+// The header counts are always derived from the sections, whatever they were set to
 func (m *Message) EncodeMessage() ([]byte, error) {
-	m.Print()
-	buf, err := m.Header.EncodeHeader()
-	if err != nil {
+	if err := m.updateCounts(); err != nil {
 		return []byte{}, err
 	}
-	// fmt.Println("header: ", buf)
-	fmt.Println("header encoded: ", hex.EncodeToString(buf))
-
-	questions, err := EncodeQuestions(m.Questions)
+	if m.Header.RCODE > 0xF && m.EDNS == nil {
+		return []byte{}, fmt.Errorf("the extended RCODE %d can only be sent with EDNS", m.Header.RCODE)
+	}
+	header, err := m.Header.EncodeHeader()
 	if err != nil {
 		return []byte{}, err
 	}
-	fmt.Println("questions after beeing encoded: ", m.Questions[0], m.Questions[1])
-	// fmt.Println("question: ", question)
-	fmt.Println("questions encoded: ", hex.EncodeToString(questions))
-	buf = append(buf, questions...)
+	e := newEncoder(header)
 
-	answers, err := EncodeAnswers(m.Answers, m.Questions)
-	if err != nil {
-		return []byte{}, err
+	for _, question := range m.Questions {
+		if err := question.encode(e); err != nil {
+			return []byte{}, fmt.Errorf("failed to encode question %s, cause: %w", question.QNAME, err)
+		}
+	}
+
+	for _, section := range [][]Answer{m.Answers, m.Authority, m.Additional} {
+		for i := range section {
+			if err := section[i].encode(e); err != nil {
+				return []byte{}, fmt.Errorf("failed to encode record %s %s, cause: %w", section[i].NAME, section[i].TYPE, err)
+			}
+		}
+	}
+
+	if m.EDNS != nil {
+		if err := m.EDNS.encode(e, m.Header.RCODE); err != nil {
+			return []byte{}, err
+		}
 	}
-	fmt.Println("answers encoded: ", hex.EncodeToString(answers))
-	// fmt.Println("answer: ", answer)
-	// fmt.Println("answer encoded: ", hex.EncodeToString(answer))
-	buf = append(buf, answers...)
 
-	fmt.Println("full message: ", hex.EncodeToString(buf))
-	return buf, nil
+	return e.buf, nil
 }
 
-func (m *Message) Print() {
-	questions := make([]Question, len(m.Questions))
-	for i := range len(m.Questions) {
-		questions[i] = *m.Questions[i]
+func (m *Message) updateCounts() error {
+	for _, count := range []int{len(m.Questions), len(m.Answers), len(m.Authority), len(m.Additional) + 1} {
+		if count > 0xFFFF {
+			return fmt.Errorf("a section has %d entries, more than the header count can represent", count)
+		}
+	}
+	m.Header.QDCOUNT = uint16(len(m.Questions))
+	m.Header.ANCOUNT = uint16(len(m.Answers))
+	m.Header.NSCOUNT = uint16(len(m.Authority))
+	m.Header.ARCOUNT = uint16(len(m.Additional))
+	if m.EDNS != nil {
+		m.Header.ARCOUNT++
 	}
+	return nil
+}
 
-	fmt.Printf("message: \n header: %v\n questions: %v\n answers: %v\n\n", m.Header, questions, m.Answers)
+func (m *Message) Print() {
+	fmt.Println(m.String())
 }
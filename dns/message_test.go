@@ -0,0 +1,62 @@
+package dns
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// a response with a bit of everything, so compression and the RDATA types are exercised
+func sampleResponse() *Message {
+	m := &Message{
+		Header:    Header{ID: 1, QR: true, RD: true, RA: true},
+		Questions: []*Question{{QNAME: "www.example.com.", QTYPE: "A", QCLASS: "IN"}},
+		Answers: []Answer{
+			{NAME: "www.example.com.", TYPE: "CNAME", CLASS: "IN", TTL: 300, RDATA: &CNAME{CNAME: "web.example.com."}},
+		},
+		Authority: []Answer{
+			{NAME: "example.com.", TYPE: "NS", CLASS: "IN", TTL: 3600, RDATA: &NS{NSDNAME: "ns1.example.com."}},
+			{NAME: "example.com.", TYPE: "NS", CLASS: "IN", TTL: 3600, RDATA: &NS{NSDNAME: "ns2.example.com."}},
+		},
+		Additional: []Answer{
+			{NAME: "ns1.example.com.", TYPE: "A", CLASS: "IN", TTL: 3600, RDATA: &A{ADDRESS: netip.MustParseAddr("192.0.2.1")}},
+			{NAME: "ns2.example.com.", TYPE: "AAAA", CLASS: "IN", TTL: 3600, RDATA: &AAAA{ADDRESS: netip.MustParseAddr("2001:db8::2")}},
+		},
+		EDNS: &EDNS{UDPSIZE: 1232},
+	}
+	for i := range 4 {
+		m.Answers = append(m.Answers, Answer{
+			NAME: "web.example.com.", TYPE: "A", CLASS: "IN", TTL: 300,
+			RDATA: &A{ADDRESS: netip.AddrFrom4([4]byte{192, 0, 2, byte(10 + i)})},
+		})
+	}
+	return m
+}
+
+func BenchmarkEncodeMessage(b *testing.B) {
+	m := sampleResponse()
+	encoded, err := m.EncodeMessage()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(encoded)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := m.EncodeMessage(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeMessage(b *testing.B) {
+	encoded, err := sampleResponse().EncodeMessage()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(encoded)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := DecodeMessage(encoded); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
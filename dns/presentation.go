@@ -0,0 +1,222 @@
+package dns
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Helpers for the presentation format (RFC 1035 section 5.1), the textual form used by
+// zone files. Fields are the whitespace separated tokens of a record, quoted strings keep
+// their quotes and escape sequences (\X and \DDD) are kept as written until a field is parsed.
+
+// ParseRData parses the presentation format of the RDATA of a record type,
+// relative names are completed with origin.
+// The RFC 3597 generic format (\# <length> <hex>) is accepted for any type,
+// known types are then decoded from those octets as if they came from the wire
+func ParseRData(recordType string, fields []string, origin string) (RData, error) {
+	rdata := newRData(recordType)
+
+	if len(fields) > 0 && fields[0] == "\\#" {
+		data, err := parseGenericData(fields)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s RDATA %q, cause: %w", recordType, strings.Join(fields, " "), err)
+		}
+		r := &reader{buf: data}
+		if err := rdata.unpack(r, len(data)); err != nil {
+			return nil, fmt.Errorf("invalid %s generic RDATA, cause: %w", recordType, err)
+		}
+		if r.remaining() != 0 {
+			return nil, fmt.Errorf("invalid %s generic RDATA, cause: %w", recordType, ErrBadRdata)
+		}
+		return rdata, nil
+	}
+
+	if err := rdata.parse(fields, origin); err != nil {
+		return nil, fmt.Errorf("invalid %s RDATA %q, cause: %w", recordType, strings.Join(fields, " "), err)
+	}
+	return rdata, nil
+}
+
+// ParseName turns a presentation name into a fully qualified one,
+// "@" is the origin itself and names without the final dot are relative to the origin
+func ParseName(field string, origin string) (string, error) {
+	if field == "@" {
+		if origin == "" {
+			return "", fmt.Errorf("@ used without an origin")
+		}
+		return Fqdn(origin), nil
+	}
+	if err := checkLabelEscapes(field); err != nil {
+		return "", err
+	}
+
+	name, err := unescape(field)
+	if err != nil {
+		return "", err
+	}
+	if name == "." {
+		return name, nil
+	}
+	if strings.HasSuffix(name, ".") || origin == "" {
+		return Fqdn(name), nil
+	}
+	if origin == "." {
+		return name + ".", nil
+	}
+	return name + "." + Fqdn(origin), nil
+}
+
+// checkLabelEscapes rejects the escapes putting a dot or a backslash inside a label, written as
+// \. or \046 and \\ or \092. Names are kept as dotted strings, the label would become several
+// once encoded, so names decoded from the wire can't have them either (see { ErrBadLabel })
+func checkLabelEscapes(field string) error {
+	for i := 0; i < len(field); i++ {
+		if field[i] != '\\' || i+1 >= len(field) {
+			continue
+		}
+		decoded := field[i+1]
+		if i+3 < len(field) && isDigit(field[i+1]) && isDigit(field[i+2]) && isDigit(field[i+3]) {
+			octet, _ := strconv.Atoi(field[i+1 : i+4])
+			decoded = byte(octet)
+			i += 2
+		}
+		if decoded == '.' || decoded == '\\' {
+			return fmt.Errorf("%w: %s", ErrBadLabel, field)
+		}
+		i++
+	}
+	return nil
+}
+
+// parseCharacterString removes the quotes (if any) and resolves the escape sequences of a <character-string>
+func parseCharacterString(field string) (string, error) {
+	if len(field) >= 2 && field[0] == '"' && field[len(field)-1] == '"' {
+		field = field[1 : len(field)-1]
+	}
+	return unescape(field)
+}
+
+// unescape resolves \X (X taken literally) and \DDD (decimal octet) sequences
+func unescape(field string) (string, error) {
+	if !strings.Contains(field, "\\") {
+		return field, nil
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] != '\\' {
+			sb.WriteByte(field[i])
+			continue
+		}
+		if i+1 >= len(field) {
+			return "", fmt.Errorf("dangling escape in %s", field)
+		}
+		if i+3 < len(field) && isDigit(field[i+1]) && isDigit(field[i+2]) && isDigit(field[i+3]) {
+			octet, _ := strconv.Atoi(field[i+1 : i+4])
+			if octet > 255 {
+				return "", fmt.Errorf("invalid escape \\%s in %s", field[i+1:i+4], field)
+			}
+			sb.WriteByte(byte(octet))
+			i += 3
+			continue
+		}
+		sb.WriteByte(field[i+1])
+		i++
+	}
+	return sb.String(), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// quoteCharacterString renders a <character-string> between quotes,
+// escaping quotes and backslashes and using \DDD for non printable octets
+func quoteCharacterString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := range len(s) {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < 0x20 || c > 0x7E:
+			fmt.Fprintf(&sb, "\\%03d", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func parseUint8(field string) (uint8, error) {
+	v, err := strconv.ParseUint(field, 10, 8)
+	return uint8(v), err
+}
+
+func parseUint16(field string) (uint16, error) {
+	v, err := strconv.ParseUint(field, 10, 16)
+	return uint16(v), err
+}
+
+func parseUint32(field string) (uint32, error) {
+	v, err := strconv.ParseUint(field, 10, 32)
+	return uint32(v), err
+}
+
+// ParseTTL accepts plain seconds or the BIND style units (1w2d3h4m5s, case insensitive)
+func ParseTTL(field string) (uint32, error) {
+	if v, err := strconv.ParseUint(field, 10, 32); err == nil {
+		return uint32(v), nil
+	}
+
+	var total, current uint64
+	hasDigits := false
+	for _, c := range strings.ToLower(field) {
+		if c >= '0' && c <= '9' {
+			current = current*10 + uint64(c-'0')
+			hasDigits = true
+			if current > 0xFFFFFFFF {
+				return 0, fmt.Errorf("the TTL %s is too big", field)
+			}
+			continue
+		}
+		if !hasDigits {
+			return 0, fmt.Errorf("invalid TTL %s", field)
+		}
+		switch c {
+		case 's':
+		case 'm':
+			current *= 60
+		case 'h':
+			current *= 60 * 60
+		case 'd':
+			current *= 60 * 60 * 24
+		case 'w':
+			current *= 60 * 60 * 24 * 7
+		default:
+			return 0, fmt.Errorf("invalid TTL unit %c in %s", c, field)
+		}
+		total += current
+		current = 0
+		hasDigits = false
+	}
+	if hasDigits {
+		total += current
+	}
+	if total > 0xFFFFFFFF {
+		return 0, fmt.Errorf("the TTL %s is too big", field)
+	}
+	return uint32(total), nil
+}
+
+// expectFields checks the number of fields of a record with a fixed layout
+func expectFields(fields []string, n int) error {
+	if len(fields) != n {
+		return fmt.Errorf("expected %d fields, got %d", n, len(fields))
+	}
+	return nil
+}
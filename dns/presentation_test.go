@@ -0,0 +1,43 @@
+package dns
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseName(t *testing.T) {
+	tests := []struct {
+		field  string
+		origin string
+		// empty when the name must be rejected
+		name string
+	}{
+		{"www", "example.com.", "www.example.com."},
+		{"www.example.com.", "example.net.", "www.example.com."},
+		{"@", "example.com", "example.com."},
+		{`a\ b`, "example.com.", "a b.example.com."},
+		{`a\098c`, "example.com.", "abc.example.com."},
+		// a dot or a backslash inside a label, however it's escaped
+		{`a\.b`, "example.com.", ""},
+		{`a\046b`, "example.com.", ""},
+		{`a\\b`, "example.com.", ""},
+		{`a\092b`, "example.com.", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.field, func(t *testing.T) {
+			name, err := ParseName(test.field, test.origin)
+			if test.name == "" {
+				if !errors.Is(err, ErrBadLabel) {
+					t.Fatalf("expected %v, got %q and %v", ErrBadLabel, name, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != test.name {
+				t.Fatalf("expected %s, got %s", test.name, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package dns
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Textual rendering of messages in the style of dig, every record is written in its
+// presentation format (RFC 1035 section 5.1) so the sections can be pasted into a zone file
+
+var opcodeNames = map[uint16]string{
+	0: "QUERY",
+	1: "IQUERY",
+	2: "STATUS",
+	4: "NOTIFY",
+	5: "UPDATE",
+}
+
+var rcodeNames = map[uint16]string{
+	0:  "NOERROR",
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+	16: "BADVERS",
+	17: "BADKEY",
+	18: "BADTIME",
+}
+
+var ednsOptionNames = map[uint16]string{
+	EDNSOptionNSID:          "NSID",
+	EDNSOptionClientSubnet:  "CLIENT-SUBNET",
+	EDNSOptionCookie:        "COOKIE",
+	EDNSOptionTCPKeepalive:  "TCP-KEEPALIVE",
+	EDNSOptionPadding:       "PADDING",
+	EDNSOptionExtendedError: "EDE",
+}
+
+// OpcodeString returns the mnemonic of an OPCODE, or its number when it has none
+func OpcodeString(opcode uint16) string {
+	if name, ok := opcodeNames[opcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("OPCODE%d", opcode)
+}
+
+// RcodeString returns the mnemonic of an RCODE, or its number when it has none
+func RcodeString(rcode uint16) string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// presentName renders a fully qualified name, escaping the characters that have a meaning
+// in the presentation format and the non printable octets as \DDD
+func presentName(name string) string {
+	name = Fqdn(name)
+	if name == "." {
+		return name
+	}
+
+	var sb strings.Builder
+	for i := range len(name) {
+		c := name[i]
+		switch {
+		case c == '"' || c == '(' || c == ')' || c == ';' || c == '@' || c == '$' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c <= 0x20 || c > 0x7E:
+			fmt.Fprintf(&sb, "\\%03d", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// String renders the record as a master file line: NAME TTL CLASS TYPE RDATA
+func (a Answer) String() string {
+	// empty RDATA, as used by UPDATE to delete RRsets
+	rdata := genericDataString(nil)
+	if a.RDATA != nil {
+		rdata = a.RDATA.String()
+	}
+	return fmt.Sprintf("%s\t%d\t%s\t%s\t%s", presentName(a.NAME), a.TTL, a.CLASS, a.TYPE, rdata)
+}
+
+// String renders the question as dig does, a commented out record without TTL nor RDATA
+func (q Question) String() string {
+	return fmt.Sprintf(";%s\t\t%s\t%s", presentName(q.QNAME), q.QCLASS, q.QTYPE)
+}
+
+// String renders the OPT pseudo-record as the OPT PSEUDOSECTION of dig
+func (e *EDNS) String() string {
+	var sb strings.Builder
+	flags := ""
+	if e.DO {
+		flags = " do"
+	}
+	fmt.Fprintf(&sb, "; EDNS: version: %d, flags:%s; udp: %d", e.VERSION, flags, e.UDPSIZE)
+	for _, option := range e.OPTIONS {
+		name, ok := ednsOptionNames[option.CODE]
+		if !ok {
+			name = fmt.Sprintf("OPT%d", option.CODE)
+		}
+		fmt.Fprintf(&sb, "\n; %s: %s", name, strings.ToUpper(hex.EncodeToString(option.DATA)))
+	}
+	return sb.String()
+}
+
+// String renders the message like dig: the header with the flags, the OPT pseudo-record and each section
+func (m *Message) String() string {
+	var sb strings.Builder
+
+	h := m.Header
+	fmt.Fprintf(&sb, ";; ->>HEADER<<- opcode: %s, status: %s, id: %d\n", OpcodeString(h.OPCODE), RcodeString(h.RCODE), h.ID)
+
+	var flags []string
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{{h.QR, "qr"}, {h.AA, "aa"}, {h.TC, "tc"}, {h.RD, "rd"}, {h.RA, "ra"}} {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+	additional := len(m.Additional)
+	if m.EDNS != nil {
+		additional++
+	}
+	// UPDATE renames the sections (RFC 2136 section 2)
+	names := [4]string{"QUESTION", "ANSWER", "AUTHORITY", "ADDITIONAL"}
+	counts := [4]string{"QUERY", "ANSWER", "AUTHORITY", "ADDITIONAL"}
+	if h.OPCODE == OpcodeUpdate {
+		names = [4]string{"ZONE", "PREREQUISITE", "UPDATE", "ADDITIONAL"}
+		counts = [4]string{"ZONE", "PREREQ", "UPDATE", "ADDITIONAL"}
+	}
+	fmt.Fprintf(&sb, ";; flags: %s; %s: %d, %s: %d, %s: %d, %s: %d\n", strings.Join(flags, " "),
+		counts[0], len(m.Questions), counts[1], len(m.Answers), counts[2], len(m.Authority), counts[3], additional)
+
+	if m.EDNS != nil {
+		fmt.Fprintf(&sb, "\n;; OPT PSEUDOSECTION:\n%s\n", m.EDNS)
+	}
+
+	if len(m.Questions) > 0 {
+		fmt.Fprintf(&sb, "\n;; %s SECTION:\n", names[0])
+		for _, question := range m.Questions {
+			sb.WriteString(question.String() + "\n")
+		}
+	}
+
+	for _, section := range []struct {
+		name    string
+		records []Answer
+	}{{names[1], m.Answers}, {names[2], m.Authority}, {names[3], m.Additional}} {
+		if len(section.records) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "\n;; %s SECTION:\n", section.name)
+		for _, record := range section.records {
+			sb.WriteString(record.String() + "\n")
+		}
+	}
+
+	return sb.String()
+}
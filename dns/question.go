@@ -1,11 +1,7 @@
 package dns
 
 import (
-	"encoding/binary"
 	"fmt"
-	"iter"
-	"slices"
-	"strings"
 )
 
 /*
@@ -29,8 +25,23 @@ Record types:
 	MX              15 mail exchange
 	TXT             16 text strings
 
+Later additions:
+
+	AAAA            28 an IPv6 host address (RFC 3596)
+	SRV             33 the location of a service (RFC 2782)
+	NAPTR           35 naming authority pointer (RFC 3403)
+	DNAME           39 redirection of a subtree (RFC 6672)
+	OPT             41 EDNS(0) pseudo-record, see { edns.go }
+	SSHFP           44 SSH key fingerprint (RFC 4255)
+	TLSA            52 TLS certificate association (RFC 6698)
+	SVCB            64 general purpose service binding (RFC 9460)
+	HTTPS           65 service binding for HTTPS (RFC 9460)
+	CAA             257 certification authority authorization (RFC 8659)
+	TSIG            250 transaction signature meta record (RFC 8945), see { tsig.go }
+
 QTYPE values (all normal Record types are valid as QTYPEs):
 
+	IXFR            251 A request for an incremental transfer of a zone (RFC 1995)
 	AXFR            252 A request for a transfer of an entire zone
 	MAILB           253 A request for mailbox-related records (MB, MG or MR)
 	MAILA           254 A request for mail agent RRs (Obsolete - see MX)
@@ -43,188 +54,65 @@ Classes:
 	CS              2 the CSNET class (Obsolete - used only for examples in some obsolete RFCs)
 	CH              3 the CHAOS class
 	HS              4 Hesiod [Dyer 87]
+
+QCLASS values:
+
+	NONE            254 used by UPDATE to delete a specific RR (RFC 2136)
+	ANY             255 any class (also written as *)
+
+Types and classes without a mnemonic are written as TYPE<code> and CLASS<code> (RFC 3597)
 */
 type Question struct {
-	// Domain name, see { utils.formatDomainName }
+	// Domain name, see { utils.encodeDomainName }
 	QNAME string
 	// Record type (16 bits)
 	QTYPE string
 	// Class  (16 bits)
 	QCLASS string
-	/**
-	* FIXME: need to try to compress every question, and handle cases that it can't
-	* compress the domain name (need to have QNAME matching previous QNAME questions in the payload context)
-	* the order of the questions will mater as it points to a location in the full payload, it cannot point to the "future",
-	* so to compress need to have matching names before the question
-	 */
-	Compress bool
-	// Useful to facilitate using pointer in the compression
-	DomainNameSize int
-	// Useful to know the boundaries of the question section in the payload as QNAME have dynamic size
-	Size int
-}
-
-func DecodeQuestions(payload []byte, qdcount int) ([]*Question, error) {
-	questions := make([]*Question, qdcount)
-
-	for i := range qdcount {
-		previousPayloadOffset := sumQuestionPayloadOffsetUntilIdx(questions, i)
-		startPos := previousPayloadOffset
-
-		domain, domainSize, useCompression, err := decodeDomainName(payload, startPos)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode domain name, cause: %s", err)
-		}
-
-		typePosition := previousPayloadOffset + domainSize
-		qtype, err := getRecordTypeString(binary.BigEndian.Uint16(payload[typePosition:]))
-		if err != nil {
-			return nil, fmt.Errorf("could not parse the QTYPE, cause: %s", err)
-		}
-
-		classPosition := previousPayloadOffset + domainSize + 2
-		class, err := getRecordClassString(binary.BigEndian.Uint16(payload[classPosition:]))
-		if err != nil {
-			return nil, fmt.Errorf("could not parse the QCLASS, cause: %s", err)
-		}
-
-		questions[i] = &Question{
-			QNAME:          domain,
-			QTYPE:          qtype,
-			QCLASS:         class,
-			DomainNameSize: domainSize,
-			Size:           domainSize + 4,
-			Compress:       useCompression,
-		}
-	}
-
-	return questions, nil
-}
-
-func EncodeQuestions(questions []*Question) ([]byte, error) {
-	var buf []byte
-	for i, question := range questions {
-		// still need to handle the case where it has a sequence of labels, ending with a pointer
-		if (*question).Compress {
-			filteredQuestions := filterIndexOut(questions, i)
-			parentQuestionIdx := slices.IndexFunc(filteredQuestions, func(q *Question) bool {
-				return strings.Contains(q.QNAME, question.QNAME) || strings.Contains(question.QNAME, q.QNAME)
-			})
-
-			parentQuestion := questions[parentQuestionIdx]
-
-			pointerOffset := findCompressionPointerOffset(
-				strings.SplitSeq(parentQuestion.QNAME, "."),
-				strings.Split(question.QNAME, "."),
-				sumQuestionPayloadOffsetUntilIdx(questions, parentQuestionIdx),
-			)
-
-			// set 2 first bits to 1 as the flag to identify a compression pointer
-			pointerOffset |= 1 << 7
-			pointerOffset |= 1 << 6
-
-			compressedBytes, err := handleBiggerCompressionDomainName(parentQuestion.QNAME, question.QNAME, byte(pointerOffset))
-			if err != nil {
-				return []byte{}, fmt.Errorf("failed to handle bigger compression domain, cause: %s", err)
-			}
-
-			b, err := question.EncodeQuestion(compressedBytes)
-			if err != nil {
-				return []byte{}, err
-			}
-
-			question.Size = len(b)
-			buf = append(buf, b...)
-			continue
-		}
-		b, err := question.EncodeQuestion([]byte{})
-		if err != nil {
-			return []byte{}, err
-		}
-		question.Size = len(b)
-		buf = append(buf, b...)
-
-	}
-
-	return buf, nil
 }
 
-// should be private after fix testings
-func (q *Question) EncodeQuestion(compressedQNAME []byte) ([]byte, error) {
-	var buf []byte
-	domainSize := 0
-	if len(compressedQNAME) > 0 {
-		buf = compressedQNAME
-		domainSize = 1
-	} else {
-		b, err := encodeDomainName(q.QNAME)
-		if err != nil {
-			return buf, err
-		}
-		domainSize = len(b)
-		buf = b
-	}
-	q.DomainNameSize = domainSize
-
-	qtype, err := getRecordTypeUint16(q.QTYPE)
+func decodeQuestion(r *reader) (*Question, error) {
+	domain, err := r.name()
 	if err != nil {
-		return buf, err
+		return nil, fmt.Errorf("failed to decode domain name, cause: %w", err)
 	}
-	buf = binary.BigEndian.AppendUint16(buf, qtype)
 
-	qclass, err := getRecordClassUint16(q.QCLASS)
+	rawType, err := r.uint16()
 	if err != nil {
-		return buf, err
+		return nil, err
 	}
-	buf = binary.BigEndian.AppendUint16(buf, qclass)
-
-	return buf, nil
-}
+	qtype := getRecordTypeString(rawType)
 
-// payloadOffset is the offset relative to all the message before the starting point of the parentPayload which contains the domain name
-func findCompressionPointerOffset(splitParent iter.Seq[string], splitCompressing []string, payloadOffset int) int {
-	const lengthByte = 1
-	discardSize := 0
-	for parentPart := range splitParent {
-		for _, compressingPart := range splitCompressing {
-			if parentPart == compressingPart {
-				if discardSize > 0 {
-					return payloadOffset + discardSize
-				}
-				return payloadOffset + discardSize
-			}
-		}
-
-		discardSize += len([]rune(parentPart)) + lengthByte
+	rawClass, err := r.uint16()
+	if err != nil {
+		return nil, err
 	}
+	class := getRecordClassString(rawClass)
 
-	return -1
+	return &Question{
+		QNAME:  domain,
+		QTYPE:  qtype,
+		QCLASS: class,
+	}, nil
 }
 
-// handles the case where the compressed have a bigger domain name than the parent
-// (the pointer it's always in the end, as it will have the null byte)
-func handleBiggerCompressionDomainName(parentDomain string, compressingDomain string, formattedPointer byte) ([]byte, error) {
-	if len([]rune(parentDomain)) >= len([]rune(compressingDomain)) {
-		return []byte{formattedPointer}, nil
+// QNAME is compressed against every name already written in the message
+func (q *Question) encode(e *encoder) error {
+	if err := e.writeName(q.QNAME, true); err != nil {
+		return err
 	}
 
-	remainingPart := strings.ReplaceAll(compressingDomain, parentDomain, "")
-	encodedPart, err := encodeDomainName(remainingPart)
+	qtype, err := getRecordTypeUint16(q.QTYPE)
 	if err != nil {
-		return []byte{}, err
+		return err
 	}
+	e.writeUint16(qtype)
 
-	encodedPart = encodedPart[:len(encodedPart)-1]
-	encodedPart[len(encodedPart)-1] = formattedPointer
-	return encodedPart, nil
-}
-
-func sumQuestionPayloadOffsetUntilIdx(questions []*Question, idx int) int {
-	const headerSize = 12
-	s := 0
-	for i := range idx {
-		s += questions[i].Size
+	qclass, err := getRecordClassUint16(q.QCLASS)
+	if err != nil {
+		return err
 	}
+	e.writeUint16(qclass)
 
-	return s + headerSize
+	return nil
 }
@@ -0,0 +1,781 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// RData is the type specific part of a resource record (RFC 1035 section 3.3),
+// every record type knows how to pack itself into the message and unpack itself from it.
+// RDLENGTH is never stored, it's computed from what pack writes
+type RData interface {
+	// presentation format of the data, as it would appear in a zone file
+	String() string
+	pack(e *encoder) error
+	// length is the RDLENGTH, the reader starts at the first byte of the RDATA
+	unpack(r *reader, length int) error
+	// fields are the presentation tokens, see { ParseRData }
+	parse(fields []string, origin string) error
+}
+
+// newRData returns an empty RData for the record type,
+// types without a known RDATA format are kept opaque (see { Unknown })
+func newRData(recordType string) RData {
+	if canonical, err := ParseType(recordType); err == nil {
+		recordType = canonical
+	}
+
+	switch recordType {
+	case "A":
+		return &A{}
+	case "NS":
+		return &NS{}
+	case "CNAME":
+		return &CNAME{}
+	case "MD":
+		return &MD{}
+	case "MF":
+		return &MF{}
+	case "MB":
+		return &MB{}
+	case "MG":
+		return &MG{}
+	case "MR":
+		return &MR{}
+	case "SOA":
+		return &SOA{}
+	case "NULL":
+		return &NULL{}
+	case "WKS":
+		return &WKS{}
+	case "PTR":
+		return &PTR{}
+	case "HINFO":
+		return &HINFO{}
+	case "MINFO":
+		return &MINFO{}
+	case "MX":
+		return &MX{}
+	case "TXT":
+		return &TXT{}
+	case "AAAA":
+		return &AAAA{}
+	case "SRV":
+		return &SRV{}
+	case "NAPTR":
+		return &NAPTR{}
+	case "DNAME":
+		return &DNAME{}
+	case "SSHFP":
+		return &SSHFP{}
+	case "TLSA":
+		return &TLSA{}
+	case "SVCB":
+		return &SVCB{}
+	case "HTTPS":
+		return &HTTPS{}
+	case "CAA":
+		return &CAA{}
+	case "TSIG":
+		return &TSIG{}
+	default:
+		return &Unknown{}
+	}
+}
+
+// EqualRData reports whether both RDATA have the same wire format,
+// that's how records of the same RRset are told apart (RFC 2181 section 5)
+func EqualRData(a RData, b RData) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	wireA, errA := packRData(a)
+	wireB, errB := packRData(b)
+	return errA == nil && errB == nil && bytes.Equal(wireA, wireB)
+}
+
+// packRData writes the RDATA alone, names are never compressed
+func packRData(rdata RData) ([]byte, error) {
+	e := &encoder{}
+	if err := rdata.pack(e); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// Unknown holds the RDATA of types this package doesn't understand (RFC 3597),
+// it's carried as opaque octets so the record can still be forwarded, cached and served.
+// the names it may contain are never compressed nor decompressed
+type Unknown struct {
+	DATA []byte
+}
+
+func (u *Unknown) String() string {
+	return genericDataString(u.DATA)
+}
+
+func (u *Unknown) pack(e *encoder) error {
+	e.buf = append(e.buf, u.DATA...)
+	return nil
+}
+
+func (u *Unknown) unpack(r *reader, length int) (err error) {
+	u.DATA, err = r.bytes(length)
+	return err
+}
+
+func (u *Unknown) parse(fields []string, origin string) (err error) {
+	u.DATA, err = parseGenericData(fields)
+	return err
+}
+
+// A a host address
+type A struct {
+	// a 32 bit Internet address
+	ADDRESS netip.Addr
+}
+
+func (a *A) String() string {
+	return a.ADDRESS.String()
+}
+
+func (a *A) pack(e *encoder) error {
+	if !a.ADDRESS.Is4() {
+		return fmt.Errorf("the A address %s is not an IPv4 address", a.ADDRESS)
+	}
+	addr := a.ADDRESS.As4()
+	e.buf = append(e.buf, addr[:]...)
+	return nil
+}
+
+func (a *A) unpack(r *reader, length int) error {
+	if length != 4 {
+		return ErrBadRdata
+	}
+	b, err := r.bytes(4)
+	if err != nil {
+		return err
+	}
+	a.ADDRESS = netip.AddrFrom4([4]byte(b))
+	return nil
+}
+
+func (a *A) parse(fields []string, origin string) (err error) {
+	a.ADDRESS, err = parseIPv4(fields)
+	return err
+}
+
+// NS an authoritative name server
+type NS struct {
+	// a host which should be authoritative for the specified class and domain
+	NSDNAME string
+}
+
+func (n *NS) String() string {
+	return presentName(n.NSDNAME)
+}
+
+func (n *NS) pack(e *encoder) error {
+	return e.writeName(n.NSDNAME, true)
+}
+
+func (n *NS) unpack(r *reader, length int) (err error) {
+	n.NSDNAME, err = r.name()
+	return err
+}
+
+func (n *NS) parse(fields []string, origin string) (err error) {
+	n.NSDNAME, err = parseSingleName(fields, origin)
+	return err
+}
+
+// CNAME the canonical name for an alias
+type CNAME struct {
+	// the canonical or primary name for the owner, the owner name is an alias
+	CNAME string
+}
+
+func (c *CNAME) String() string {
+	return presentName(c.CNAME)
+}
+
+func (c *CNAME) pack(e *encoder) error {
+	return e.writeName(c.CNAME, true)
+}
+
+func (c *CNAME) unpack(r *reader, length int) (err error) {
+	c.CNAME, err = r.name()
+	return err
+}
+
+func (c *CNAME) parse(fields []string, origin string) (err error) {
+	c.CNAME, err = parseSingleName(fields, origin)
+	return err
+}
+
+// SOA marks the start of a zone of authority
+type SOA struct {
+	// the name server that was the original or primary source of data for this zone
+	MNAME string
+	// the mailbox of the person responsible for this zone
+	RNAME string
+	// version number of the original copy of the zone, wraps around (RFC 1982 serial arithmetic)
+	SERIAL uint32
+	// interval in seconds before the zone should be refreshed
+	REFRESH uint32
+	// interval in seconds that should elapse before a failed refresh should be retried
+	RETRY uint32
+	// upper limit in seconds that can elapse before the zone is no longer authoritative
+	EXPIRE uint32
+	// minimum TTL that should be exported with any RR from this zone, also the negative caching TTL (RFC 2308)
+	MINIMUM uint32
+}
+
+func (s *SOA) String() string {
+	return fmt.Sprintf("%s %s %d %d %d %d %d", presentName(s.MNAME), presentName(s.RNAME), s.SERIAL, s.REFRESH, s.RETRY, s.EXPIRE, s.MINIMUM)
+}
+
+// CompareSerial compares two SERIAL values with the serial number arithmetic of RFC 1982,
+// a serial is greater when it's less than 2^31 ahead of the other one, wrapping around.
+// returns -1, 0 or 1 like cmp.Compare
+func CompareSerial(a uint32, b uint32) int {
+	switch {
+	case a == b:
+		return 0
+	case int32(a-b) > 0:
+		return 1
+	default:
+		return -1
+	}
+}
+
+func (s *SOA) pack(e *encoder) error {
+	if err := e.writeName(s.MNAME, true); err != nil {
+		return err
+	}
+	if err := e.writeName(s.RNAME, true); err != nil {
+		return err
+	}
+	for _, v := range []uint32{s.SERIAL, s.REFRESH, s.RETRY, s.EXPIRE, s.MINIMUM} {
+		e.writeUint32(v)
+	}
+	return nil
+}
+
+func (s *SOA) unpack(r *reader, length int) (err error) {
+	if s.MNAME, err = r.name(); err != nil {
+		return err
+	}
+	if s.RNAME, err = r.name(); err != nil {
+		return err
+	}
+	for _, field := range []*uint32{&s.SERIAL, &s.REFRESH, &s.RETRY, &s.EXPIRE, &s.MINIMUM} {
+		if *field, err = r.uint32(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the timers accept TTL units (e.g. 1h), the serial doesn't
+func (s *SOA) parse(fields []string, origin string) (err error) {
+	if err := expectFields(fields, 7); err != nil {
+		return err
+	}
+	if s.MNAME, err = ParseName(fields[0], origin); err != nil {
+		return err
+	}
+	if s.RNAME, err = ParseName(fields[1], origin); err != nil {
+		return err
+	}
+	if s.SERIAL, err = parseUint32(fields[2]); err != nil {
+		return err
+	}
+	for i, field := range []*uint32{&s.REFRESH, &s.RETRY, &s.EXPIRE, &s.MINIMUM} {
+		if *field, err = ParseTTL(fields[3+i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NULL a null RR (EXPERIMENTAL), anything at all may be in the RDATA field
+type NULL struct {
+	DATA []byte
+}
+
+// NULL has no presentation format, the RFC 3597 generic one is used
+func (n *NULL) String() string {
+	return genericDataString(n.DATA)
+}
+
+func (n *NULL) pack(e *encoder) error {
+	e.buf = append(e.buf, n.DATA...)
+	return nil
+}
+
+func (n *NULL) unpack(r *reader, length int) (err error) {
+	n.DATA, err = r.bytes(length)
+	return err
+}
+
+func (n *NULL) parse(fields []string, origin string) (err error) {
+	n.DATA, err = parseGenericData(fields)
+	return err
+}
+
+// WKS a well known service description
+type WKS struct {
+	// a 32 bit Internet address
+	ADDRESS netip.Addr
+	// an 8 bit IP protocol number
+	PROTOCOL uint8
+	// a variable length bit map, bit N set means port N is served
+	BITMAP []byte
+}
+
+// ports returns the port numbers set in the bitmap
+func (w *WKS) ports() []int {
+	var ports []int
+	for i, b := range w.BITMAP {
+		for bit := range 8 {
+			if b&(0x80>>bit) != 0 {
+				ports = append(ports, i*8+bit)
+			}
+		}
+	}
+	return ports
+}
+
+func (w *WKS) String() string {
+	protocol := strconv.Itoa(int(w.PROTOCOL))
+	switch w.PROTOCOL {
+	case 6:
+		protocol = "tcp"
+	case 17:
+		protocol = "udp"
+	}
+
+	parts := []string{w.ADDRESS.String(), protocol}
+	for _, port := range w.ports() {
+		parts = append(parts, strconv.Itoa(port))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (w *WKS) pack(e *encoder) error {
+	if !w.ADDRESS.Is4() {
+		return fmt.Errorf("the WKS address %s is not an IPv4 address", w.ADDRESS)
+	}
+	addr := w.ADDRESS.As4()
+	e.buf = append(e.buf, addr[:]...)
+	e.buf = append(e.buf, w.PROTOCOL)
+	e.buf = append(e.buf, w.BITMAP...)
+	return nil
+}
+
+func (w *WKS) unpack(r *reader, length int) error {
+	if length < 5 {
+		return ErrBadRdata
+	}
+	b, err := r.bytes(length)
+	if err != nil {
+		return err
+	}
+	w.ADDRESS = netip.AddrFrom4([4]byte(b[:4]))
+	w.PROTOCOL = b[4]
+	w.BITMAP = b[5:]
+	return nil
+}
+
+func (w *WKS) parse(fields []string, origin string) (err error) {
+	if len(fields) < 2 {
+		return fmt.Errorf("expected an address and a protocol")
+	}
+	if w.ADDRESS, err = parseIPv4(fields[:1]); err != nil {
+		return err
+	}
+	switch strings.ToLower(fields[1]) {
+	case "tcp":
+		w.PROTOCOL = 6
+	case "udp":
+		w.PROTOCOL = 17
+	default:
+		if w.PROTOCOL, err = parseUint8(fields[1]); err != nil {
+			return err
+		}
+	}
+
+	w.BITMAP = []byte{}
+	for _, field := range fields[2:] {
+		port, err := parseUint16(field)
+		if err != nil {
+			return fmt.Errorf("invalid port %s, cause: %w", field, err)
+		}
+		for len(w.BITMAP) <= int(port)/8 {
+			w.BITMAP = append(w.BITMAP, 0)
+		}
+		w.BITMAP[port/8] |= 0x80 >> (port % 8)
+	}
+	return nil
+}
+
+// PTR a domain name pointer
+type PTR struct {
+	// a domain name which points to some location in the domain name space
+	PTRDNAME string
+}
+
+func (p *PTR) String() string {
+	return presentName(p.PTRDNAME)
+}
+
+func (p *PTR) pack(e *encoder) error {
+	return e.writeName(p.PTRDNAME, true)
+}
+
+func (p *PTR) unpack(r *reader, length int) (err error) {
+	p.PTRDNAME, err = r.name()
+	return err
+}
+
+func (p *PTR) parse(fields []string, origin string) (err error) {
+	p.PTRDNAME, err = parseSingleName(fields, origin)
+	return err
+}
+
+// HINFO host information
+type HINFO struct {
+	CPU string
+	OS  string
+}
+
+func (h *HINFO) String() string {
+	return quoteCharacterString(h.CPU) + " " + quoteCharacterString(h.OS)
+}
+
+func (h *HINFO) pack(e *encoder) error {
+	if err := e.writeCharacterString(h.CPU); err != nil {
+		return err
+	}
+	return e.writeCharacterString(h.OS)
+}
+
+func (h *HINFO) unpack(r *reader, length int) (err error) {
+	if h.CPU, err = r.characterString(); err != nil {
+		return err
+	}
+	h.OS, err = r.characterString()
+	return err
+}
+
+func (h *HINFO) parse(fields []string, origin string) (err error) {
+	if err := expectFields(fields, 2); err != nil {
+		return err
+	}
+	if h.CPU, err = parseCharacterString(fields[0]); err != nil {
+		return err
+	}
+	h.OS, err = parseCharacterString(fields[1])
+	return err
+}
+
+// MINFO mailbox or mail list information
+type MINFO struct {
+	// a mailbox which is responsible for the mailing list or mailbox
+	RMAILBX string
+	// a mailbox which is to receive error messages related to the mailing list or mailbox
+	EMAILBX string
+}
+
+func (m *MINFO) String() string {
+	return presentName(m.RMAILBX) + " " + presentName(m.EMAILBX)
+}
+
+func (m *MINFO) pack(e *encoder) error {
+	if err := e.writeName(m.RMAILBX, true); err != nil {
+		return err
+	}
+	return e.writeName(m.EMAILBX, true)
+}
+
+func (m *MINFO) unpack(r *reader, length int) (err error) {
+	if m.RMAILBX, err = r.name(); err != nil {
+		return err
+	}
+	m.EMAILBX, err = r.name()
+	return err
+}
+
+func (m *MINFO) parse(fields []string, origin string) (err error) {
+	if err := expectFields(fields, 2); err != nil {
+		return err
+	}
+	if m.RMAILBX, err = ParseName(fields[0], origin); err != nil {
+		return err
+	}
+	m.EMAILBX, err = ParseName(fields[1], origin)
+	return err
+}
+
+// the mail types of RFC 1035 below are obsolete or experimental, they hold a single name
+// which is decompressed like in any RFC 1035 type (RFC 3597 section 4)
+
+// MD mail destination, obsolete (RFC 973), MX replaced it
+type MD struct {
+	// a host which has a mail agent for the domain which should be able to deliver mail for it
+	MADNAME string
+}
+
+func (m *MD) String() string {
+	return presentName(m.MADNAME)
+}
+
+func (m *MD) pack(e *encoder) error {
+	return e.writeName(m.MADNAME, true)
+}
+
+func (m *MD) unpack(r *reader, length int) (err error) {
+	m.MADNAME, err = r.name()
+	return err
+}
+
+func (m *MD) parse(fields []string, origin string) (err error) {
+	m.MADNAME, err = parseSingleName(fields, origin)
+	return err
+}
+
+// MF mail forwarder, obsolete (RFC 973), MX replaced it
+type MF struct {
+	// a host which has a mail agent for the domain which will accept mail for forwarding to it
+	MADNAME string
+}
+
+func (m *MF) String() string {
+	return presentName(m.MADNAME)
+}
+
+func (m *MF) pack(e *encoder) error {
+	return e.writeName(m.MADNAME, true)
+}
+
+func (m *MF) unpack(r *reader, length int) (err error) {
+	m.MADNAME, err = r.name()
+	return err
+}
+
+func (m *MF) parse(fields []string, origin string) (err error) {
+	m.MADNAME, err = parseSingleName(fields, origin)
+	return err
+}
+
+// MB mailbox domain name, experimental
+type MB struct {
+	// a host which has the specified mailbox
+	MADNAME string
+}
+
+func (m *MB) String() string {
+	return presentName(m.MADNAME)
+}
+
+func (m *MB) pack(e *encoder) error {
+	return e.writeName(m.MADNAME, true)
+}
+
+func (m *MB) unpack(r *reader, length int) (err error) {
+	m.MADNAME, err = r.name()
+	return err
+}
+
+func (m *MB) parse(fields []string, origin string) (err error) {
+	m.MADNAME, err = parseSingleName(fields, origin)
+	return err
+}
+
+// MG mail group member, experimental
+type MG struct {
+	// a mailbox which is a member of the mail group specified by the owner
+	MGMNAME string
+}
+
+func (m *MG) String() string {
+	return presentName(m.MGMNAME)
+}
+
+func (m *MG) pack(e *encoder) error {
+	return e.writeName(m.MGMNAME, true)
+}
+
+func (m *MG) unpack(r *reader, length int) (err error) {
+	m.MGMNAME, err = r.name()
+	return err
+}
+
+func (m *MG) parse(fields []string, origin string) (err error) {
+	m.MGMNAME, err = parseSingleName(fields, origin)
+	return err
+}
+
+// MR mail rename domain name, experimental
+type MR struct {
+	// a mailbox which is the proper rename of the mailbox specified by the owner
+	NEWNAME string
+}
+
+func (m *MR) String() string {
+	return presentName(m.NEWNAME)
+}
+
+func (m *MR) pack(e *encoder) error {
+	return e.writeName(m.NEWNAME, true)
+}
+
+func (m *MR) unpack(r *reader, length int) (err error) {
+	m.NEWNAME, err = r.name()
+	return err
+}
+
+func (m *MR) parse(fields []string, origin string) (err error) {
+	m.NEWNAME, err = parseSingleName(fields, origin)
+	return err
+}
+
+// MX mail exchange
+type MX struct {
+	// the preference given to this RR among others at the same owner, lower values are preferred
+	PREFERENCE uint16
+	// a host willing to act as a mail exchange for the owner name
+	EXCHANGE string
+}
+
+func (m *MX) String() string {
+	return fmt.Sprintf("%d %s", m.PREFERENCE, presentName(m.EXCHANGE))
+}
+
+func (m *MX) pack(e *encoder) error {
+	e.writeUint16(m.PREFERENCE)
+	return e.writeName(m.EXCHANGE, true)
+}
+
+func (m *MX) unpack(r *reader, length int) (err error) {
+	if m.PREFERENCE, err = r.uint16(); err != nil {
+		return err
+	}
+	m.EXCHANGE, err = r.name()
+	return err
+}
+
+func (m *MX) parse(fields []string, origin string) (err error) {
+	if err := expectFields(fields, 2); err != nil {
+		return err
+	}
+	if m.PREFERENCE, err = parseUint16(fields[0]); err != nil {
+		return err
+	}
+	m.EXCHANGE, err = ParseName(fields[1], origin)
+	return err
+}
+
+// TXT text strings
+type TXT struct {
+	// one or more character strings (up to 255 octets each)
+	TXTDATA []string
+}
+
+func (t *TXT) String() string {
+	quoted := make([]string, len(t.TXTDATA))
+	for i, s := range t.TXTDATA {
+		quoted[i] = quoteCharacterString(s)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (t *TXT) pack(e *encoder) error {
+	for _, s := range t.TXTDATA {
+		if err := e.writeCharacterString(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *TXT) unpack(r *reader, length int) error {
+	end := r.off + length
+	t.TXTDATA = []string{}
+	for r.off < end {
+		s, err := r.characterString()
+		if err != nil {
+			return err
+		}
+		t.TXTDATA = append(t.TXTDATA, s)
+	}
+	return nil
+}
+
+func (t *TXT) parse(fields []string, origin string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("expected at least one character string")
+	}
+	t.TXTDATA = make([]string, len(fields))
+	for i, field := range fields {
+		s, err := parseCharacterString(field)
+		if err != nil {
+			return err
+		}
+		t.TXTDATA[i] = s
+	}
+	return nil
+}
+
+func parseIPv4(fields []string) (netip.Addr, error) {
+	if err := expectFields(fields, 1); err != nil {
+		return netip.Addr{}, err
+	}
+	addr, err := netip.ParseAddr(fields[0])
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if !addr.Is4() {
+		return netip.Addr{}, fmt.Errorf("%s is not an IPv4 address", fields[0])
+	}
+	return addr, nil
+}
+
+func parseSingleName(fields []string, origin string) (string, error) {
+	if err := expectFields(fields, 1); err != nil {
+		return "", err
+	}
+	return ParseName(fields[0], origin)
+}
+
+// genericDataString renders the RFC 3597 generic encoding: \# <length> <hex>
+func genericDataString(data []byte) string {
+	if len(data) == 0 {
+		return "\\# 0"
+	}
+	return fmt.Sprintf("\\# %d %s", len(data), hex.EncodeToString(data))
+}
+
+// parseGenericData parses the RFC 3597 generic encoding: \# <length> <hex>...
+func parseGenericData(fields []string) ([]byte, error) {
+	if len(fields) < 2 || fields[0] != "\\#" {
+		return nil, fmt.Errorf("expected the generic format \\# <length> <hex>")
+	}
+	length, err := parseUint16(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(strings.Join(fields[2:], ""))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != int(length) {
+		return nil, fmt.Errorf("the generic data has %d octets but declares %d", len(data), length)
+	}
+	return data, nil
+}
@@ -0,0 +1,334 @@
+package dns
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Record types defined after RFC 1035, the names inside their RDATA are never compressed (RFC 3597 section 4)
+
+// AAAA an IPv6 host address (RFC 3596)
+type AAAA struct {
+	// a 128 bit IPv6 address
+	ADDRESS netip.Addr
+}
+
+func (a *AAAA) String() string {
+	return a.ADDRESS.String()
+}
+
+func (a *AAAA) pack(e *encoder) error {
+	if !a.ADDRESS.Is6() {
+		return fmt.Errorf("the AAAA address %s is not an IPv6 address", a.ADDRESS)
+	}
+	addr := a.ADDRESS.As16()
+	e.buf = append(e.buf, addr[:]...)
+	return nil
+}
+
+func (a *AAAA) unpack(r *reader, length int) error {
+	if length != 16 {
+		return ErrBadRdata
+	}
+	b, err := r.bytes(16)
+	if err != nil {
+		return err
+	}
+	a.ADDRESS = netip.AddrFrom16([16]byte(b))
+	return nil
+}
+
+func (a *AAAA) parse(fields []string, origin string) error {
+	if err := expectFields(fields, 1); err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(fields[0])
+	if err != nil {
+		return err
+	}
+	if !addr.Is6() {
+		return fmt.Errorf("%s is not an IPv6 address", fields[0])
+	}
+	a.ADDRESS = addr
+	return nil
+}
+
+// SRV the location of a service (RFC 2782)
+type SRV struct {
+	// lower values are tried first
+	PRIORITY uint16
+	// relative weight for entries with the same priority
+	WEIGHT uint16
+	PORT   uint16
+	// the host providing the service, "." means the service is not available
+	TARGET string
+}
+
+func (s *SRV) String() string {
+	return fmt.Sprintf("%d %d %d %s", s.PRIORITY, s.WEIGHT, s.PORT, presentName(s.TARGET))
+}
+
+func (s *SRV) pack(e *encoder) error {
+	e.writeUint16(s.PRIORITY)
+	e.writeUint16(s.WEIGHT)
+	e.writeUint16(s.PORT)
+	return e.writeName(s.TARGET, false)
+}
+
+func (s *SRV) unpack(r *reader, length int) (err error) {
+	for _, field := range []*uint16{&s.PRIORITY, &s.WEIGHT, &s.PORT} {
+		if *field, err = r.uint16(); err != nil {
+			return err
+		}
+	}
+	s.TARGET, err = r.name()
+	return err
+}
+
+func (s *SRV) parse(fields []string, origin string) (err error) {
+	if err := expectFields(fields, 4); err != nil {
+		return err
+	}
+	for i, field := range []*uint16{&s.PRIORITY, &s.WEIGHT, &s.PORT} {
+		if *field, err = parseUint16(fields[i]); err != nil {
+			return err
+		}
+	}
+	s.TARGET, err = ParseName(fields[3], origin)
+	return err
+}
+
+// NAPTR naming authority pointer (RFC 3403)
+type NAPTR struct {
+	ORDER      uint16
+	PREFERENCE uint16
+	// character string with the flags controlling the rewriting (e.g. "U", "S")
+	FLAGS string
+	// character string with the available services
+	SERVICES string
+	// character string with the substitution expression
+	REGEXP string
+	// the next name to query when REGEXP is empty
+	REPLACEMENT string
+}
+
+func (n *NAPTR) String() string {
+	return fmt.Sprintf("%d %d %s %s %s %s", n.ORDER, n.PREFERENCE, quoteCharacterString(n.FLAGS),
+		quoteCharacterString(n.SERVICES), quoteCharacterString(n.REGEXP), presentName(n.REPLACEMENT))
+}
+
+func (n *NAPTR) pack(e *encoder) error {
+	e.writeUint16(n.ORDER)
+	e.writeUint16(n.PREFERENCE)
+	for _, s := range []string{n.FLAGS, n.SERVICES, n.REGEXP} {
+		if err := e.writeCharacterString(s); err != nil {
+			return err
+		}
+	}
+	return e.writeName(n.REPLACEMENT, false)
+}
+
+func (n *NAPTR) unpack(r *reader, length int) (err error) {
+	if n.ORDER, err = r.uint16(); err != nil {
+		return err
+	}
+	if n.PREFERENCE, err = r.uint16(); err != nil {
+		return err
+	}
+	for _, field := range []*string{&n.FLAGS, &n.SERVICES, &n.REGEXP} {
+		if *field, err = r.characterString(); err != nil {
+			return err
+		}
+	}
+	n.REPLACEMENT, err = r.name()
+	return err
+}
+
+func (n *NAPTR) parse(fields []string, origin string) (err error) {
+	if err := expectFields(fields, 6); err != nil {
+		return err
+	}
+	if n.ORDER, err = parseUint16(fields[0]); err != nil {
+		return err
+	}
+	if n.PREFERENCE, err = parseUint16(fields[1]); err != nil {
+		return err
+	}
+	for i, field := range []*string{&n.FLAGS, &n.SERVICES, &n.REGEXP} {
+		if *field, err = parseCharacterString(fields[2+i]); err != nil {
+			return err
+		}
+	}
+	n.REPLACEMENT, err = ParseName(fields[5], origin)
+	return err
+}
+
+// DNAME redirection of a whole subtree (RFC 6672)
+type DNAME struct {
+	TARGET string
+}
+
+func (d *DNAME) String() string {
+	return presentName(d.TARGET)
+}
+
+func (d *DNAME) pack(e *encoder) error {
+	return e.writeName(d.TARGET, false)
+}
+
+func (d *DNAME) unpack(r *reader, length int) (err error) {
+	d.TARGET, err = r.name()
+	return err
+}
+
+func (d *DNAME) parse(fields []string, origin string) (err error) {
+	d.TARGET, err = parseSingleName(fields, origin)
+	return err
+}
+
+// SSHFP SSH key fingerprint (RFC 4255)
+type SSHFP struct {
+	// 1 RSA, 2 DSA, 3 ECDSA, 4 Ed25519...
+	ALGORITHM uint8
+	// 1 SHA-1, 2 SHA-256
+	FPTYPE      uint8
+	FINGERPRINT []byte
+}
+
+func (s *SSHFP) String() string {
+	return fmt.Sprintf("%d %d %s", s.ALGORITHM, s.FPTYPE, strings.ToUpper(hex.EncodeToString(s.FINGERPRINT)))
+}
+
+func (s *SSHFP) pack(e *encoder) error {
+	e.buf = append(e.buf, s.ALGORITHM, s.FPTYPE)
+	e.buf = append(e.buf, s.FINGERPRINT...)
+	return nil
+}
+
+func (s *SSHFP) unpack(r *reader, length int) error {
+	if length < 2 {
+		return ErrBadRdata
+	}
+	b, err := r.bytes(length)
+	if err != nil {
+		return err
+	}
+	s.ALGORITHM, s.FPTYPE, s.FINGERPRINT = b[0], b[1], b[2:]
+	return nil
+}
+
+// the fingerprint hex may be split in several fields
+func (s *SSHFP) parse(fields []string, origin string) (err error) {
+	if len(fields) < 3 {
+		return fmt.Errorf("expected at least 3 fields, got %d", len(fields))
+	}
+	if s.ALGORITHM, err = parseUint8(fields[0]); err != nil {
+		return err
+	}
+	if s.FPTYPE, err = parseUint8(fields[1]); err != nil {
+		return err
+	}
+	s.FINGERPRINT, err = hex.DecodeString(strings.Join(fields[2:], ""))
+	return err
+}
+
+// TLSA TLS certificate association (RFC 6698)
+type TLSA struct {
+	// 0 PKIX-TA, 1 PKIX-EE, 2 DANE-TA, 3 DANE-EE
+	USAGE uint8
+	// 0 full certificate, 1 SubjectPublicKeyInfo
+	SELECTOR uint8
+	// 0 exact match, 1 SHA-256, 2 SHA-512
+	MATCHINGTYPE uint8
+	CERTIFICATE  []byte
+}
+
+func (t *TLSA) String() string {
+	return fmt.Sprintf("%d %d %d %s", t.USAGE, t.SELECTOR, t.MATCHINGTYPE, strings.ToUpper(hex.EncodeToString(t.CERTIFICATE)))
+}
+
+func (t *TLSA) pack(e *encoder) error {
+	e.buf = append(e.buf, t.USAGE, t.SELECTOR, t.MATCHINGTYPE)
+	e.buf = append(e.buf, t.CERTIFICATE...)
+	return nil
+}
+
+func (t *TLSA) unpack(r *reader, length int) error {
+	if length < 3 {
+		return ErrBadRdata
+	}
+	b, err := r.bytes(length)
+	if err != nil {
+		return err
+	}
+	t.USAGE, t.SELECTOR, t.MATCHINGTYPE, t.CERTIFICATE = b[0], b[1], b[2], b[3:]
+	return nil
+}
+
+// the certificate hex may be split in several fields
+func (t *TLSA) parse(fields []string, origin string) (err error) {
+	if len(fields) < 4 {
+		return fmt.Errorf("expected at least 4 fields, got %d", len(fields))
+	}
+	for i, field := range []*uint8{&t.USAGE, &t.SELECTOR, &t.MATCHINGTYPE} {
+		if *field, err = parseUint8(fields[i]); err != nil {
+			return err
+		}
+	}
+	t.CERTIFICATE, err = hex.DecodeString(strings.Join(fields[3:], ""))
+	return err
+}
+
+// CAA certification authority authorization (RFC 8659)
+type CAA struct {
+	// bit 0 (128) is the issuer critical flag
+	FLAGS uint8
+	// property identifier, e.g. issue, issuewild, iodef
+	TAG string
+	// property value, not length prefixed on the wire
+	VALUE string
+}
+
+func (c *CAA) String() string {
+	return fmt.Sprintf("%d %s %s", c.FLAGS, c.TAG, quoteCharacterString(c.VALUE))
+}
+
+func (c *CAA) pack(e *encoder) error {
+	if len(c.TAG) == 0 || len(c.TAG) > 255 {
+		return fmt.Errorf("the CAA tag must have between 1 and 255 octets")
+	}
+	e.buf = append(e.buf, c.FLAGS, byte(len(c.TAG)))
+	e.buf = append(e.buf, c.TAG...)
+	e.buf = append(e.buf, c.VALUE...)
+	return nil
+}
+
+func (c *CAA) unpack(r *reader, length int) (err error) {
+	end := r.off + length
+	if c.FLAGS, err = r.uint8(); err != nil {
+		return err
+	}
+	if c.TAG, err = r.characterString(); err != nil {
+		return err
+	}
+	if r.off > end {
+		return ErrBadRdata
+	}
+	value, err := r.bytes(end - r.off)
+	c.VALUE = string(value)
+	return err
+}
+
+func (c *CAA) parse(fields []string, origin string) (err error) {
+	if err := expectFields(fields, 3); err != nil {
+		return err
+	}
+	if c.FLAGS, err = parseUint8(fields[0]); err != nil {
+		return err
+	}
+	c.TAG = fields[1]
+	c.VALUE, err = parseCharacterString(fields[2])
+	return err
+}
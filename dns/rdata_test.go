@@ -0,0 +1,43 @@
+package dns
+
+import (
+	"testing"
+)
+
+// the names of the RFC 1035 mail types may be compressed, they must come out
+// of the decoder as names so they're still right in another message
+func TestDecodeCompressedMailTypes(t *testing.T) {
+	for _, recordType := range []string{"MD", "MF", "MB", "MG", "MR"} {
+		t.Run(recordType, func(t *testing.T) {
+			code, _ := getRecordTypeUint16(recordType)
+			// the RDATA is mail. followed by a pointer to example.com. in the question
+			payload := packet(
+				header(1, 1, 0, 0),
+				[]byte{0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'c', 'o', 'm', 0x00}, typeAClassIN,
+				[]byte{0xC0, 0x0C, byte(code >> 8), byte(code), 0x00, 0x01, 0, 0, 0, 60, 0, 7},
+				[]byte{0x04, 'm', 'a', 'i', 'l', 0xC0, 0x0C},
+			)
+			message, err := DecodeMessage(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := message.Answers[0].RDATA.String(); got != "mail.example.com." {
+				t.Fatalf("expected mail.example.com., got %s", got)
+			}
+
+			// alone in a message the name can't point to the question anymore
+			message.Questions = nil
+			encoded, err := message.EncodeMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := DecodeMessage(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decoded.Answers[0].RDATA.String(); got != "mail.example.com." {
+				t.Fatalf("expected mail.example.com. after encoding again, got %s", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// Errors returned while decoding a message, every malformed input maps to one of them
+// so callers can use errors.Is to decide how to react (e.g. replying with FORMERR)
+var (
+	// the message ended before the field being read
+	ErrTruncated = errors.New("message is truncated")
+	// a compression pointer does not point to a prior occurrence of a name or the chain is too long
+	ErrPointerLoop = errors.New("compression pointer loop")
+	// a compression pointer points to itself or to something after it
+	ErrForwardPointer = errors.New("compression pointer points forward")
+	// a length byte is bigger than 63 without being a pointer (01 and 10 prefixes are not supported)
+	ErrLabelTooLong = errors.New("label has more than 63 octets")
+	// the decoded name exceeds 255 octets
+	ErrNameTooLong = errors.New("domain name has more than 255 octets")
+	// a label holds a dot or a backslash, names are kept as dotted strings so a.b.com. would
+	// silently become three labels when encoded again
+	ErrBadLabel = errors.New("label contains a dot or a backslash")
+	// the RDATA doesn't match its RDLENGTH
+	ErrBadRdata = errors.New("malformed RDATA")
+)
+
+// a name can have at most 127 labels, so there is no reason to follow more pointers than that
+const maxPointers = 127
+
+// reader is a cursor over the whole payload, every read is bounds checked
+// and advances the offset only when it succeeds
+type reader struct {
+	buf []byte
+	off int
+}
+
+func (r *reader) remaining() int {
+	return len(r.buf) - r.off
+}
+
+func (r *reader) uint8() (uint8, error) {
+	if r.remaining() < 1 {
+		return 0, ErrTruncated
+	}
+	v := r.buf[r.off]
+	r.off++
+	return v, nil
+}
+
+func (r *reader) uint16() (uint16, error) {
+	if r.remaining() < 2 {
+		return 0, ErrTruncated
+	}
+	v := binary.BigEndian.Uint16(r.buf[r.off:])
+	r.off += 2
+	return v, nil
+}
+
+func (r *reader) uint32() (uint32, error) {
+	if r.remaining() < 4 {
+		return 0, ErrTruncated
+	}
+	v := binary.BigEndian.Uint32(r.buf[r.off:])
+	r.off += 4
+	return v, nil
+}
+
+// returns a copy, so the decoded message doesn't keep the read buffer alive
+func (r *reader) bytes(n int) ([]byte, error) {
+	if n < 0 || r.remaining() < n {
+		return nil, ErrTruncated
+	}
+	b := make([]byte, n)
+	copy(b, r.buf[r.off:r.off+n])
+	r.off += n
+	return b, nil
+}
+
+// <character-string> is a single length octet followed by that number of characters
+func (r *reader) characterString() (string, error) {
+	length, err := r.uint8()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(int(length))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// name decodes a domain name (see { encoder.writeName }) following compression pointers,
+// the cursor ends right after the first pointer or the null byte.
+// Pointers must point to a prior occurrence: every jump has to land before the previous one,
+// which makes loops impossible, and the chain is capped by maxPointers anyway.
+// the returned name always has the final dot of the root label
+func (r *reader) name() (string, error) {
+	var sb strings.Builder
+	curr := r.off
+	// offset the next pointer has to stay below
+	limit := r.off
+	end := -1
+	pointers := 0
+	// counting the null byte
+	wireSize := 1
+
+	for {
+		if curr >= len(r.buf) {
+			return "", ErrTruncated
+		}
+		length := int(r.buf[curr])
+
+		switch length & 0xC0 {
+		case 0x00:
+			if length == 0 {
+				if end == -1 {
+					end = curr + 1
+				}
+				r.off = end
+				if sb.Len() == 0 {
+					return ".", nil
+				}
+				return sb.String(), nil
+			}
+
+			wireSize += length + 1
+			if wireSize > 255 {
+				return "", ErrNameTooLong
+			}
+			if curr+1+length > len(r.buf) {
+				return "", ErrTruncated
+			}
+			label := r.buf[curr+1 : curr+1+length]
+			if bytes.ContainsAny(label, ".\\") {
+				return "", ErrBadLabel
+			}
+			sb.Write(label)
+			sb.WriteByte('.')
+			curr += length + 1
+
+		case 0xC0:
+			if curr+1 >= len(r.buf) {
+				return "", ErrTruncated
+			}
+			// pointer is the "last" 14 bits from the length byte and the next one
+			target := (length&0x3F)<<8 | int(r.buf[curr+1])
+			if target >= curr {
+				return "", ErrForwardPointer
+			}
+			pointers++
+			if target >= limit || pointers > maxPointers {
+				return "", ErrPointerLoop
+			}
+			if end == -1 {
+				end = curr + 2
+			}
+			limit = target
+			curr = target
+
+		default:
+			return "", ErrLabelTooLong
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package dns
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+// header builds a query header with the given section counts
+func header(qdcount, ancount, nscount, arcount uint16) []byte {
+	return []byte{
+		0x12, 0x34, 0x01, 0x00,
+		byte(qdcount >> 8), byte(qdcount),
+		byte(ancount >> 8), byte(ancount),
+		byte(nscount >> 8), byte(nscount),
+		byte(arcount >> 8), byte(arcount),
+	}
+}
+
+func packet(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// type A, class IN
+var typeAClassIN = []byte{0x00, 0x01, 0x00, 0x01}
+
+func TestDecodeMessageHostileInput(t *testing.T) {
+	// 128 labels of a single octet take 257 octets with the null byte
+	var long []byte
+	for range 128 {
+		long = append(long, 0x01, 'a')
+	}
+	long = append(long, 0x00)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		err     error
+	}{
+		{"empty", nil, ErrTruncated},
+		{"short header", header(0, 0, 0, 0)[:11], ErrTruncated},
+		{"name cut short", packet(header(1, 0, 0, 0), []byte{0x07, 'e', 'x', 'a'}), ErrTruncated},
+		{"name without the null byte", packet(header(1, 0, 0, 0), []byte{0x01, 'a'}), ErrTruncated},
+		{"question without class", packet(header(1, 0, 0, 0), []byte{0x01, 'a', 0x00, 0x00, 0x01}), ErrTruncated},
+		{"pointer cut short", packet(header(1, 0, 0, 0), []byte{0xC0}), ErrTruncated},
+		{"pointer to itself", packet(header(1, 0, 0, 0), []byte{0xC0, 0x0C}, typeAClassIN), ErrForwardPointer},
+		{"pointer forward", packet(header(1, 0, 0, 0), []byte{0xC0, 0x0E, 0x01, 'a', 0x00}, typeAClassIN), ErrForwardPointer},
+		{
+			// a -> pointer back to a, the second jump doesn't land before the first one
+			"pointer loop",
+			packet(header(1, 0, 0, 0), []byte{0x01, 'a', 0xC0, 0x0C}, typeAClassIN),
+			ErrPointerLoop,
+		},
+		{"label length with 01 prefix", packet(header(1, 0, 0, 0), []byte{0x40, 'a', 0x00}, typeAClassIN), ErrLabelTooLong},
+		{"label length with 10 prefix", packet(header(1, 0, 0, 0), []byte{0x80, 'a', 0x00}, typeAClassIN), ErrLabelTooLong},
+		{"name over 255 octets", packet(header(1, 0, 0, 0), long, typeAClassIN), ErrNameTooLong},
+		{"dot inside a label", packet(header(1, 0, 0, 0), []byte{0x03, 'a', '.', 'b', 0x03, 'c', 'o', 'm', 0x00}, typeAClassIN), ErrBadLabel},
+		{"backslash inside a label", packet(header(1, 0, 0, 0), []byte{0x03, 'a', '\\', 'b', 0x00}, typeAClassIN), ErrBadLabel},
+		{"more questions than the message has", packet(header(3, 0, 0, 0), []byte{0x01, 'a', 0x00}, typeAClassIN), ErrTruncated},
+		{"more answers than the message has", packet(header(0, 2, 0, 0), []byte{0x00}, typeAClassIN, []byte{0, 0, 0, 60, 0, 4, 192, 0, 2, 1}), ErrTruncated},
+		{"additional count with no records", packet(header(0, 0, 0, 0xFFFF)), ErrTruncated},
+		{"RDLENGTH past the end", packet(header(0, 1, 0, 0), []byte{0x00}, typeAClassIN, []byte{0, 0, 0, 60, 0, 8, 192, 0, 2, 1}), ErrTruncated},
+		{"A with 3 octets", packet(header(0, 1, 0, 0), []byte{0x00}, typeAClassIN, []byte{0, 0, 0, 60, 0, 3, 192, 0, 2}), ErrBadRdata},
+		{
+			// the CNAME target ends one octet after its RDLENGTH
+			"name overrunning RDLENGTH",
+			packet(header(0, 1, 0, 0), []byte{0x00, 0x00, 0x05, 0x00, 0x01, 0, 0, 0, 60, 0, 2, 0x01, 'a', 0x00}),
+			ErrBadRdata,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeMessage(test.payload)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestDecodeMessageCompressedNames(t *testing.T) {
+	// example.com. in the question, www.example.com. in the answer pointing to it
+	payload := packet(
+		header(1, 1, 0, 0),
+		[]byte{0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'c', 'o', 'm', 0x00}, typeAClassIN,
+		[]byte{0x03, 'w', 'w', 'w', 0xC0, 0x0C}, typeAClassIN, []byte{0, 0, 0, 60, 0, 4, 192, 0, 2, 1},
+	)
+	message, err := DecodeMessage(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Questions[0].QNAME != "example.com." {
+		t.Errorf("expected example.com., got %s", message.Questions[0].QNAME)
+	}
+	if message.Answers[0].NAME != "www.example.com." {
+		t.Errorf("expected www.example.com., got %s", message.Answers[0].NAME)
+	}
+}
+
+// every prefix of a valid message is an error, never a panic
+func TestDecodeMessageEveryTruncation(t *testing.T) {
+	message := &Message{
+		Header:    Header{ID: 1, QR: true},
+		Questions: []*Question{{QNAME: "example.com.", QTYPE: "A", QCLASS: "IN"}},
+		Answers: []Answer{
+			{NAME: "example.com.", TYPE: "CNAME", CLASS: "IN", TTL: 60, RDATA: &CNAME{CNAME: "www.example.com."}},
+			{NAME: "www.example.com.", TYPE: "A", CLASS: "IN", TTL: 60, RDATA: &A{ADDRESS: netip.MustParseAddr("192.0.2.1")}},
+		},
+	}
+	payload, err := message.EncodeMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeMessage(payload); err != nil {
+		t.Fatal(err)
+	}
+	for i := range len(payload) {
+		if _, err := DecodeMessage(payload[:i]); err == nil {
+			t.Errorf("decoding the first %d octets succeeded", i)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package dns
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SvcParamKeys (RFC 9460 section 14.3.2)
+const (
+	SvcMandatory     = 0
+	SvcAlpn          = 1
+	SvcNoDefaultAlpn = 2
+	SvcPort          = 3
+	SvcIPv4Hint      = 4
+	SvcEch           = 5
+	SvcIPv6Hint      = 6
+	SvcDohPath       = 7
+	SvcOhttp         = 8
+)
+
+var svcParamKeyNames = map[uint16]string{
+	SvcMandatory:     "mandatory",
+	SvcAlpn:          "alpn",
+	SvcNoDefaultAlpn: "no-default-alpn",
+	SvcPort:          "port",
+	SvcIPv4Hint:      "ipv4hint",
+	SvcEch:           "ech",
+	SvcIPv6Hint:      "ipv6hint",
+	SvcDohPath:       "dohpath",
+	SvcOhttp:         "ohttp",
+}
+
+// SVCParam is a single key=value pair, VALUE is kept in wire format
+type SVCParam struct {
+	KEY   uint16
+	VALUE []byte
+}
+
+// SVCB service binding (RFC 9460)
+type SVCB struct {
+	// 0 is the AliasMode, anything else is the ServiceMode priority
+	PRIORITY uint16
+	// "." means the owner name itself in ServiceMode
+	TARGET string
+	// kept sorted by key, as required on the wire
+	PARAMS []SVCParam
+}
+
+// HTTPS is the SVCB variant for the https scheme, with the exact same format
+type HTTPS struct {
+	SVCB
+}
+
+func (s *SVCB) String() string {
+	parts := []string{strconv.Itoa(int(s.PRIORITY)), presentName(s.TARGET)}
+	for _, param := range s.PARAMS {
+		parts = append(parts, param.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s *SVCB) pack(e *encoder) error {
+	e.writeUint16(s.PRIORITY)
+	if err := e.writeName(s.TARGET, false); err != nil {
+		return err
+	}
+
+	params := slices.Clone(s.PARAMS)
+	slices.SortFunc(params, func(a, b SVCParam) int { return int(a.KEY) - int(b.KEY) })
+	for i, param := range params {
+		if i > 0 && params[i-1].KEY == param.KEY {
+			return fmt.Errorf("the SvcParamKey %s is repeated", svcParamKeyString(param.KEY))
+		}
+		if len(param.VALUE) > 0xFFFF {
+			return fmt.Errorf("the SvcParamValue of %s is too big", svcParamKeyString(param.KEY))
+		}
+		e.writeUint16(param.KEY)
+		e.writeUint16(uint16(len(param.VALUE)))
+		e.buf = append(e.buf, param.VALUE...)
+	}
+	return nil
+}
+
+func (s *SVCB) unpack(r *reader, length int) (err error) {
+	end := r.off + length
+	if s.PRIORITY, err = r.uint16(); err != nil {
+		return err
+	}
+	if s.TARGET, err = r.name(); err != nil {
+		return err
+	}
+
+	s.PARAMS = nil
+	for r.off < end {
+		key, err := r.uint16()
+		if err != nil {
+			return err
+		}
+		// keys must be strictly increasing
+		if len(s.PARAMS) > 0 && s.PARAMS[len(s.PARAMS)-1].KEY >= key {
+			return ErrBadRdata
+		}
+		valueLength, err := r.uint16()
+		if err != nil {
+			return err
+		}
+		value, err := r.bytes(int(valueLength))
+		if err != nil {
+			return err
+		}
+		s.PARAMS = append(s.PARAMS, SVCParam{KEY: key, VALUE: value})
+	}
+	return nil
+}
+
+func (s *SVCB) parse(fields []string, origin string) (err error) {
+	if len(fields) < 2 {
+		return fmt.Errorf("expected at least a priority and a target")
+	}
+	if s.PRIORITY, err = parseUint16(fields[0]); err != nil {
+		return err
+	}
+	if s.TARGET, err = ParseName(fields[1], origin); err != nil {
+		return err
+	}
+
+	s.PARAMS = nil
+	for _, field := range fields[2:] {
+		param, err := parseSVCParam(field)
+		if err != nil {
+			return err
+		}
+		s.PARAMS = append(s.PARAMS, param)
+	}
+	slices.SortFunc(s.PARAMS, func(a, b SVCParam) int { return int(a.KEY) - int(b.KEY) })
+	return nil
+}
+
+func svcParamKeyString(key uint16) string {
+	if name, ok := svcParamKeyNames[key]; ok {
+		return name
+	}
+	return "key" + strconv.Itoa(int(key))
+}
+
+func parseSvcParamKey(name string) (uint16, error) {
+	for key, keyName := range svcParamKeyNames {
+		if keyName == name {
+			return key, nil
+		}
+	}
+	if number, ok := strings.CutPrefix(name, "key"); ok {
+		return parseUint16(number)
+	}
+	return 0, fmt.Errorf("unknown SvcParamKey %s", name)
+}
+
+// String renders key=value, values that can't be shown as text use the generic
+// escaped form of RFC 9460 appendix A
+func (p SVCParam) String() string {
+	key := svcParamKeyString(p.KEY)
+	value, err := p.presentationValue()
+	if err != nil {
+		value = quoteCharacterString(string(p.VALUE))
+	}
+	if value == "" {
+		return key
+	}
+	return key + "=" + value
+}
+
+func (p SVCParam) presentationValue() (string, error) {
+	switch p.KEY {
+	case SvcMandatory:
+		if len(p.VALUE)%2 != 0 {
+			return "", ErrBadRdata
+		}
+		keys := []string{}
+		for i := 0; i < len(p.VALUE); i += 2 {
+			keys = append(keys, svcParamKeyString(binary.BigEndian.Uint16(p.VALUE[i:])))
+		}
+		return strings.Join(keys, ","), nil
+	case SvcAlpn:
+		ids := []string{}
+		r := &reader{buf: p.VALUE}
+		for r.remaining() > 0 {
+			id, err := r.characterString()
+			if err != nil {
+				return "", err
+			}
+			ids = append(ids, strings.ReplaceAll(id, ",", "\\,"))
+		}
+		return quoteCharacterString(strings.Join(ids, ",")), nil
+	case SvcNoDefaultAlpn, SvcOhttp:
+		if len(p.VALUE) != 0 {
+			return "", ErrBadRdata
+		}
+		return "", nil
+	case SvcPort:
+		if len(p.VALUE) != 2 {
+			return "", ErrBadRdata
+		}
+		return strconv.Itoa(int(binary.BigEndian.Uint16(p.VALUE))), nil
+	case SvcIPv4Hint, SvcIPv6Hint:
+		size := 4
+		if p.KEY == SvcIPv6Hint {
+			size = 16
+		}
+		if len(p.VALUE) == 0 || len(p.VALUE)%size != 0 {
+			return "", ErrBadRdata
+		}
+		addrs := []string{}
+		for i := 0; i < len(p.VALUE); i += size {
+			addr, _ := netip.AddrFromSlice(p.VALUE[i : i+size])
+			addrs = append(addrs, addr.String())
+		}
+		return strings.Join(addrs, ","), nil
+	case SvcEch:
+		return base64.StdEncoding.EncodeToString(p.VALUE), nil
+	default:
+		return quoteCharacterString(string(p.VALUE)), nil
+	}
+}
+
+// parseSVCParam parses key=value, the value may be quoted
+func parseSVCParam(field string) (SVCParam, error) {
+	name, rawValue, hasValue := strings.Cut(field, "=")
+	key, err := parseSvcParamKey(name)
+	if err != nil {
+		return SVCParam{}, err
+	}
+	value, err := parseCharacterString(rawValue)
+	if err != nil {
+		return SVCParam{}, err
+	}
+
+	param := SVCParam{KEY: key}
+	switch key {
+	case SvcMandatory:
+		for _, keyName := range strings.Split(value, ",") {
+			mandatoryKey, err := parseSvcParamKey(keyName)
+			if err != nil {
+				return SVCParam{}, err
+			}
+			param.VALUE = binary.BigEndian.AppendUint16(param.VALUE, mandatoryKey)
+		}
+	case SvcAlpn:
+		e := newEncoder(nil)
+		for _, id := range splitEscapedCommas(value) {
+			if err := e.writeCharacterString(id); err != nil {
+				return SVCParam{}, err
+			}
+		}
+		param.VALUE = e.buf
+	case SvcNoDefaultAlpn, SvcOhttp:
+		if hasValue {
+			return SVCParam{}, fmt.Errorf("%s doesn't take a value", name)
+		}
+	case SvcPort:
+		port, err := parseUint16(value)
+		if err != nil {
+			return SVCParam{}, err
+		}
+		param.VALUE = binary.BigEndian.AppendUint16(nil, port)
+	case SvcIPv4Hint, SvcIPv6Hint:
+		for _, rawAddr := range strings.Split(value, ",") {
+			addr, err := netip.ParseAddr(rawAddr)
+			if err != nil {
+				return SVCParam{}, err
+			}
+			if key == SvcIPv4Hint && !addr.Is4() || key == SvcIPv6Hint && !addr.Is6() {
+				return SVCParam{}, fmt.Errorf("%s is not valid for %s", rawAddr, name)
+			}
+			param.VALUE = append(param.VALUE, addr.AsSlice()...)
+		}
+	case SvcEch:
+		if param.VALUE, err = base64.StdEncoding.DecodeString(value); err != nil {
+			return SVCParam{}, err
+		}
+	default:
+		param.VALUE = []byte(value)
+	}
+	return param, nil
+}
+
+// alpn ids are comma separated, a literal comma is written as \,
+func splitEscapedCommas(value string) []string {
+	ids := []string{}
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value) && value[i+1] == ',':
+			sb.WriteByte(',')
+			i++
+		case value[i] == ',':
+			ids = append(ids, sb.String())
+			sb.Reset()
+		default:
+			sb.WriteByte(value[i])
+		}
+	}
+	return append(ids, sb.String())
+}
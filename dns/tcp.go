@@ -0,0 +1,38 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Over TCP every message is prefixed with a 2 byte length (RFC 1035 section 4.2.2)
+
+// MaxTCPSize is the largest message the length prefix can carry
+const MaxTCPSize = 0xFFFF
+
+// ReadTCP reads a single length prefixed message
+func ReadTCP(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// WriteTCP writes the length prefix and the message in a single write,
+// so concurrent writers on the same connection never interleave
+func WriteTCP(w io.Writer, payload []byte) error {
+	if len(payload) > MaxTCPSize {
+		return fmt.Errorf("the message has %d octets, more than TCP framing allows", len(payload))
+	}
+	framed := make([]byte, 0, 2+len(payload))
+	framed = binary.BigEndian.AppendUint16(framed, uint16(len(payload)))
+	framed = append(framed, payload...)
+	_, err := w.Write(framed)
+	return err
+}
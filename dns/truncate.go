@@ -0,0 +1,64 @@
+package dns
+
+import (
+	"fmt"
+	"slices"
+)
+
+// EncodeMessageWithLimit encodes the message making sure the result is at most limit octets.
+// When it doesn't fit, whole RRsets are dropped starting from the end of the additional section,
+// then the authority and finally the answer section (RFC 2181 section 9), a partial RRset is never sent.
+// TC is set when answer data had to be dropped, so the client knows it should retry over TCP.
+// When not even the question fits, only the header (and the OPT record if there is room) is sent
+// with TC set, the result is never over the limit. The message itself is not modified
+func (m *Message) EncodeMessageWithLimit(limit int) ([]byte, error) {
+	encoded, err := m.EncodeMessage()
+	if err != nil || len(encoded) <= limit {
+		return encoded, err
+	}
+
+	truncated := *m
+	truncated.Answers = slices.Clone(m.Answers)
+	truncated.Authority = slices.Clone(m.Authority)
+	truncated.Additional = slices.Clone(m.Additional)
+
+	// in the order they are dropped
+	sections := []*[]Answer{&truncated.Additional, &truncated.Authority, &truncated.Answers}
+	for _, section := range sections {
+		for len(*section) > 0 {
+			*section = dropLastRRset(*section)
+			if section == &truncated.Answers {
+				truncated.Header.TC = true
+			}
+
+			encoded, err = truncated.EncodeMessage()
+			if err != nil || len(encoded) <= limit {
+				return encoded, err
+			}
+		}
+	}
+
+	// not even the question fits, the header alone still tells the client to retry over TCP
+	headerOnly := Message{Header: truncated.Header, EDNS: truncated.EDNS}
+	headerOnly.Header.TC = true
+	if encoded, err = headerOnly.EncodeMessage(); err != nil || len(encoded) <= limit {
+		return encoded, err
+	}
+	if limit < 12 {
+		return nil, fmt.Errorf("the limit of %d octets can't even hold the header", limit)
+	}
+	headerOnly.EDNS = nil
+	return headerOnly.EncodeMessage()
+}
+
+// dropLastRRset removes every record of the RRset (same owner, type and class) of the last record
+func dropLastRRset(records []Answer) []Answer {
+	last := records[len(records)-1]
+	return slices.DeleteFunc(records, func(record Answer) bool {
+		return sameRRset(record, last)
+	})
+}
+
+func sameRRset(a Answer, b Answer) bool {
+	return EqualNames(a.NAME, b.NAME) && a.TYPE == b.TYPE && a.CLASS == b.CLASS
+}
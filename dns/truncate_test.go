@@ -0,0 +1,72 @@
+package dns
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestEncodeMessageWithLimit(t *testing.T) {
+	// 63 octets labels, the question alone takes more than 100 octets
+	long := strings.Repeat("a", 63) + "." + strings.Repeat("b", 63) + ".example."
+	message := &Message{
+		Header:    Header{ID: 1, QR: true},
+		Questions: []*Question{{QNAME: long, QTYPE: "A", QCLASS: "IN"}},
+		EDNS:      &EDNS{UDPSIZE: 1232},
+	}
+	for i := range 40 {
+		message.Answers = append(message.Answers, Answer{
+			NAME: long, TYPE: "A", CLASS: "IN", TTL: 60,
+			RDATA: &A{ADDRESS: netip.AddrFrom4([4]byte{192, 0, 2, byte(i)})},
+		})
+	}
+	message.Additional = []Answer{{
+		NAME: "ns.example.", TYPE: "A", CLASS: "IN", TTL: 60,
+		RDATA: &A{ADDRESS: netip.MustParseAddr("192.0.2.53")},
+	}}
+
+	tests := []struct {
+		name    string
+		limit   int
+		answers int
+		tc      bool
+		// the question and the OPT record are still there
+		question bool
+		edns     bool
+	}{
+		{"everything fits", 4096, 40, false, true, true},
+		// the answers are one RRset, dropping the additional section isn't enough
+		{"answer RRset dropped", 512, 0, true, true, true},
+		{"only the header and OPT fit", 100, 0, true, false, true},
+		{"only the header fits", 20, 0, true, false, false},
+		{"exactly the header", 12, 0, true, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := message.EncodeMessageWithLimit(test.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(encoded) > test.limit {
+				t.Fatalf("%d octets are over the limit of %d", len(encoded), test.limit)
+			}
+			decoded, err := DecodeMessage(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(decoded.Answers) != test.answers || decoded.Header.TC != test.tc ||
+				(len(decoded.Questions) == 1) != test.question || (decoded.EDNS != nil) != test.edns {
+				t.Fatalf("expected %d answers, TC %t, question %t and EDNS %t, got %d answers, TC %t, %d questions and EDNS %t",
+					test.answers, test.tc, test.question, test.edns,
+					len(decoded.Answers), decoded.Header.TC, len(decoded.Questions), decoded.EDNS != nil)
+			}
+		})
+	}
+
+	if _, err := message.EncodeMessageWithLimit(11); err == nil {
+		t.Fatal("a limit smaller than the header must fail")
+	}
+	if len(message.Answers) != 40 || len(message.Additional) != 1 || message.Header.TC {
+		t.Fatal("the message was modified")
+	}
+}
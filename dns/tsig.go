@@ -0,0 +1,207 @@
+package dns
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrTSIGNotLast is returned when a TSIG record is not the last record of the message (RFC 8945 section 5.1)
+var ErrTSIGNotLast = errors.New("the TSIG record must be the last record of the message")
+
+// TSIG transaction signature (RFC 8945 section 4.2), a meta record only found at the end of
+// the additional section. It's never part of a zone, its NAME is the key name and its CLASS is ANY
+type TSIG struct {
+	// name of the HMAC algorithm, e.g. hmac-sha256.
+	ALGORITHM string
+	// seconds since the epoch, 48 bits on the wire
+	TIMESIGNED uint64
+	// seconds of error allowed in TIMESIGNED
+	FUDGE uint16
+	MAC   []byte
+	// ID of the message when it was signed, forwarders may change the header one
+	ORIGINALID uint16
+	// extended RCODE covering the TSIG processing, e.g. BADSIG
+	ERROR uint16
+	// the server time in BADTIME responses, empty otherwise
+	OTHERDATA []byte
+}
+
+// rendered like dig does: algorithm, time, fudge, MAC size, MAC, original ID, error, other data size and other data
+func (t *TSIG) String() string {
+	return fmt.Sprintf("%s %d %d %d %s %d %s %d %s", presentName(t.ALGORITHM), t.TIMESIGNED, t.FUDGE, len(t.MAC),
+		base64.StdEncoding.EncodeToString(t.MAC), t.ORIGINALID, tsigErrorString(t.ERROR), len(t.OTHERDATA),
+		base64.StdEncoding.EncodeToString(t.OTHERDATA))
+}
+
+// 16 is BADVERS in a header but BADSIG in a TSIG record
+func tsigErrorString(rcode uint16) string {
+	if rcode == RcodeBadSig {
+		return "BADSIG"
+	}
+	return RcodeString(rcode)
+}
+
+// the algorithm name is never compressed (RFC 8945 section 4.2)
+func (t *TSIG) pack(e *encoder) error {
+	if err := e.writeName(t.ALGORITHM, false); err != nil {
+		return err
+	}
+	if t.TIMESIGNED>>48 != 0 {
+		return fmt.Errorf("the TSIG time %d doesn't fit in 48 bits", t.TIMESIGNED)
+	}
+	e.writeUint16(uint16(t.TIMESIGNED >> 32))
+	e.writeUint32(uint32(t.TIMESIGNED))
+	e.writeUint16(t.FUDGE)
+	if len(t.MAC) > 0xFFFF || len(t.OTHERDATA) > 0xFFFF {
+		return fmt.Errorf("the TSIG MAC or other data has more than 65535 octets")
+	}
+	e.writeUint16(uint16(len(t.MAC)))
+	e.buf = append(e.buf, t.MAC...)
+	e.writeUint16(t.ORIGINALID)
+	e.writeUint16(t.ERROR)
+	e.writeUint16(uint16(len(t.OTHERDATA)))
+	e.buf = append(e.buf, t.OTHERDATA...)
+	return nil
+}
+
+func (t *TSIG) unpack(r *reader, length int) (err error) {
+	if t.ALGORITHM, err = r.name(); err != nil {
+		return err
+	}
+	high, err := r.uint16()
+	if err != nil {
+		return err
+	}
+	low, err := r.uint32()
+	if err != nil {
+		return err
+	}
+	t.TIMESIGNED = uint64(high)<<32 | uint64(low)
+	if t.FUDGE, err = r.uint16(); err != nil {
+		return err
+	}
+	macSize, err := r.uint16()
+	if err != nil {
+		return err
+	}
+	if t.MAC, err = r.bytes(int(macSize)); err != nil {
+		return err
+	}
+	if t.ORIGINALID, err = r.uint16(); err != nil {
+		return err
+	}
+	if t.ERROR, err = r.uint16(); err != nil {
+		return err
+	}
+	otherSize, err := r.uint16()
+	if err != nil {
+		return err
+	}
+	t.OTHERDATA, err = r.bytes(int(otherSize))
+	return err
+}
+
+func (t *TSIG) parse(fields []string, origin string) error {
+	return fmt.Errorf("TSIG records only exist in messages, they can't be written in a zone")
+}
+
+// AppendTSIG adds the TSIG record at the end of an encoded message, counting it in ARCOUNT
+func AppendTSIG(wire []byte, record Answer) ([]byte, error) {
+	if len(wire) < 12 {
+		return nil, ErrTruncated
+	}
+	arcount := binary.BigEndian.Uint16(wire[10:12])
+	if arcount == 0xFFFF {
+		return nil, fmt.Errorf("the additional section is full, there is no room for the TSIG record")
+	}
+
+	// without compression the record can be stripped off again without touching the rest
+	e := &encoder{buf: wire}
+	if err := record.encode(e); err != nil {
+		return nil, fmt.Errorf("failed to encode the TSIG record, cause: %w", err)
+	}
+	binary.BigEndian.PutUint16(e.buf[10:12], arcount+1)
+	return e.buf, nil
+}
+
+// SplitTSIG returns a copy of the message without its TSIG record, with ARCOUNT decremented,
+// and the record. false when the message isn't signed
+func SplitTSIG(wire []byte) ([]byte, Answer, bool, error) {
+	r := &reader{buf: wire}
+	header, err := decodeHeader(r)
+	if err != nil {
+		return nil, Answer{}, false, err
+	}
+	for range int(header.QDCOUNT) {
+		if _, err := decodeQuestion(r); err != nil {
+			return nil, Answer{}, false, err
+		}
+	}
+
+	total := int(header.ANCOUNT) + int(header.NSCOUNT) + int(header.ARCOUNT)
+	for i := range total {
+		start := r.off
+		h, err := decodeRecordHeader(r)
+		if err != nil {
+			return nil, Answer{}, false, err
+		}
+		if getRecordTypeString(h.rtype) != "TSIG" {
+			if _, err := r.bytes(int(h.rdlength)); err != nil {
+				return nil, Answer{}, false, err
+			}
+			continue
+		}
+		if i != total-1 || i < total-int(header.ARCOUNT) {
+			return nil, Answer{}, false, ErrTSIGNotLast
+		}
+		record, err := decodeAnswerBody(r, h)
+		if err != nil {
+			return nil, Answer{}, false, err
+		}
+		if _, ok := record.RDATA.(*TSIG); !ok || r.remaining() > 0 {
+			return nil, Answer{}, false, ErrBadRdata
+		}
+
+		unsigned := append([]byte{}, wire[:start]...)
+		binary.BigEndian.PutUint16(unsigned[10:12], header.ARCOUNT-1)
+		return unsigned, record, true, nil
+	}
+	return nil, Answer{}, false, nil
+}
+
+// TSIGVariables returns the TSIG fields covered by the MAC (RFC 8945 section 4.3.3). The names
+// are in canonical form, lower case and uncompressed. timersOnly keeps only the time signed and
+// fudge, as used by the messages following the first one of a zone transfer (section 5.3.1)
+func TSIGVariables(record Answer, timersOnly bool) ([]byte, error) {
+	t, ok := record.RDATA.(*TSIG)
+	if !ok {
+		return nil, fmt.Errorf("the %s record is not a TSIG", record.TYPE)
+	}
+	e := &encoder{}
+	if !timersOnly {
+		if err := e.writeName(strings.ToLower(record.NAME), false); err != nil {
+			return nil, err
+		}
+		class, err := getRecordClassUint16(record.CLASS)
+		if err != nil {
+			return nil, err
+		}
+		e.writeUint16(class)
+		e.writeUint32(uint32(record.TTL))
+		if err := e.writeName(strings.ToLower(t.ALGORITHM), false); err != nil {
+			return nil, err
+		}
+	}
+	e.writeUint16(uint16(t.TIMESIGNED >> 32))
+	e.writeUint32(uint32(t.TIMESIGNED))
+	e.writeUint16(t.FUDGE)
+	if !timersOnly {
+		e.writeUint16(t.ERROR)
+		e.writeUint16(uint16(len(t.OTHERDATA)))
+		e.buf = append(e.buf, t.OTHERDATA...)
+	}
+	return e.buf, nil
+}
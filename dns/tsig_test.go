@@ -0,0 +1,122 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func tsigRecord() Answer {
+	return Answer{NAME: "transfer.example.com.", TYPE: "TSIG", CLASS: "ANY", RDATA: &TSIG{
+		ALGORITHM:  "hmac-sha256.",
+		TIMESIGNED: 1594855491,
+		FUDGE:      300,
+		MAC:        bytes.Repeat([]byte{0xAB}, 32),
+		ORIGINALID: 0x1234,
+	}}
+}
+
+func TestSplitTSIG(t *testing.T) {
+	message := &Message{
+		Header:    Header{ID: 0x1234, QR: true},
+		Questions: []*Question{{QNAME: "example.com.", QTYPE: "A", QCLASS: "IN"}},
+		Answers: []Answer{{
+			NAME: "example.com.", TYPE: "A", CLASS: "IN", TTL: 60,
+			RDATA: &A{ADDRESS: netip.MustParseAddr("192.0.2.1")},
+		}},
+		Additional: []Answer{{
+			NAME: "ns.example.com.", TYPE: "A", CLASS: "IN", TTL: 60,
+			RDATA: &A{ADDRESS: netip.MustParseAddr("192.0.2.2")},
+		}},
+	}
+	wire, err := message.EncodeMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := bytes.Clone(wire)
+
+	signed, err := AppendTSIG(wire, tsigRecord())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if arcount := binary.BigEndian.Uint16(signed[10:12]); arcount != 2 {
+		t.Fatalf("expected the TSIG to be counted in ARCOUNT, got %d", arcount)
+	}
+
+	unsigned, record, ok, err := SplitTSIG(signed)
+	if err != nil || !ok {
+		t.Fatalf("expected a TSIG record, got %t and %v", ok, err)
+	}
+	if !bytes.Equal(unsigned, prefix) {
+		t.Fatalf("expected the message as it was before signing\n%x\ngot\n%x", prefix, unsigned)
+	}
+	if got, expected := record.RDATA.String(), tsigRecord().RDATA.String(); got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+	// the signed message is left as it was
+	if arcount := binary.BigEndian.Uint16(signed[10:12]); arcount != 2 {
+		t.Fatalf("the signed message was modified, ARCOUNT is %d", arcount)
+	}
+
+	if _, _, ok, err := SplitTSIG(prefix); ok || err != nil {
+		t.Fatalf("expected no TSIG in the unsigned message, got %t and %v", ok, err)
+	}
+}
+
+func TestSplitTSIGNotLast(t *testing.T) {
+	other := Answer{
+		NAME: "example.com.", TYPE: "A", CLASS: "IN", TTL: 60,
+		RDATA: &A{ADDRESS: netip.MustParseAddr("192.0.2.1")},
+	}
+	tests := []struct {
+		name    string
+		message *Message
+	}{
+		{"followed by another record", &Message{Additional: []Answer{tsigRecord(), other}}},
+		{"in the answer section", &Message{Answers: []Answer{tsigRecord()}}},
+		{"last of the authority section", &Message{Authority: []Answer{tsigRecord()}, Additional: []Answer{}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wire, err := test.message.EncodeMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, _, err := SplitTSIG(wire); !errors.Is(err, ErrTSIGNotLast) {
+				t.Fatalf("expected %v, got %v", ErrTSIGNotLast, err)
+			}
+		})
+	}
+}
+
+// the TSIG variables of RFC 8945 section 4.3.3, names in lower case and uncompressed
+func TestTSIGVariables(t *testing.T) {
+	record := tsigRecord()
+	record.NAME = "Transfer.Example.COM."
+	record.RDATA.(*TSIG).OTHERDATA = []byte{0, 0, 0x5F, 0x0F, 0x90, 0x43}
+
+	all, err := TSIGVariables(record, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := packet(
+		[]byte("\x08transfer\x07example\x03com\x00"),
+		[]byte{0x00, 0xFF, 0, 0, 0, 0},
+		[]byte("\x0bhmac-sha256\x00"),
+		[]byte{0x00, 0x00, 0x5F, 0x0F, 0x90, 0x43, 0x01, 0x2C},
+		[]byte{0x00, 0x00, 0x00, 0x06, 0, 0, 0x5F, 0x0F, 0x90, 0x43},
+	)
+	if !bytes.Equal(all, expected) {
+		t.Fatalf("expected\n%x\ngot\n%x", expected, all)
+	}
+
+	timers, err := TSIGVariables(record, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x00, 0x00, 0x5F, 0x0F, 0x90, 0x43, 0x01, 0x2C}; !bytes.Equal(timers, expected) {
+		t.Fatalf("expected the timers %x, got %x", expected, timers)
+	}
+}
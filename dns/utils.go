@@ -20,68 +20,31 @@ func FormatHexTraillingZeros(hex string, desiredLength int) string {
 // Labels are encoded as <length><content>
 // Length is a single byte representing the length of the label/content
 // content has size length in bytes
-// sequence of labels is terminated by a null byte \x00
+// sequence of labels is terminated by a null byte \x00 or by a compression pointer (2 bytes, first 2 bits set)
 // google.com -> \x06google\x03com\x00 -> 06 67 6f 6f 67 6c 65 03 63 6f 6d 00 -> label 1: \x06google, label 2: \x03com, null byte: \x00
-// TODO: DNS labels have a 63 octets limit
-// TODO: names have a 255 octets limit
-func encodeDomainName(name string) ([]byte, error) {
-	var buf []byte
-	splitted := strings.SplitSeq(name, ".")
-	for domainPart := range splitted {
-		runes := []rune(domainPart)
-		if len(runes) > 63 {
-			return []byte{}, fmt.Errorf("the domain part %s has more than 63 octets", domainPart)
-		}
-		buf = append(buf, byte(len(runes)))
-		for _, r := range runes {
-			buf = append(buf, byte(r))
-		}
+// see { encoder.writeName } for the encoding side and { reader.name } for the decoding side
 
-		if len(buf) > 255 {
-			return []byte{}, fmt.Errorf("the domain %s has more than 255 octets", name)
-		}
+// Fqdn returns the name with the final dot of the root label
+func Fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
 	}
-
-	buf = append(buf, 0x00)
-	return buf, nil
+	return name + "."
 }
 
-// will recieve the buffer from 12:n beeing 12 the end of the header section;
-// returns the domainName as string, size, bool indicating compression and error
-// dont need to remove the last dot as the real domain name always has this final dot
-func decodeDomainName(buf []byte, startPos int) (string, int, bool, error) {
-	str := ""
-	curr := startPos
-	usesCompression := false
-	sizeUntilCompression := 0
-
-	for len(str) < 255+3 && buf[curr] != 0x00 {
-		firstByte := int(buf[curr])
-		isCompressed := firstByte>>6 == 0x03
-		if isCompressed {
-			usesCompression = true
-			// pointer is the "last" 6 bits from the length byte
-			curr = int(firstByte & 0x3F)
-			sizeUntilCompression = len([]rune(str))
-			continue
-		}
-		if firstByte > 63 {
-			return "", 0, usesCompression, fmt.Errorf("label has more than 63 octets")
-		}
-		str += string(buf[curr+1 : curr+firstByte+1])
-		str += "."
-		curr += firstByte + 1
+// IsSubdomain reports whether child is parent itself or a name below it, case insensitive
+func IsSubdomain(child string, parent string) bool {
+	child = strings.ToLower(Fqdn(child))
+	parent = strings.ToLower(Fqdn(parent))
+	if parent == "." || child == parent {
+		return true
 	}
+	return strings.HasSuffix(child, "."+parent)
+}
 
-	// weird that the str
-	size := func() int {
-		firstLengthByte := 1
-		if usesCompression {
-			return sizeUntilCompression + firstLengthByte
-		}
-		return len([]rune(str)) + firstLengthByte
-	}()
-	return str, size, usesCompression, nil
+// EqualNames compares two domain names, case insensitive and ignoring the final dot
+func EqualNames(a string, b string) bool {
+	return strings.EqualFold(Fqdn(a), Fqdn(b))
 }
 
 func getRecordTypeUint16(recordType string) (uint16, error) {
@@ -118,63 +81,116 @@ func getRecordTypeUint16(recordType string) (uint16, error) {
 		return 15, nil
 	case "TXT":
 		return 16, nil
+	case "AAAA":
+		return 28, nil
+	case "SRV":
+		return 33, nil
+	case "NAPTR":
+		return 35, nil
+	case "DNAME":
+		return 39, nil
+	case "OPT":
+		return 41, nil
+	case "SSHFP":
+		return 44, nil
+	case "TLSA":
+		return 52, nil
+	case "SVCB":
+		return 64, nil
+	case "HTTPS":
+		return 65, nil
+	case "CAA":
+		return 257, nil
+	case "TSIG":
+		return 250, nil
+	case "IXFR":
+		return 251, nil
 	case "AXFR":
 		return 252, nil
 	case "MAILB":
 		return 253, nil
 	case "MAILA":
 		return 254, nil
-	case "*":
+	case "*", "ANY":
 		return 255, nil
 	default:
-		return 0, fmt.Errorf("invalid question QTYPE, could not parse")
+		// RFC 3597 generic form for types without a mnemonic
+		if code, ok := parseGenericCode(recordType, "TYPE"); ok {
+			return code, nil
+		}
+		return 0, fmt.Errorf("invalid record type %s, could not parse", recordType)
 	}
 }
 
-func getRecordTypeString(code uint16) (string, error) {
+// types without a mnemonic are represented as TYPE<code> (RFC 3597 section 5)
+func getRecordTypeString(code uint16) string {
 	switch code {
 	case 1:
-		return "A", nil
+		return "A"
 	case 2:
-		return "NS", nil
+		return "NS"
 	case 3:
-		return "MD", nil
+		return "MD"
 	case 4:
-		return "MF", nil
+		return "MF"
 	case 5:
-		return "CNAME", nil
+		return "CNAME"
 	case 6:
-		return "SOA", nil
+		return "SOA"
 	case 7:
-		return "MB", nil
+		return "MB"
 	case 8:
-		return "MG", nil
+		return "MG"
 	case 9:
-		return "MR", nil
+		return "MR"
 	case 10:
-		return "NULL", nil
+		return "NULL"
 	case 11:
-		return "WKS", nil
+		return "WKS"
 	case 12:
-		return "PTR", nil
+		return "PTR"
 	case 13:
-		return "HINFO", nil
+		return "HINFO"
 	case 14:
-		return "MINFO", nil
+		return "MINFO"
 	case 15:
-		return "MX", nil
+		return "MX"
 	case 16:
-		return "TXT", nil
+		return "TXT"
+	case 28:
+		return "AAAA"
+	case 33:
+		return "SRV"
+	case 35:
+		return "NAPTR"
+	case 39:
+		return "DNAME"
+	case 41:
+		return "OPT"
+	case 44:
+		return "SSHFP"
+	case 52:
+		return "TLSA"
+	case 64:
+		return "SVCB"
+	case 65:
+		return "HTTPS"
+	case 257:
+		return "CAA"
+	case 250:
+		return "TSIG"
+	case 251:
+		return "IXFR"
 	case 252:
-		return "AXFR", nil
+		return "AXFR"
 	case 253:
-		return "MAILB", nil
+		return "MAILB"
 	case 254:
-		return "MAILA", nil
+		return "MAILA"
 	case 255:
-		return "*", nil
+		return "*"
 	default:
-		return "", fmt.Errorf("invalid record type code: %d", code)
+		return fmt.Sprintf("TYPE%d", code)
 	}
 }
 
@@ -188,46 +204,67 @@ func getRecordClassUint16(class string) (uint16, error) {
 		return 3, nil
 	case "HS":
 		return 4, nil
+	case "NONE":
+		return 254, nil
+	case "ANY", "*":
+		return 255, nil
 	default:
-		return 0, fmt.Errorf("invalid question QCLASS, could not parse")
+		// RFC 3597 generic form for classes without a mnemonic
+		if code, ok := parseGenericCode(class, "CLASS"); ok {
+			return code, nil
+		}
+		return 0, fmt.Errorf("invalid record class %s, could not parse", class)
 	}
 }
 
-func getRecordClassString(code uint16) (string, error) {
+// classes without a mnemonic are represented as CLASS<code> (RFC 3597 section 5)
+func getRecordClassString(code uint16) string {
 	switch code {
 	case 1:
-		return "IN", nil
+		return "IN"
 	case 2:
-		return "CS", nil
+		return "CS"
 	case 3:
-		return "CH", nil
+		return "CH"
 	case 4:
-		return "HS", nil
+		return "HS"
+	case 254:
+		return "NONE"
+	case 255:
+		return "ANY"
 	default:
-		return "", fmt.Errorf("invalid record class code: %d", code)
+		return fmt.Sprintf("CLASS%d", code)
 	}
 }
 
-func bytesFromIPAdress(ipaddr string) ([]byte, error) {
-	var buf []byte
-	for s := range strings.SplitSeq(ipaddr, ".") {
-		number, err := strconv.Atoi(s)
-		if err != nil {
-			return []byte{}, err
-		}
-		buf = append(buf, byte(number))
+// parseGenericCode parses <prefix><decimal code>, e.g. TYPE12345
+func parseGenericCode(value string, prefix string) (uint16, bool) {
+	number, ok := strings.CutPrefix(strings.ToUpper(value), prefix)
+	if !ok || number == "" {
+		return 0, false
 	}
-
-	return buf, nil
+	code, err := strconv.ParseUint(number, 10, 16)
+	if err != nil {
+		return 0, false
+	}
+	return uint16(code), true
 }
 
-func filterIndexOut[T interface{}](lst []T, idx int) []T {
-	var l []T
-	for i, item := range lst {
-		if i != idx {
-			l = append(l, item)
-		}
+// ParseType returns the canonical representation of a record type, which is
+// the mnemonic when there is one (TYPE1 -> A, aaaa -> AAAA) and TYPE<code> otherwise
+func ParseType(recordType string) (string, error) {
+	code, err := getRecordTypeUint16(recordType)
+	if err != nil {
+		return "", err
 	}
+	return getRecordTypeString(code), nil
+}
 
-	return l
+// ParseClass returns the canonical representation of a class, see { ParseType }
+func ParseClass(class string) (string, error) {
+	code, err := getRecordClassUint16(class)
+	if err != nil {
+		return "", err
+	}
+	return getRecordClassString(code), nil
 }
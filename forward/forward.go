@@ -0,0 +1,231 @@
+package forward
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alissonbk/dns-server/client"
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/server"
+)
+
+// Policy decides the order the upstreams are tried for each query
+type Policy int
+
+const (
+	// RoundRobin starts each query on the next upstream
+	RoundRobin Policy = iota
+	// FastestFirst tries the upstreams ordered by their smoothed round trip time
+	FastestFirst
+)
+
+const (
+	// consecutive failures before an upstream is considered down
+	maxFailures = 3
+	// weight of the newest sample in the smoothed RTT
+	rttSmoothing               = 0.3
+	defaultHealthCheckInterval = 10 * time.Second
+	// stub resolvers usually give up on a query after 5s, there is no point in trying upstreams after that
+	defaultQueryTimeout = 5 * time.Second
+)
+
+// Upstream is a resolver queries are forwarded to
+type Upstream struct {
+	// host:port
+	Addr string
+
+	mutex    sync.Mutex
+	healthy  bool
+	failures int
+	// smoothed round trip time, zero until the first response
+	rtt time.Duration
+}
+
+func (u *Upstream) Healthy() bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.healthy
+}
+
+func (u *Upstream) RTT() time.Duration {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.rtt
+}
+
+func (u *Upstream) success(rtt time.Duration) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.healthy = true
+	u.failures = 0
+	if u.rtt == 0 {
+		u.rtt = rtt
+	} else {
+		u.rtt = time.Duration(rttSmoothing*float64(rtt) + (1-rttSmoothing)*float64(u.rtt))
+	}
+}
+
+func (u *Upstream) failure() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.failures++
+	if u.failures >= maxFailures && u.healthy {
+		u.healthy = false
+		log.Printf("upstream %s marked as down after %d failures", u.Addr, u.failures)
+	}
+}
+
+// Forwarder is a server.Handler relaying every query to upstream resolvers.
+// Each query goes to the upstreams in the order given by the Policy, healthy ones first,
+// failing over to the next one on errors, SERVFAIL or REFUSED
+type Forwarder struct {
+	Upstreams []*Upstream
+	Policy    Policy
+	Client    *client.Client
+	// how often the upstreams are probed by StartHealthChecks, defaults to 10s
+	HealthCheckInterval time.Duration
+	// time allowed for a query over all the upstreams tried, defaults to 5s
+	QueryTimeout time.Duration
+
+	next atomic.Uint32
+}
+
+// New creates a forwarder for the upstream addresses (host:port), all starting as healthy
+func New(addrs []string, policy Policy) *Forwarder {
+	upstreams := make([]*Upstream, len(addrs))
+	for i, addr := range addrs {
+		upstreams[i] = &Upstream{Addr: addr, healthy: true}
+	}
+	return &Forwarder{Upstreams: upstreams, Policy: policy, Client: &client.Client{}}
+}
+
+func (f *Forwarder) ServeDNS(req *server.Request) (*dns.Message, error) {
+	if len(f.Upstreams) == 0 {
+		return nil, fmt.Errorf("no upstream configured")
+	}
+
+	query := &dns.Message{
+		Header:    dns.Header{OPCODE: req.Message.Header.OPCODE, RD: true},
+		Questions: req.Message.Questions,
+		EDNS:      &dns.EDNS{},
+	}
+	if req.Message.EDNS != nil {
+		query.EDNS.DO = req.Message.EDNS.DO
+	}
+
+	timeout := f.QueryTimeout
+	if timeout <= 0 {
+		timeout = defaultQueryTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var lastResponse *dns.Message
+	var lastErr error
+	for _, upstream := range f.order() {
+		if ctx.Err() != nil {
+			break
+		}
+		response, rtt, err := f.Client.Exchange(ctx, query, upstream.Addr)
+		if err != nil {
+			// running out of time is not the upstream's fault
+			if ctx.Err() == nil {
+				upstream.failure()
+			}
+			lastErr = fmt.Errorf("upstream %s failed, cause: %w", upstream.Addr, err)
+			continue
+		}
+		upstream.success(rtt)
+
+		// another upstream may have a better answer
+		if response.Header.RCODE == dns.RcodeServFail || response.Header.RCODE == dns.RcodeRefused {
+			lastResponse = response
+			continue
+		}
+		return response, nil
+	}
+
+	if lastResponse != nil {
+		return lastResponse, nil
+	}
+	if lastErr == nil {
+		lastErr = ctx.Err()
+	}
+	return nil, lastErr
+}
+
+// order returns the upstreams to try for a query: healthy ones first in policy order,
+// then the ones marked as down as a last resort
+func (f *Forwarder) order() []*Upstream {
+	upstreams := slices.Clone(f.Upstreams)
+
+	switch f.Policy {
+	case FastestFirst:
+		// upstreams never measured have rtt 0, so they get a chance to be measured
+		slices.SortStableFunc(upstreams, func(a, b *Upstream) int {
+			return cmp.Compare(a.RTT(), b.RTT())
+		})
+	default:
+		// the modulo is done before the conversion, a negative int would make the slicing panic
+		start := int((f.next.Add(1) - 1) % uint32(len(upstreams)))
+		upstreams = append(upstreams[start:], upstreams[:start]...)
+	}
+
+	slices.SortStableFunc(upstreams, func(a, b *Upstream) int {
+		if a.Healthy() == b.Healthy() {
+			return 0
+		}
+		if a.Healthy() {
+			return -1
+		}
+		return 1
+	})
+	return upstreams
+}
+
+// StartHealthChecks probes every upstream periodically with a query for the root NS,
+// bringing back the ones that recovered and keeping the RTT of idle ones up to date.
+// it runs until the context is done
+func (f *Forwarder) StartHealthChecks(ctx context.Context) {
+	interval := f.HealthCheckInterval
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for _, upstream := range f.Upstreams {
+					go f.probe(ctx, upstream)
+				}
+			}
+		}
+	}()
+}
+
+func (f *Forwarder) probe(ctx context.Context, upstream *Upstream) {
+	query := &dns.Message{
+		Header:    dns.Header{RD: true},
+		Questions: []*dns.Question{{QNAME: ".", QTYPE: "NS", QCLASS: "IN"}},
+	}
+	response, rtt, err := f.Client.Exchange(ctx, query, upstream.Addr)
+	if err != nil || response.Header.RCODE == dns.RcodeServFail {
+		upstream.failure()
+		return
+	}
+	if !upstream.Healthy() {
+		log.Printf("upstream %s is back up", upstream.Addr)
+	}
+	upstream.success(rtt)
+}
@@ -0,0 +1,327 @@
+package forward
+
+import (
+	"math"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alissonbk/dns-server/client"
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/server"
+)
+
+// the round robin counter wraps around without ever giving a negative start
+func TestOrderWrapsAround(t *testing.T) {
+	f := New([]string{"192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53"}, RoundRobin)
+	f.next.Store(math.MaxUint32 - 1)
+	// 2^32 isn't a multiple of 3, the wrap starts the sequence again from the first upstream
+	expected := []string{
+		"192.0.2.3:53 192.0.2.1:53 192.0.2.2:53",
+		"192.0.2.1:53 192.0.2.2:53 192.0.2.3:53",
+		"192.0.2.1:53 192.0.2.2:53 192.0.2.3:53",
+		"192.0.2.2:53 192.0.2.3:53 192.0.2.1:53",
+	}
+	for i, order := range expected {
+		if got := addrs(f.order()); got != order {
+			t.Fatalf("call %d: expected %s, got %s", i, order, got)
+		}
+	}
+}
+
+func addrs(upstreams []*Upstream) string {
+	var addrs []string
+	for _, upstream := range upstreams {
+		addrs = append(addrs, upstream.Addr)
+	}
+	return strings.Join(addrs, " ")
+}
+
+func TestOrderFastestFirst(t *testing.T) {
+	f := New([]string{"192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53", "192.0.2.4:53"}, FastestFirst)
+	f.Upstreams[0].success(30 * time.Millisecond)
+	f.Upstreams[1].success(10 * time.Millisecond)
+	f.Upstreams[2].success(20 * time.Millisecond)
+	// never measured, it's tried first to get measured
+	if got := addrs(f.order()); got != "192.0.2.4:53 192.0.2.2:53 192.0.2.3:53 192.0.2.1:53" {
+		t.Fatalf("unexpected order %s", got)
+	}
+
+	// the RTT is smoothed, a single slow response doesn't make the fastest one the slowest
+	f.Upstreams[1].success(40 * time.Millisecond)
+	if rtt := f.Upstreams[1].RTT(); rtt != 19*time.Millisecond {
+		t.Fatalf("expected a smoothed RTT of 19ms, got %s", rtt)
+	}
+	f.Upstreams[3].success(50 * time.Millisecond)
+	if got := addrs(f.order()); got != "192.0.2.2:53 192.0.2.3:53 192.0.2.1:53 192.0.2.4:53" {
+		t.Fatalf("unexpected order %s", got)
+	}
+
+	// upstreams down come last whatever their RTT
+	for range maxFailures {
+		f.Upstreams[1].failure()
+	}
+	if got := addrs(f.order()); got != "192.0.2.3:53 192.0.2.1:53 192.0.2.4:53 192.0.2.2:53" {
+		t.Fatalf("unexpected order %s", got)
+	}
+}
+
+// respond gives the messages a fake upstream sends back to a query received over network,
+// they're written as they are so they can be wrong in any way
+type respond func(network string, query *dns.Message) []*dns.Message
+
+// startUpstream serves respond over UDP and TCP on the same loopback port, returns its address
+func startUpstream(t *testing.T, respond respond) string {
+	t.Helper()
+	// the UDP socket takes the port of the TCP listener, which may be taken already
+	var l net.Listener
+	var conn *net.UDPConn
+	for range 10 {
+		var err error
+		if l, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		if conn, err = net.ListenUDP("udp", net.UDPAddrFromAddrPort(l.Addr().(*net.TCPAddr).AddrPort())); err == nil {
+			break
+		}
+		l.Close()
+	}
+	if conn == nil {
+		t.Skip("can't bind UDP and TCP to the same loopback port")
+	}
+	t.Cleanup(func() {
+		l.Close()
+		conn.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, source, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			query, err := dns.DecodeMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, response := range respond("udp", query) {
+				if payload, err := response.EncodeMessage(); err == nil {
+					conn.WriteToUDP(payload, source)
+				}
+			}
+		}
+	}()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				payload, err := dns.ReadTCP(c)
+				if err != nil {
+					return
+				}
+				query, err := dns.DecodeMessage(payload)
+				if err != nil {
+					return
+				}
+				for _, response := range respond("tcp", query) {
+					if payload, err := response.EncodeMessage(); err == nil {
+						dns.WriteTCP(c, payload)
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// reply answers the query with an A record, keeping its ID and question
+func reply(query *dns.Message, rcode uint16, address string) *dns.Message {
+	response := &dns.Message{
+		Header:    dns.Header{ID: query.Header.ID, QR: true, RD: true, RA: true, RCODE: rcode},
+		Questions: query.Questions,
+	}
+	if address != "" {
+		response.Answers = []dns.Answer{{
+			NAME: query.Questions[0].QNAME, TYPE: "A", CLASS: "IN", TTL: 60,
+			RDATA: &dns.A{ADDRESS: netip.MustParseAddr(address)},
+		}}
+	}
+	return response
+}
+
+func request(id uint16) *server.Request {
+	return &server.Request{Message: &dns.Message{
+		Header:    dns.Header{ID: id, RD: true},
+		Questions: []*dns.Question{{QNAME: "www.example.com.", QTYPE: "A", QCLASS: "IN"}},
+	}}
+}
+
+func address(response *dns.Message) string {
+	if len(response.Answers) != 1 {
+		return ""
+	}
+	return response.Answers[0].RDATA.String()
+}
+
+// the upstream never sees the ID of the client, and responses to another ID or question are discarded
+func TestServeDNSMatching(t *testing.T) {
+	ids := make(chan uint16, 10)
+	addr := startUpstream(t, func(network string, query *dns.Message) []*dns.Message {
+		ids <- query.Header.ID
+		otherID := reply(query, dns.RcodeNoError, "192.0.2.1")
+		otherID.Header.ID++
+		otherQuestion := reply(query, dns.RcodeNoError, "192.0.2.2")
+		otherQuestion.Questions = []*dns.Question{{QNAME: "mail.example.com.", QTYPE: "A", QCLASS: "IN"}}
+		return []*dns.Message{otherID, otherQuestion, reply(query, dns.RcodeNoError, "192.0.2.3")}
+	})
+	f := New([]string{addr}, RoundRobin)
+	f.Client = &client.Client{Timeout: time.Second}
+
+	for range 5 {
+		response, err := f.ServeDNS(request(0xBEEF))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if address(response) != "192.0.2.3" {
+			t.Fatalf("expected the matching response, got %v", response.Answers)
+		}
+	}
+	seen := map[uint16]bool{}
+	for range 5 {
+		seen[<-ids] = true
+	}
+	if len(seen) < 2 || seen[0xBEEF] {
+		t.Fatalf("expected a new random ID for every query, got %v", seen)
+	}
+}
+
+// an upstream answering only with responses to other queries is a failure, the next one is tried
+func TestServeDNSMismatchFailover(t *testing.T) {
+	spoofed := startUpstream(t, func(network string, query *dns.Message) []*dns.Message {
+		response := reply(query, dns.RcodeNoError, "192.0.2.1")
+		response.Questions = []*dns.Question{{QNAME: "www.example.net.", QTYPE: "A", QCLASS: "IN"}}
+		return []*dns.Message{response}
+	})
+	good := startUpstream(t, func(network string, query *dns.Message) []*dns.Message {
+		return []*dns.Message{reply(query, dns.RcodeNoError, "192.0.2.2")}
+	})
+	f := New([]string{spoofed, good}, RoundRobin)
+	f.Client = &client.Client{Timeout: 100 * time.Millisecond}
+
+	response, err := f.ServeDNS(request(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address(response) != "192.0.2.2" {
+		t.Fatalf("expected the response of the second upstream, got %v", response.Answers)
+	}
+}
+
+func TestServeDNSTruncated(t *testing.T) {
+	networks := make(chan string, 10)
+	addr := startUpstream(t, func(network string, query *dns.Message) []*dns.Message {
+		networks <- network
+		if network == "udp" {
+			truncated := reply(query, dns.RcodeNoError, "")
+			truncated.Header.TC = true
+			return []*dns.Message{truncated}
+		}
+		return []*dns.Message{reply(query, dns.RcodeNoError, "192.0.2.1")}
+	})
+	f := New([]string{addr}, RoundRobin)
+	f.Client = &client.Client{Timeout: time.Second}
+
+	response, err := f.ServeDNS(request(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Header.TC || address(response) != "192.0.2.1" {
+		t.Fatalf("expected the complete response over TCP, got TC %t with %v", response.Header.TC, response.Answers)
+	}
+	if len(networks) != 2 || <-networks != "udp" || <-networks != "tcp" {
+		t.Fatal("expected a query over UDP then one over TCP")
+	}
+}
+
+func TestServeDNSFailover(t *testing.T) {
+	// nothing listens there anymore, the query fails right away
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := conn.LocalAddr().String()
+	conn.Close()
+	servfail := startUpstream(t, func(network string, query *dns.Message) []*dns.Message {
+		return []*dns.Message{reply(query, dns.RcodeServFail, "")}
+	})
+	good := startUpstream(t, func(network string, query *dns.Message) []*dns.Message {
+		return []*dns.Message{reply(query, dns.RcodeNoError, "192.0.2.1")}
+	})
+
+	f := New([]string{down, servfail, good}, FastestFirst)
+	f.Client = &client.Client{Timeout: time.Second}
+	for i := range maxFailures {
+		response, err := f.ServeDNS(request(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if address(response) != "192.0.2.1" {
+			t.Fatalf("query %d: expected the response of the last upstream, got %s", i, dns.RcodeString(response.Header.RCODE))
+		}
+	}
+	if f.Upstreams[0].Healthy() {
+		t.Fatalf("expected %s to be down after %d failures", down, maxFailures)
+	}
+	// SERVFAIL is an answer, the upstream is up but another one may do better
+	if !f.Upstreams[1].Healthy() {
+		t.Fatal("expected the upstream answering SERVFAIL to be up")
+	}
+	if got := addrs(f.order()); !strings.HasSuffix(got, down) {
+		t.Fatalf("expected the upstream down to be tried last, got %s", got)
+	}
+
+	// without a better answer the SERVFAIL is returned
+	f = New([]string{down, servfail}, RoundRobin)
+	f.Client = &client.Client{Timeout: time.Second}
+	response, err := f.ServeDNS(request(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Header.RCODE != dns.RcodeServFail {
+		t.Fatalf("expected SERVFAIL, got %s", dns.RcodeString(response.Header.RCODE))
+	}
+}
+
+// upstreams that never answer make the query fail after QueryTimeout, not after each one timed out
+func TestServeDNSQueryTimeout(t *testing.T) {
+	var addrs []string
+	for range 3 {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		addrs = append(addrs, conn.LocalAddr().String())
+	}
+	f := New(addrs, RoundRobin)
+	f.Client = &client.Client{Timeout: time.Second}
+	f.QueryTimeout = 200 * time.Millisecond
+
+	req := &server.Request{Message: &dns.Message{
+		Questions: []*dns.Question{{QNAME: "example.com.", QTYPE: "A", QCLASS: "IN"}},
+	}}
+	start := time.Now()
+	if _, err := f.ServeDNS(req); err == nil {
+		t.Fatal("expected an error without any answer")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("the query took %s", elapsed)
+	}
+}
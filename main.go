@@ -1,113 +1,166 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"net"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/alissonbk/dns-server/cache"
 	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/forward"
+	"github.com/alissonbk/dns-server/resolver"
+	"github.com/alissonbk/dns-server/server"
+	"github.com/alissonbk/dns-server/tsig"
 )
 
-func createStaticMessage() *dns.Message {
-	header := dns.Header{
-		ID:      1234,
-		QR:      true,
-		OPCODE:  0,
-		AA:      false,
-		TC:      false,
-		RD:      false,
-		RA:      false,
-		Z:       0,
-		RCODE:   0,
-		QDCOUNT: 2,
-		ANCOUNT: 2,
-		NSCOUNT: 0,
-		ARCOUNT: 0,
-	}
-	question1 := &dns.Question{
-		// QNAME:    "fodase.google.com",
-		QNAME:    "google.com",
-		QTYPE:    "A",
-		QCLASS:   "IN",
-		Compress: false,
-	}
-	question2 := &dns.Question{
-		QNAME: "fodase.google.com",
-		// QNAME:    "google.com",
-		QTYPE:    "NULL",
-		QCLASS:   "IN",
-		Compress: true,
-	}
-	answer1 := dns.Answer{
-		NAME:     "fodase.google.com",
-		TYPE:     "A",
-		CLASS:    "IN",
-		TTL:      60,
-		RDLENGTH: 4,
-		RDATA:    "4.4.4.4",
-	}
-	answer2 := dns.Answer{
-		NAME:     "google.com",
-		TYPE:     "SOA",
-		CLASS:    "IN",
-		TTL:      60,
-		RDLENGTH: 4,
-		RDATA:    "8.8.8.8",
-		Compress: true,
-	}
+// assignments collects repeated key=value flags, e.g. -zone origin=file
+type assignments []string
+
+func (a *assignments) String() string {
+	return strings.Join(*a, " ")
+}
 
-	return &dns.Message{
-		Header:    header,
-		Questions: []*dns.Question{question1, question2},
-		Answers:   []dns.Answer{answer1, answer2},
+func (a *assignments) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("expected key=value, got %s", value)
 	}
+	*a = append(*a, value)
+	return nil
+}
 
+// refusedHandler answers REFUSED to everything, it's used when the server has
+// nothing to answer from: no zones, upstreams or recursion
+func refusedHandler(req *server.Request) (*dns.Message, error) {
+	return &dns.Message{Header: dns.Header{RCODE: dns.RcodeRefused}}, nil
 }
 
 func main() {
-	udpAddr, err := net.ResolveUDPAddr("udp", "127.0.0.1:2053")
-	if err != nil {
-		fmt.Println("Failed to resolve UDP address:", err)
-		return
-	}
+	addr := flag.String("addr", "127.0.0.1:2053", "address to listen on, over UDP and TCP")
+	workers := flag.Int("workers", 64, "goroutines handling UDP queries of each socket")
+	reusePort := flag.Int("reuseport", 1, "UDP sockets bound to the address with SO_REUSEPORT, one reader each")
+	upstreams := flag.String("forward", "", "comma separated upstream resolvers (host:port) to forward every query to")
+	policy := flag.String("forward-policy", "roundrobin", "order the upstreams are tried: roundrobin or fastest")
+	recursive := flag.Bool("recursive", false, "resolve queries iteratively starting from the root servers")
+	roots := flag.String("roots", "", "comma separated root servers (host:port) replacing the built in root hints")
+	cacheSize := flag.Int("cache", 32, "megabytes of responses cached when forwarding or resolving, 0 disables the cache")
+	maxStale := flag.Duration("max-stale", 0, "how long expired responses may be served when they can't be refreshed, 0 disables serve-stale")
+	staleTimeout := flag.Duration("stale-timeout", 1800*time.Millisecond, "how long a client waits for a fresh response before a stale one is sent, with -max-stale")
+	prefetch := flag.Int("prefetch", 0, "lookups after which an entry is refreshed before it expires, 0 disables prefetching")
+	prefetchThreshold := flag.Float64("prefetch-threshold", 0.1, "fraction of its TTL an entry has left when it's prefetched, with -prefetch")
+	var zones, secondaries, notify assignments
+	flag.Var(&zones, "zone", "origin=file of a master file to serve authoritatively, may be repeated")
+	flag.Var(&secondaries, "secondary", "origin=primary[,primary] of a zone to copy from its primaries (host:port), may be repeated")
+	secondaryDir := flag.String("secondary-dir", "", "directory keeping the last good copy of each secondary zone, so restarts serve them right away")
+	flag.Var(&notify, "notify", "origin=secondary[,secondary] to send NOTIFY to (host:port) when the zone changes, may be repeated")
+	transferACL := flag.String("transfer-acl", "", "comma separated prefixes (e.g. 192.0.2.0/24) allowed to transfer the zones")
+	updateACL := flag.String("update-acl", "", "comma separated prefixes allowed to change the zones with dynamic UPDATE, changes are lost on restart")
+	keysFile := flag.String("keys", "", "file of TSIG keys, one per line as: name algorithm base64-secret")
+	key := flag.String("key", "", "TSIG key signing the SOA queries, transfers and NOTIFY sent, incoming NOTIFY must be signed with it too")
+	transferKeys := flag.String("transfer-keys", "", "comma separated TSIG keys allowed to transfer the zones, from any address")
+	updateKeys := flag.String("update-keys", "", "comma separated TSIG keys allowed to change the zones with dynamic UPDATE, from any address")
+	flag.Parse()
 
-	udpConn, err := net.ListenUDP("udp", udpAddr)
-	if err != nil {
-		fmt.Println("Failed to bind to address:", err)
-		return
+	var keyring tsig.Keyring
+	if *keysFile != "" {
+		var err error
+		if keyring, err = tsig.LoadKeyring(*keysFile); err != nil {
+			fmt.Println("Failed to load the TSIG keys:", err)
+			return
+		}
 	}
-	defer udpConn.Close()
 
-	buf := make([]byte, 512)
-	staticResponse, err := createStaticMessage().EncodeMessage()
-	if err != nil {
-		panic("could not build the static response, cause: " + err.Error())
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var handler server.Handler = server.HandlerFunc(refusedHandler)
+	if *upstreams != "" {
+		forwardPolicy := forward.RoundRobin
+		switch *policy {
+		case "roundrobin":
+		case "fastest":
+			forwardPolicy = forward.FastestFirst
+		default:
+			fmt.Println("Unknown forward policy:", *policy)
+			return
+		}
+		forwarder := forward.New(strings.Split(*upstreams, ","), forwardPolicy)
+		forwarder.StartHealthChecks(ctx)
+		handler = forwarder
+	} else if *recursive {
+		r := resolver.New()
+		if *roots != "" {
+			r.Roots = strings.Split(*roots, ",")
+		}
+		handler = r
+	}
+	if *prefetchThreshold <= 0 || *prefetchThreshold >= 1 {
+		fmt.Println("The prefetch threshold must be between 0 and 1, got", *prefetchThreshold)
+		return
 	}
-	decodedMessage, err := dns.DecodeMessage(staticResponse)
-	if err != nil {
-		panic("could not decode the static response, cause: " + err.Error())
+	if (*upstreams != "" || *recursive) && *cacheSize > 0 {
+		c := cache.New(*cacheSize << 20)
+		c.MaxStale = *maxStale
+		handler = &cache.Handler{
+			Cache:                 c,
+			Next:                  handler,
+			ClientResponseTimeout: *staleTimeout,
+			PrefetchHits:          *prefetch,
+			PrefetchThreshold:     *prefetchThreshold,
+		}
 	}
-	decodedMessage.Print()
 
-	for {
-		size, source, err := udpConn.ReadFromUDP(buf)
-		if err != nil {
-			fmt.Println("Error receiving data:", err)
-			break
+	if len(zones) > 0 || len(secondaries) > 0 {
+		authoritative := &authoritativeConfig{
+			zones:        zones,
+			secondaries:  secondaries,
+			notify:       notify,
+			secondaryDir: *secondaryDir,
+			transferACL:  *transferACL,
+			updateACL:    *updateACL,
+			keyring:      keyring,
+			key:          *key,
+			transferKeys: *transferKeys,
+			updateKeys:   *updateKeys,
 		}
+		// names outside the zones are resolved when forwarding or resolving, refused otherwise
+		if *upstreams != "" || *recursive {
+			authoritative.next = handler
+		}
+		var err error
+		if handler, err = authoritative.handler(ctx); err != nil {
+			fmt.Println("Failed to serve the zones:", err)
+			return
+		}
+	}
 
-		payload := buf[:size]
-		fmt.Printf("Received %d bytes from %s: %s\n", size, source, string(payload))
+	s := &server.Server{
+		Addr:      *addr,
+		Handler:   handler,
+		Workers:   *workers,
+		ReusePort: *reusePort,
+		Keyring:   keyring,
+	}
 
-		decodedMessage, err := dns.DecodeMessage(payload)
-		if err != nil {
-			fmt.Println("failed to decode the recieved payload, cause: ", err.Error())
-		}
+	errs := make(chan error, 1)
+	go func() { errs <- s.ListenAndServe() }()
 
-		fmt.Printf("decoded message: %v\n", decodedMessage)
+	select {
+	case err := <-errs:
+		fmt.Println("Server stopped:", err)
+		return
+	case <-ctx.Done():
+	}
 
-		_, err = udpConn.WriteToUDP(staticResponse, source)
-		if err != nil {
-			fmt.Println("Failed to send response:", err)
-		}
+	fmt.Println("Shutting down, waiting for in flight queries")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		fmt.Println("Failed to shutdown gracefully:", err)
+		return
 	}
+	<-errs
 }
@@ -0,0 +1,147 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alissonbk/dns-server/client"
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/server"
+	"github.com/alissonbk/dns-server/zone"
+)
+
+const (
+	defaultRetries = 5
+	defaultBackoff = 2 * time.Second
+)
+
+// Notifier tells the secondaries of a zone it changed (RFC 1996), so they don't wait for
+// the refresh interval to transfer it
+type Notifier struct {
+	Client *client.Client
+	// secondaries (host:port) by zone origin
+	Secondaries map[string][]string
+	// NOTIFY messages sent to a secondary before giving up, defaults to 5
+	Retries int
+	// wait before the first retry, doubled after each one, defaults to 2s
+	Backoff time.Duration
+}
+
+// Watch notifies the secondaries of the zone every time its serial changes
+func (n *Notifier) Watch(z *zone.Zone) {
+	z.OnChange(n.Notify)
+}
+
+// Notify sends a NOTIFY for the zone to each of its secondaries in the background,
+// until each one acknowledges it or the retries are exhausted
+func (n *Notifier) Notify(z *zone.Zone) {
+	soa, ok := z.SOA()
+	if !ok {
+		return
+	}
+	for _, secondary := range n.Secondaries[z.Origin] {
+		go func() {
+			retries := n.Retries
+			if retries <= 0 {
+				retries = defaultRetries
+			}
+			backoff := n.Backoff
+			if backoff <= 0 {
+				backoff = defaultBackoff
+			}
+			err := client.Retry(context.Background(), retries, backoff, func() error {
+				return n.send(z, soa, secondary)
+			})
+			if err != nil {
+				log.Printf("failed to notify %s of the changes of %s, cause: %s", secondary, z.Origin, err)
+			}
+		}()
+	}
+}
+
+// send carries the new SOA in the answer section, the secondary may use it as a hint (RFC 1996 section 3.7)
+func (n *Notifier) send(z *zone.Zone, soa dns.Answer, secondary string) error {
+	query := &dns.Message{
+		Header:    dns.Header{OPCODE: dns.OpcodeNotify, AA: true},
+		Questions: []*dns.Question{{QNAME: z.Origin, QTYPE: "SOA", QCLASS: z.Class}},
+		Answers:   []dns.Answer{soa},
+	}
+	response, _, err := n.Client.Exchange(context.Background(), query, secondary)
+	if err != nil {
+		return err
+	}
+	if response.Header.OPCODE != dns.OpcodeNotify {
+		return fmt.Errorf("the response has OPCODE %d", response.Header.OPCODE)
+	}
+	if response.Header.RCODE != dns.RcodeNoError {
+		return fmt.Errorf("the secondary answered %s", dns.RcodeString(response.Header.RCODE))
+	}
+	return nil
+}
+
+// Handler accepts NOTIFY messages of the zones in Primaries coming from their primaries,
+// every other message goes to Next
+type Handler struct {
+	Next server.Handler
+	// addresses allowed to notify each zone, by origin
+	Primaries map[string][]netip.Addr
+	// when set, NOTIFY must also be signed with one of these TSIG keys
+	Keys []string
+	// called in the background for every accepted NOTIFY, with the zone origin
+	Refresh func(origin string)
+}
+
+// ServeDNS acknowledges the NOTIFY right away, the Server echoes the OPCODE and question
+// in the response (RFC 1996 section 4.7). The refresh happens afterwards
+func (h *Handler) ServeDNS(req *server.Request) (*dns.Message, error) {
+	if req.Message.Header.OPCODE != dns.OpcodeNotify {
+		return h.Next.ServeDNS(req)
+	}
+	if len(req.Message.Questions) != 1 || req.Message.Questions[0].QTYPE != "SOA" {
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeFormErr}}, nil
+	}
+
+	origin := strings.ToLower(dns.Fqdn(req.Message.Questions[0].QNAME))
+	primaries, ok := h.Primaries[origin]
+	if !ok {
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeNotAuth}}, nil
+	}
+	addrPort, err := netip.ParseAddrPort(req.RemoteAddr.String())
+	if err != nil || !containsAddr(primaries, addrPort.Addr().Unmap()) {
+		log.Printf("refusing NOTIFY of %s from %s, it's not one of its primaries", origin, req.RemoteAddr)
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeRefused}}, nil
+	}
+	if len(h.Keys) > 0 && !slices.ContainsFunc(h.Keys, func(key string) bool { return req.Key != "" && dns.EqualNames(key, req.Key) }) {
+		log.Printf("refusing NOTIFY of %s from %s, it's not signed with a known key", origin, req.RemoteAddr)
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeRefused}}, nil
+	}
+
+	go h.Refresh(origin)
+	return &dns.Message{Header: dns.Header{AA: true}}, nil
+}
+
+func containsAddr(addrs []netip.Addr, addr netip.Addr) bool {
+	for _, a := range addrs {
+		if a.Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeDNSStream passes zone transfers to Next, so wrapping a StreamHandler doesn't hide it from the Server
+func (h *Handler) ServeDNSStream(req *server.Request, send func(*dns.Message) error) error {
+	if stream, ok := h.Next.(server.StreamHandler); ok {
+		return stream.ServeDNSStream(req, send)
+	}
+	response, err := h.Next.ServeDNS(req)
+	if err != nil {
+		return err
+	}
+	return send(response)
+}
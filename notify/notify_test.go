@@ -0,0 +1,263 @@
+package notify
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alissonbk/dns-server/client"
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/server"
+	"github.com/alissonbk/dns-server/zone"
+)
+
+func notifyQuery(origin string, qtype string) *dns.Message {
+	return &dns.Message{
+		Header:    dns.Header{OPCODE: dns.OpcodeNotify, AA: true},
+		Questions: []*dns.Question{{QNAME: origin, QTYPE: qtype, QCLASS: "IN"}},
+	}
+}
+
+// refreshes records the origins the Handler asked to refresh
+type refreshes struct {
+	mutex   sync.Mutex
+	origins []string
+}
+
+func (r *refreshes) refresh(origin string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.origins = append(r.origins, origin)
+}
+
+func (r *refreshes) get() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string{}, r.origins...)
+}
+
+func TestHandler(t *testing.T) {
+	next := server.HandlerFunc(func(req *server.Request) (*dns.Message, error) {
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeNXDomain}}, nil
+	})
+	loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+
+	tests := []struct {
+		name    string
+		query   *dns.Message
+		source  net.Addr
+		key     string
+		keys    []string
+		rcode   uint16
+		refresh bool
+	}{
+		{"from a primary", notifyQuery("Example.COM", "SOA"), loopback, "", nil, dns.RcodeNoError, true},
+		{"from another address", notifyQuery("example.com.", "SOA"), &net.UDPAddr{IP: net.IPv4(192, 0, 2, 9), Port: 53}, "", nil, dns.RcodeRefused, false},
+		{"unknown zone", notifyQuery("example.net.", "SOA"), loopback, "", nil, dns.RcodeNotAuth, false},
+		{"not a SOA", notifyQuery("example.com.", "A"), loopback, "", nil, dns.RcodeFormErr, false},
+		{"unsigned when a key is required", notifyQuery("example.com.", "SOA"), loopback, "", []string{"transfer.example.com."}, dns.RcodeRefused, false},
+		{"signed with another key", notifyQuery("example.com.", "SOA"), loopback, "other.example.com.", []string{"transfer.example.com."}, dns.RcodeRefused, false},
+		{"signed with the key", notifyQuery("example.com.", "SOA"), loopback, "Transfer.example.com", []string{"transfer.example.com."}, dns.RcodeNoError, true},
+		{"not a NOTIFY", &dns.Message{Questions: []*dns.Question{{QNAME: "example.com.", QTYPE: "SOA", QCLASS: "IN"}}}, loopback, "", nil, dns.RcodeNXDomain, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var refreshed refreshes
+			h := &Handler{
+				Next:      next,
+				Primaries: map[string][]netip.Addr{"example.com.": {netip.MustParseAddr("::ffff:127.0.0.1")}},
+				Keys:      test.keys,
+				Refresh:   refreshed.refresh,
+			}
+			response, err := h.ServeDNS(&server.Request{Message: test.query, RemoteAddr: test.source, Network: "udp", Key: test.key})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Header.RCODE != test.rcode {
+				t.Fatalf("expected %s, got %s", dns.RcodeString(test.rcode), dns.RcodeString(response.Header.RCODE))
+			}
+
+			// the refresh runs in the background
+			deadline := time.Now().Add(time.Second)
+			for test.refresh && len(refreshed.get()) == 0 && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			if !test.refresh {
+				time.Sleep(20 * time.Millisecond)
+			}
+			origins := refreshed.get()
+			if test.refresh && (len(origins) != 1 || origins[0] != "example.com.") {
+				t.Fatalf("expected a refresh of example.com., got %v", origins)
+			}
+			if !test.refresh && len(origins) != 0 {
+				t.Fatalf("expected no refresh, got %v", origins)
+			}
+		})
+	}
+}
+
+// the acknowledgement echoes the OPCODE and the question, with AA (RFC 1996 section 4.7)
+func TestHandlerReply(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var refreshed refreshes
+	h := &Handler{Primaries: map[string][]netip.Addr{"example.com.": {netip.MustParseAddr("127.0.0.1")}}, Refresh: refreshed.refresh}
+	s := &server.Server{Handler: h}
+	errs := make(chan error, 1)
+	go func() { errs <- s.ServeUDP(conn) }()
+	defer func() {
+		s.Shutdown(context.Background())
+		<-errs
+	}()
+
+	response, _, err := (&client.Client{Timeout: time.Second}).Exchange(context.Background(), notifyQuery("example.com.", "SOA"), conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Header.OPCODE != dns.OpcodeNotify || !response.Header.AA || response.Header.RCODE != dns.RcodeNoError {
+		t.Fatalf("expected a NOERROR NOTIFY with AA, got OPCODE %d, AA %t and %s", response.Header.OPCODE, response.Header.AA, dns.RcodeString(response.Header.RCODE))
+	}
+	if len(response.Questions) != 1 || response.Questions[0].QNAME != "example.com." {
+		t.Fatalf("expected the question to be echoed, got %v", response.Questions)
+	}
+}
+
+// secondary receives NOTIFY messages, answer decides what to send back to the nth one
+type secondary struct {
+	conn     *net.UDPConn
+	mutex    sync.Mutex
+	arrivals []time.Time
+}
+
+func startSecondary(t *testing.T, answer func(n int, query *dns.Message) []*dns.Message) *secondary {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	s := &secondary{conn: conn}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			size, source, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			query, err := dns.DecodeMessage(buf[:size])
+			if err != nil {
+				continue
+			}
+			s.mutex.Lock()
+			s.arrivals = append(s.arrivals, time.Now())
+			n := len(s.arrivals)
+			s.mutex.Unlock()
+			for _, response := range answer(n, query) {
+				if payload, err := response.EncodeMessage(); err == nil {
+					conn.WriteToUDP(payload, source)
+				}
+			}
+		}
+	}()
+	return s
+}
+
+func (s *secondary) received() []time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]time.Time{}, s.arrivals...)
+}
+
+func acknowledgement(query *dns.Message) *dns.Message {
+	return &dns.Message{
+		Header:    dns.Header{ID: query.Header.ID, QR: true, OPCODE: dns.OpcodeNotify, AA: true},
+		Questions: query.Questions,
+	}
+}
+
+func TestNotifierRetries(t *testing.T) {
+	s := startSecondary(t, func(n int, query *dns.Message) []*dns.Message {
+		switch n {
+		case 1:
+			// lost
+			return nil
+		case 2:
+			// a response to another query, then one that isn't a NOTIFY
+			other := acknowledgement(query)
+			other.Header.ID++
+			wrongOpcode := acknowledgement(query)
+			wrongOpcode.Header.OPCODE = dns.OpcodeQuery
+			return []*dns.Message{other, wrongOpcode}
+		default:
+			return []*dns.Message{acknowledgement(query)}
+		}
+	})
+
+	z := zone.New("example.com.", "IN")
+	soa := dns.Answer{
+		NAME: "example.com.", TYPE: "SOA", CLASS: "IN", TTL: 300,
+		RDATA: &dns.SOA{MNAME: "ns.example.com.", RNAME: "hostmaster.example.com.", SERIAL: 2},
+	}
+	if err := z.Insert(soa); err != nil {
+		t.Fatal(err)
+	}
+	timeout, backoff := 100*time.Millisecond, 50*time.Millisecond
+	n := &Notifier{
+		Client:      &client.Client{Timeout: timeout},
+		Secondaries: map[string][]string{"example.com.": {s.conn.LocalAddr().String()}},
+		Backoff:     backoff,
+	}
+	n.Notify(z)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for len(s.received()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// acknowledged, nothing more is sent
+	time.Sleep(timeout + 4*backoff)
+	arrivals := s.received()
+	if len(arrivals) != 3 {
+		t.Fatalf("expected 3 NOTIFY messages, got %d", len(arrivals))
+	}
+	// the first one timed out, the backoff doubles
+	if gap := arrivals[1].Sub(arrivals[0]); gap < timeout+backoff {
+		t.Fatalf("the first retry came after %s", gap)
+	}
+	if gap := arrivals[2].Sub(arrivals[1]); gap < 2*backoff {
+		t.Fatalf("the second retry came after %s, the backoff didn't double", gap)
+	}
+}
+
+func serial(soa dns.Answer) uint32 {
+	if rdata, ok := soa.RDATA.(*dns.SOA); ok {
+		return rdata.SERIAL
+	}
+	return 0
+}
+
+// the NOTIFY carries the SOA of the zone as a hint (RFC 1996 section 3.7)
+func TestNotifierSend(t *testing.T) {
+	queries := make(chan *dns.Message, 1)
+	s := startSecondary(t, func(n int, query *dns.Message) []*dns.Message {
+		queries <- query
+		return []*dns.Message{acknowledgement(query)}
+	})
+	z := zone.New("example.com.", "IN")
+	soa := dns.Answer{
+		NAME: "example.com.", TYPE: "SOA", CLASS: "IN", TTL: 300,
+		RDATA: &dns.SOA{MNAME: "ns.example.com.", RNAME: "hostmaster.example.com.", SERIAL: 7},
+	}
+	n := &Notifier{Client: &client.Client{Timeout: time.Second}}
+	if err := n.send(z, soa, s.conn.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	query := <-queries
+	if query.Header.OPCODE != dns.OpcodeNotify || !query.Header.AA || len(query.Answers) != 1 || serial(query.Answers[0]) != 7 {
+		t.Fatalf("unexpected NOTIFY, OPCODE %d, AA %t, answers %v", query.Header.OPCODE, query.Header.AA, query.Answers)
+	}
+}
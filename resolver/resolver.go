@@ -0,0 +1,304 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/alissonbk/dns-server/client"
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/server"
+)
+
+const (
+	// referrals followed for a single name before giving up
+	maxReferrals = 30
+	// CNAME records followed before giving up
+	maxCNAMEs = 8
+	// nested resolutions of name server addresses without glue
+	maxDepth = 4
+	// time allowed for a whole resolution
+	resolveTimeout = 10 * time.Second
+)
+
+var (
+	// ErrLame is returned when none of the servers of a zone gave a usable response
+	ErrLame = errors.New("every name server of the zone is lame or unreachable")
+	// ErrLoop is returned when a CNAME chain loops or a limit is exceeded
+	ErrLoop = errors.New("resolution exceeded its limits")
+)
+
+// Resolver is a server.Handler answering recursive queries by itself: it starts
+// from the root servers, follows the NS referrals down to the authoritative servers
+// and chases CNAME chains
+type Resolver struct {
+	// root server addresses (host:port), defaults to the IPv4 addresses of RootHints on port 53
+	Roots []string
+	// port used to reach the name servers learned from referrals, defaults to 53
+	Port   string
+	Client *client.Client
+}
+
+func New() *Resolver {
+	roots := make([]string, len(RootHints))
+	for i, hint := range RootHints {
+		roots[i] = net.JoinHostPort(hint.IPv4, "53")
+	}
+	return &Resolver{Roots: roots, Port: "53", Client: &client.Client{}}
+}
+
+// ServeDNS resolves the question of queries with RD set, recursion is offered so RA is always set
+func (r *Resolver) ServeDNS(req *server.Request) (*dns.Message, error) {
+	response := &dns.Message{Header: dns.Header{RA: true}}
+	if !req.Message.Header.RD {
+		response.Header.RCODE = dns.RcodeRefused
+		return response, nil
+	}
+	if len(req.Message.Questions) != 1 {
+		response.Header.RCODE = dns.RcodeFormErr
+		return response, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	question := req.Message.Questions[0]
+	resolved, err := r.Resolve(ctx, question.QNAME, question.QTYPE, question.QCLASS)
+	if err != nil {
+		log.Printf("failed to resolve %s %s, cause: %s", question.QNAME, question.QTYPE, err)
+		response.Header.RCODE = dns.RcodeServFail
+		return response, nil
+	}
+	resolved.Header.RA = true
+	return resolved, nil
+}
+
+// Resolve answers the question iteratively. The returned message has the CNAME chain
+// followed by the final answer, or the authority section of the negative response
+func (r *Resolver) Resolve(ctx context.Context, qname string, qtype string, qclass string) (*dns.Message, error) {
+	return r.resolve(ctx, dns.Fqdn(qname), qtype, qclass, 0)
+}
+
+func (r *Resolver) resolve(ctx context.Context, qname string, qtype string, qclass string, depth int) (*dns.Message, error) {
+	if depth > maxDepth {
+		return nil, ErrLoop
+	}
+
+	result := &dns.Message{}
+	seen := map[string]bool{}
+	for range maxCNAMEs + 1 {
+		if seen[strings.ToLower(qname)] {
+			return nil, fmt.Errorf("CNAME loop at %s, cause: %w", qname, ErrLoop)
+		}
+		seen[strings.ToLower(qname)] = true
+
+		response, err := r.iterate(ctx, qname, qtype, qclass, depth)
+		if err != nil {
+			return nil, err
+		}
+
+		answers, target := followAnswers(response, qname, qtype)
+		result.Answers = append(result.Answers, answers...)
+		if target == "" {
+			result.Header.RCODE = response.Header.RCODE
+			result.Authority = response.Authority
+			return result, nil
+		}
+		qname = target
+	}
+
+	return nil, fmt.Errorf("CNAME chain too long, cause: %w", ErrLoop)
+}
+
+// followAnswers extracts the records of qname from the answer section. target is the
+// CNAME target that still needs to be resolved, empty when the response is final for qname.
+// the target may be answered in the same response, but the server could have no authority
+// over it, so it's always resolved again from the root
+func followAnswers(response *dns.Message, qname string, qtype string) ([]dns.Answer, string) {
+	var answers []dns.Answer
+	var cname *dns.CNAME
+	found := false
+	for _, answer := range response.Answers {
+		if !dns.EqualNames(answer.NAME, qname) {
+			continue
+		}
+		if answer.TYPE == qtype || qtype == "*" {
+			answers = append(answers, answer)
+			found = true
+		} else if c, ok := answer.RDATA.(*dns.CNAME); ok {
+			answers = append(answers, answer)
+			cname = c
+		}
+	}
+	if found || cname == nil {
+		return answers, ""
+	}
+	return answers, dns.Fqdn(cname.CNAME)
+}
+
+// nameserver is a server of a delegation, addrs is empty until resolved when there was no glue
+type nameserver struct {
+	host  string
+	addrs []string
+}
+
+// iterate follows the referrals from the root down to a server answering for qname
+func (r *Resolver) iterate(ctx context.Context, qname string, qtype string, qclass string, depth int) (*dns.Message, error) {
+	zone := "."
+	servers := make([]nameserver, len(r.Roots))
+	for i, root := range r.Roots {
+		servers[i] = nameserver{host: root, addrs: []string{root}}
+	}
+
+	query := &dns.Message{
+		Questions: []*dns.Question{{QNAME: qname, QTYPE: qtype, QCLASS: qclass}},
+		EDNS:      &dns.EDNS{},
+	}
+
+	for range maxReferrals {
+		response, delegation, err := r.queryZone(ctx, query, zone, servers, depth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query the servers of %s, cause: %w", zone, err)
+		}
+		if delegation == "" {
+			return response, nil
+		}
+
+		zone = delegation
+		servers = r.referralServers(response, zone)
+	}
+
+	return nil, fmt.Errorf("too many referrals for %s, cause: %w", qname, ErrLoop)
+}
+
+// queryZone asks the servers of zone one after the other until one gives a usable response.
+// servers with glue are tried first, the others have their addresses resolved only when needed.
+// delegation is the child zone when the response is a referral
+func (r *Resolver) queryZone(ctx context.Context, query *dns.Message, zone string, servers []nameserver, depth int) (*dns.Message, string, error) {
+	qname := query.Questions[0].QNAME
+
+	try := func(addr string) (*dns.Message, string, bool) {
+		response, _, err := r.Client.Exchange(ctx, query, addr)
+		if err != nil {
+			return nil, "", false
+		}
+		delegation, usable := classify(response, qname, zone)
+		if !usable {
+			log.Printf("lame response from %s for %s in zone %s", addr, qname, zone)
+		}
+		return response, delegation, usable
+	}
+
+	for _, ns := range servers {
+		for _, addr := range ns.addrs {
+			if response, delegation, ok := try(addr); ok {
+				return response, delegation, nil
+			}
+		}
+	}
+
+	for _, ns := range servers {
+		if len(ns.addrs) > 0 || ctx.Err() != nil {
+			continue
+		}
+		// a server named inside the zone it serves without glue can't be reached
+		if dns.IsSubdomain(ns.host, zone) && zone != "." {
+			continue
+		}
+		for _, addr := range r.lookupAddrs(ctx, ns.host, depth) {
+			if response, delegation, ok := try(addr); ok {
+				return response, delegation, nil
+			}
+		}
+	}
+
+	return nil, "", ErrLame
+}
+
+// classify checks the response is usable for a query about qname sent to a server of zone.
+// returns the child zone when it's a referral. Lame responses (errors, referrals that don't
+// get closer to qname, non authoritative answers without a referral) are not usable
+func classify(response *dns.Message, qname string, zone string) (string, bool) {
+	switch response.Header.RCODE {
+	case dns.RcodeNoError, dns.RcodeNXDomain:
+	default:
+		return "", false
+	}
+
+	if response.Header.AA || len(response.Answers) > 0 {
+		return "", true
+	}
+
+	for _, record := range response.Authority {
+		if record.TYPE != "NS" {
+			continue
+		}
+		// the delegation must be strictly below the zone asked and contain qname,
+		// anything else is an upward or sideways referral
+		if !dns.EqualNames(record.NAME, zone) && dns.IsSubdomain(record.NAME, zone) && dns.IsSubdomain(qname, record.NAME) {
+			return dns.Fqdn(strings.ToLower(record.NAME)), true
+		}
+		return "", false
+	}
+
+	// a non authoritative negative response
+	return "", false
+}
+
+// referralServers builds the server list of the delegation, glue is only accepted for
+// servers named inside the delegated zone, anything else could be used to poison the
+// addresses of names the referring server has no authority over
+func (r *Resolver) referralServers(response *dns.Message, zone string) []nameserver {
+	var servers []nameserver
+	for _, record := range response.Authority {
+		ns, ok := record.RDATA.(*dns.NS)
+		if record.TYPE != "NS" || !ok || !dns.EqualNames(record.NAME, zone) {
+			continue
+		}
+
+		server := nameserver{host: dns.Fqdn(ns.NSDNAME)}
+		if dns.IsSubdomain(server.host, zone) {
+			for _, glue := range response.Additional {
+				if !dns.EqualNames(glue.NAME, server.host) {
+					continue
+				}
+				switch rdata := glue.RDATA.(type) {
+				case *dns.A:
+					server.addrs = append(server.addrs, net.JoinHostPort(rdata.ADDRESS.String(), r.port()))
+				case *dns.AAAA:
+					server.addrs = append(server.addrs, net.JoinHostPort(rdata.ADDRESS.String(), r.port()))
+				}
+			}
+		}
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// lookupAddrs resolves the IPv4 addresses of a name server that came without glue
+func (r *Resolver) lookupAddrs(ctx context.Context, host string, depth int) []string {
+	response, err := r.resolve(ctx, host, "A", "IN", depth+1)
+	if err != nil {
+		log.Printf("failed to resolve the name server %s, cause: %s", host, err)
+		return nil
+	}
+
+	var addrs []string
+	for _, answer := range response.Answers {
+		if a, ok := answer.RDATA.(*dns.A); ok {
+			addrs = append(addrs, net.JoinHostPort(a.ADDRESS.String(), r.port()))
+		}
+	}
+	return addrs
+}
+
+func (r *Resolver) port() string {
+	if r.Port == "" {
+		return "53"
+	}
+	return r.Port
+}
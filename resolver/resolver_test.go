@@ -0,0 +1,204 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alissonbk/dns-server/client"
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/server"
+	"github.com/alissonbk/dns-server/zone"
+)
+
+// the fake hierarchy, every server listens on the same port of its own loopback address
+// because the resolver reaches the servers learned from referrals on a single port
+var hierarchy = []struct {
+	addr  string
+	zones map[string]string
+}{
+	{"127.0.0.1", map[string]string{".": `
+@ SOA a.root. hostmaster.root. 1 3600 600 86400 60
+@ NS a.root.
+a.root. A 127.0.0.1
+com. NS ns.com.
+ns.com. A 127.0.0.2
+net. NS ns.net.
+ns.net. A 127.0.0.4
+org. NS ns.org.
+ns.org. A 127.0.0.2
+`}},
+	{"127.0.0.2", map[string]string{
+		"com.": `
+@ SOA ns.com. hostmaster.com. 1 3600 600 86400 60
+@ NS ns.com.
+ns A 127.0.0.2
+example NS ns1.example
+ns1.example A 127.0.0.3
+; the first server doesn't serve the zone, the second one does
+lame NS ns1.lame
+lame NS ns2.lame
+ns1.lame A 127.0.0.6
+ns2.lame A 127.0.0.3
+; no server serves the zone
+dead NS ns.dead
+ns.dead A 127.0.0.6
+`,
+		// the servers of example.org. are named in another zone, the referral can't carry glue
+		"org.": `
+@ SOA ns.com. hostmaster.com. 1 3600 600 86400 60
+@ NS ns.com.
+example NS ns.example.net.
+`,
+	}},
+	{"127.0.0.3", map[string]string{
+		"example.com.": `
+@ SOA ns1 hostmaster 1 3600 600 86400 60
+@ NS ns1
+ns1 A 127.0.0.3
+www A 192.0.2.1
+alias CNAME www.example.net.
+`,
+		"lame.com.": `
+@ SOA ns2 hostmaster 1 3600 600 86400 60
+@ NS ns1
+@ NS ns2
+www A 192.0.2.4
+`,
+	}},
+	{"127.0.0.4", map[string]string{"net.": `
+@ SOA ns hostmaster 1 3600 600 86400 60
+@ NS ns
+ns A 127.0.0.4
+example NS ns.example
+ns.example A 127.0.0.5
+`}},
+	{"127.0.0.5", map[string]string{
+		"example.net.": `
+@ SOA ns hostmaster 1 3600 600 86400 60
+@ NS ns
+ns A 127.0.0.5
+www A 192.0.2.2
+`,
+		"example.org.": `
+@ SOA ns.example.net. hostmaster 1 3600 600 86400 60
+@ NS ns.example.net.
+www A 192.0.2.3
+`,
+	}},
+	// serves nothing, REFUSED to everything
+	{"127.0.0.6", nil},
+}
+
+// startHierarchy serves the zones on the loopback addresses, returns the resolver starting from the root
+func startHierarchy(t *testing.T) *Resolver {
+	var port string
+	for _, servers := range hierarchy {
+		store := zone.NewStore()
+		for origin, content := range servers.zones {
+			records, err := zone.Parse(strings.NewReader(content), origin, origin, "IN")
+			if err != nil {
+				t.Fatal(err)
+			}
+			z := zone.New(origin, "IN")
+			for _, record := range records {
+				if err := z.Insert(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			store.Add(z)
+		}
+
+		// the root picks the port, the others bind to the same one
+		addr := net.JoinHostPort(servers.addr, port)
+		if port == "" {
+			addr = net.JoinHostPort(servers.addr, "0")
+		}
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := net.ListenUDP("udp", udpAddr)
+		if err != nil {
+			t.Skipf("can't listen on %s, cause: %s", addr, err)
+		}
+		if port == "" {
+			_, port, _ = net.SplitHostPort(conn.LocalAddr().String())
+		}
+
+		s := &server.Server{Handler: &zone.Handler{Store: store}}
+		errs := make(chan error, 1)
+		go func() { errs <- s.ServeUDP(conn) }()
+		t.Cleanup(func() {
+			s.Shutdown(context.Background())
+			<-errs
+			conn.Close()
+		})
+	}
+
+	return &Resolver{
+		Roots:  []string{net.JoinHostPort("127.0.0.1", port)},
+		Port:   port,
+		Client: &client.Client{Timeout: 500 * time.Millisecond},
+	}
+}
+
+func TestResolve(t *testing.T) {
+	r := startHierarchy(t)
+
+	tests := []struct {
+		name  string
+		qname string
+		qtype string
+		rcode uint16
+		// the answer section, rendered as NAME TYPE RDATA
+		answers []string
+	}{
+		{"answer from the root", ".", "SOA", dns.RcodeNoError, []string{". SOA a.root. hostmaster.root. 1 3600 600 86400 60"}},
+		{"referrals with glue", "www.example.com.", "A", dns.RcodeNoError, []string{"www.example.com. A 192.0.2.1"}},
+		{"referral without glue", "www.example.org.", "A", dns.RcodeNoError, []string{"www.example.org. A 192.0.2.3"}},
+		{
+			"CNAME to another zone", "alias.example.com.", "A", dns.RcodeNoError,
+			[]string{"alias.example.com. CNAME www.example.net.", "www.example.net. A 192.0.2.2"},
+		},
+		{"lame server skipped", "www.lame.com.", "A", dns.RcodeNoError, []string{"www.lame.com. A 192.0.2.4"}},
+		{"NXDOMAIN", "missing.example.com.", "A", dns.RcodeNXDomain, nil},
+		{"NODATA", "www.example.com.", "AAAA", dns.RcodeNoError, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			response, err := r.Resolve(ctx, test.qname, test.qtype, "IN")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Header.RCODE != test.rcode {
+				t.Fatalf("expected %s, got %s", dns.RcodeString(test.rcode), dns.RcodeString(response.Header.RCODE))
+			}
+			var answers []string
+			for _, answer := range response.Answers {
+				answers = append(answers, answer.NAME+" "+answer.TYPE+" "+answer.RDATA.String())
+			}
+			if strings.Join(answers, "\n") != strings.Join(test.answers, "\n") {
+				t.Fatalf("expected answers %q, got %q", test.answers, answers)
+			}
+			// negative responses carry the SOA of the zone for negative caching
+			if len(test.answers) == 0 && (len(response.Authority) == 0 || response.Authority[0].TYPE != "SOA") {
+				t.Fatalf("expected the SOA in the authority section, got %v", response.Authority)
+			}
+		})
+	}
+}
+
+func TestResolveLameDelegation(t *testing.T) {
+	r := startHierarchy(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := r.Resolve(ctx, "www.dead.com.", "A", "IN"); !errors.Is(err, ErrLame) {
+		t.Fatalf("expected %v, got %v", ErrLame, err)
+	}
+}
@@ -0,0 +1,279 @@
+package secondary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/alissonbk/dns-server/client"
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/zone"
+)
+
+const (
+	// wait before the next refresh when there is no SOA to take the interval from yet
+	defaultRetry = 30 * time.Second
+	// shortest wait between refreshes, guards against zones with tiny or zero timers
+	minInterval = 5 * time.Second
+)
+
+// ErrNoPrimary is returned when none of the primaries answered the SOA query
+var ErrNoPrimary = errors.New("no primary answered")
+
+// Zone is a copy of a zone kept up to date from its primaries and served from the Store
+type Zone struct {
+	Origin string
+	Class  string
+	// host:port, tried in order
+	Primaries []string
+	Store     *zone.Store
+	Client    *client.Client
+	// master file keeping the last good copy, so a restart serves it right away. empty disables it
+	File string
+
+	mutex sync.Mutex
+	// last time the copy was known to match a primary
+	refreshed time.Time
+	// wakes up Run when a NOTIFY arrives, see { Notified }
+	notified chan struct{}
+}
+
+// Load serves the copy kept in File, if any. The copy counts as refreshed when the file
+// was last written, so it still expires on time across restarts
+func (s *Zone) Load() error {
+	if s.File == "" {
+		return nil
+	}
+	info, err := os.Stat(s.File)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load the copy of %s, cause: %w", s.Origin, err)
+	}
+
+	z, err := zone.Load(s.File, s.Origin, s.Class)
+	if err != nil {
+		return err
+	}
+	z.SetSecondary(true)
+	s.Store.Add(z)
+	s.mutex.Lock()
+	s.refreshed = info.ModTime()
+	s.mutex.Unlock()
+	s.expire()
+	return nil
+}
+
+// Run refreshes the zone until the context is done: right away, then after the SOA REFRESH
+// interval when it succeeded or the RETRY interval when it failed, and whenever a NOTIFY
+// arrives. Once no refresh succeeded for the EXPIRE interval the zone answers SERVFAIL
+func (s *Zone) Run(ctx context.Context) {
+	notified := s.notifications()
+	for {
+		err := s.Refresh(ctx)
+		if err != nil {
+			log.Print(err)
+		}
+
+		timer := time.NewTimer(s.next(err != nil))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-notified:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// next returns how long Run waits before the next refresh: the SOA RETRY interval after
+// a failed one and REFRESH otherwise, never past the moment the copy expires
+func (s *Zone) next(failed bool) time.Duration {
+	wait := defaultRetry
+	if soa, ok := s.soa(); ok {
+		if failed {
+			wait = seconds(soa.RETRY)
+		} else {
+			wait = seconds(soa.REFRESH)
+		}
+	}
+	if expiresIn, ok := s.expire(); ok {
+		wait = min(wait, max(expiresIn, minInterval))
+	}
+	return wait
+}
+
+// Notified makes Run refresh the zone right away
+func (s *Zone) Notified() {
+	select {
+	case s.notifications() <- struct{}{}:
+	default:
+		// a refresh is already due
+	}
+}
+
+func (s *Zone) notifications() chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.notified == nil {
+		s.notified = make(chan struct{}, 1)
+	}
+	return s.notified
+}
+
+// Refresh asks the primaries for their SOA and transfers the zone from the first one whose
+// serial is newer than the local copy (RFC 1982 arithmetic), or when there is no local copy yet
+func (s *Zone) Refresh(ctx context.Context) error {
+	var errs []error
+	for _, primary := range s.Primaries {
+		remote, err := s.querySerial(ctx, primary)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if local, ok := s.serial(); ok && dns.CompareSerial(remote, local) <= 0 {
+			s.succeeded(false)
+			return nil
+		}
+		if _, err := s.Store.TransferIn(ctx, s.Client, s.Origin, s.Class, primary); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		serial, _ := s.serial()
+		log.Printf("transferred %s serial %d from %s", s.Origin, serial, primary)
+		s.succeeded(true)
+		return nil
+	}
+	return fmt.Errorf("failed to refresh %s, cause: %w", s.Origin, errors.Join(append(errs, ErrNoPrimary)...))
+}
+
+// succeeded restarts the expire interval and keeps the copy on disk, it's only written
+// again when it changed, otherwise touching the file is enough to record the refresh
+func (s *Zone) succeeded(changed bool) {
+	now := time.Now()
+	s.mutex.Lock()
+	s.refreshed = now
+	s.mutex.Unlock()
+
+	z, ok := s.Store.Get(s.Origin)
+	if !ok {
+		return
+	}
+	z.SetExpired(false)
+	if s.File == "" {
+		return
+	}
+	if !changed {
+		err := os.Chtimes(s.File, now, now)
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("failed to touch the copy of %s, cause: %s", s.Origin, err)
+			return
+		}
+	}
+	if err := save(z, s.File); err != nil {
+		log.Printf("failed to save the copy of %s, cause: %s", s.Origin, err)
+	}
+}
+
+// expire stops serving the copy once the EXPIRE interval passed since the last refresh,
+// returns how long until that happens, false when there is no copy or it already expired
+func (s *Zone) expire() (time.Duration, bool) {
+	z, ok := s.Store.Get(s.Origin)
+	if !ok {
+		return 0, false
+	}
+	soa, ok := s.soa()
+	if !ok {
+		return 0, false
+	}
+	s.mutex.Lock()
+	expiresIn := time.Until(s.refreshed.Add(seconds(soa.EXPIRE)))
+	s.mutex.Unlock()
+
+	if expiresIn > 0 {
+		return expiresIn, true
+	}
+	if !z.Expired() {
+		log.Printf("the copy of %s expired, answering SERVFAIL until it's refreshed", s.Origin)
+		z.SetExpired(true)
+	}
+	return 0, false
+}
+
+// save writes the zone next to the file and renames it over, so a crash never leaves half a copy
+func save(z *zone.Zone, path string) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	// CreateTemp makes the file private, but it's a regular zone file
+	if err := temp.Chmod(0o644); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := z.Write(temp); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+func (s *Zone) serial() (uint32, bool) {
+	z, ok := s.Store.Get(s.Origin)
+	if !ok {
+		return 0, false
+	}
+	return z.Serial()
+}
+
+func (s *Zone) soa() (*dns.SOA, bool) {
+	z, ok := s.Store.Get(s.Origin)
+	if !ok {
+		return nil, false
+	}
+	record, ok := z.SOA()
+	if !ok {
+		return nil, false
+	}
+	soa, ok := record.RDATA.(*dns.SOA)
+	return soa, ok
+}
+
+func seconds(interval uint32) time.Duration {
+	return max(time.Duration(interval)*time.Second, minInterval)
+}
+
+// querySerial asks the primary for the SOA of the zone, the answer must be authoritative
+func (s *Zone) querySerial(ctx context.Context, primary string) (uint32, error) {
+	query := &dns.Message{Questions: []*dns.Question{{QNAME: s.Origin, QTYPE: "SOA", QCLASS: s.Class}}}
+	response, _, err := s.Client.Exchange(ctx, query, primary)
+	if err != nil {
+		return 0, err
+	}
+	if !response.Header.AA || response.Header.RCODE != dns.RcodeNoError {
+		return 0, fmt.Errorf("%s is not authoritative for %s, it answered %s", primary, s.Origin, dns.RcodeString(response.Header.RCODE))
+	}
+	for _, answer := range response.Answers {
+		if soa, ok := answer.RDATA.(*dns.SOA); ok && dns.EqualNames(answer.NAME, s.Origin) {
+			return soa.SERIAL, nil
+		}
+	}
+	return 0, fmt.Errorf("%s answered without the SOA of %s", primary, s.Origin)
+}
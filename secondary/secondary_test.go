@@ -0,0 +1,352 @@
+package secondary
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alissonbk/dns-server/client"
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/server"
+	"github.com/alissonbk/dns-server/zone"
+)
+
+// the SOA timers of the test zones, in seconds
+const (
+	refresh = 60
+	retry   = 10
+	expire  = 3600
+)
+
+func records(serial uint32, address string) []dns.Answer {
+	return []dns.Answer{
+		{
+			NAME: "example.com.", TYPE: "SOA", CLASS: "IN", TTL: 300,
+			RDATA: &dns.SOA{MNAME: "ns.example.com.", RNAME: "hostmaster.example.com.", SERIAL: serial, REFRESH: refresh, RETRY: retry, EXPIRE: expire, MINIMUM: 60},
+		},
+		{NAME: "example.com.", TYPE: "NS", CLASS: "IN", TTL: 300, RDATA: &dns.NS{NSDNAME: "ns.example.com."}},
+		{NAME: "www.example.com.", TYPE: "A", CLASS: "IN", TTL: 300, RDATA: &dns.A{ADDRESS: netip.MustParseAddr(address)}},
+	}
+}
+
+func newZone(t *testing.T, serial uint32, address string) *zone.Zone {
+	t.Helper()
+	z := zone.New("example.com.", "IN")
+	for _, record := range records(serial, address) {
+		if err := z.Insert(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return z
+}
+
+// primary serves its zone over UDP and TCP on the same loopback port, counting the transfers
+type primary struct {
+	zone      *zone.Zone
+	handler   *zone.Handler
+	addr      string
+	polls     atomic.Int32
+	transfers atomic.Int32
+	// answers SERVFAIL to everything while set
+	down atomic.Bool
+}
+
+func (p *primary) ServeDNS(req *server.Request) (*dns.Message, error) {
+	p.polls.Add(1)
+	if p.down.Load() {
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeServFail}}, nil
+	}
+	return p.handler.ServeDNS(req)
+}
+
+func (p *primary) ServeDNSStream(req *server.Request, send func(*dns.Message) error) error {
+	p.transfers.Add(1)
+	return p.handler.ServeDNSStream(req, send)
+}
+
+func startPrimary(t *testing.T, z *zone.Zone) *primary {
+	t.Helper()
+	store := zone.NewStore()
+	store.Add(z)
+	p := &primary{zone: z, handler: &zone.Handler{Store: store, TransferACL: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}}}
+
+	// the UDP socket takes the port of the TCP listener, which may be taken already
+	var l net.Listener
+	var conn *net.UDPConn
+	for range 10 {
+		var err error
+		if l, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		if conn, err = net.ListenUDP("udp", net.UDPAddrFromAddrPort(l.Addr().(*net.TCPAddr).AddrPort())); err == nil {
+			break
+		}
+		l.Close()
+	}
+	if conn == nil {
+		t.Skip("can't bind UDP and TCP to the same loopback port")
+	}
+	p.addr = l.Addr().String()
+
+	s := &server.Server{Handler: p}
+	errs := make(chan error, 2)
+	go func() { errs <- s.ServeUDP(conn) }()
+	go func() { errs <- s.ServeTCP(l) }()
+	t.Cleanup(func() {
+		s.Shutdown(context.Background())
+		<-errs
+		<-errs
+		conn.Close()
+	})
+	return p
+}
+
+func newSecondary(t *testing.T, p *primary) *Zone {
+	return &Zone{
+		Origin:    "example.com.",
+		Class:     "IN",
+		Primaries: []string{p.addr},
+		Store:     zone.NewStore(),
+		Client:    &client.Client{Timeout: time.Second},
+	}
+}
+
+// address returns the address of www.example.com. in the copy
+func address(t *testing.T, s *Zone) string {
+	t.Helper()
+	z, ok := s.Store.Get(s.Origin)
+	if !ok {
+		t.Fatal("the zone is not in the store")
+	}
+	answers := z.Lookup("www.example.com.", "A").Answers
+	if len(answers) != 1 {
+		t.Fatalf("expected a single address, got %v", answers)
+	}
+	return answers[0].RDATA.String()
+}
+
+func TestRefresh(t *testing.T) {
+	tests := []struct {
+		name  string
+		local uint32
+		// the primary moves from the local serial to this one
+		remote   uint32
+		transfer bool
+	}{
+		{"newer serial", 5, 6, true},
+		{"serial wrapping around", 0xFFFFFFFF, 1, true},
+		{"same serial", 5, 5, false},
+		{"older serial", 5, 4, false},
+		{"older serial wrapping around", 1, 0xFFFFFFFF, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := startPrimary(t, newZone(t, test.local, "192.0.2.1"))
+			s := newSecondary(t, p)
+
+			// the first refresh has no copy to compare with
+			if err := s.Refresh(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if p.transfers.Load() != 1 || address(t, s) != "192.0.2.1" {
+				t.Fatalf("expected the first refresh to transfer the zone, %d transfers", p.transfers.Load())
+			}
+			z, _ := s.Store.Get(s.Origin)
+			if !z.Secondary() {
+				t.Fatal("expected the copy to be marked as secondary")
+			}
+
+			if err := p.zone.Replace(records(test.remote, "192.0.2.2")); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Refresh(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			expected, transfers := "192.0.2.1", int32(1)
+			if test.transfer {
+				expected, transfers = "192.0.2.2", 2
+			}
+			if p.transfers.Load() != transfers || address(t, s) != expected {
+				t.Fatalf("expected %d transfers and %s, got %d and %s", transfers, expected, p.transfers.Load(), address(t, s))
+			}
+		})
+	}
+}
+
+func TestRefreshFailover(t *testing.T) {
+	down := startPrimary(t, newZone(t, 1, "192.0.2.1"))
+	down.down.Store(true)
+	up := startPrimary(t, newZone(t, 1, "192.0.2.2"))
+	s := newSecondary(t, down)
+	s.Primaries = append(s.Primaries, up.addr)
+
+	if err := s.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if address(t, s) != "192.0.2.2" {
+		t.Fatalf("expected the zone of the primary that answered, got %s", address(t, s))
+	}
+
+	up.down.Store(true)
+	if err := s.Refresh(context.Background()); err == nil || !strings.Contains(err.Error(), ErrNoPrimary.Error()) {
+		t.Fatalf("expected %v, got %v", ErrNoPrimary, err)
+	}
+}
+
+// Run waits RETRY after a failed refresh and REFRESH after a successful one, never past EXPIRE
+func TestNext(t *testing.T) {
+	p := startPrimary(t, newZone(t, 1, "192.0.2.1"))
+	s := newSecondary(t, p)
+	if wait := s.next(true); wait != defaultRetry {
+		t.Fatalf("expected %s without a copy, got %s", defaultRetry, wait)
+	}
+
+	if err := s.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if wait := s.next(false); wait != refresh*time.Second {
+		t.Fatalf("expected the REFRESH interval after a success, got %s", wait)
+	}
+	if wait := s.next(true); wait != retry*time.Second {
+		t.Fatalf("expected the RETRY interval after a failure, got %s", wait)
+	}
+
+	// the copy expires in 30 seconds
+	s.refreshed = time.Now().Add(-(expire - 30) * time.Second)
+	if wait := s.next(false); wait > 30*time.Second || wait < 29*time.Second {
+		t.Fatalf("expected to wait until the copy expires, got %s", wait)
+	}
+}
+
+// a failed refresh is retried, here on a NOTIFY so the test doesn't wait for RETRY
+func TestRunRetries(t *testing.T) {
+	p := startPrimary(t, newZone(t, 1, "192.0.2.1"))
+	p.down.Store(true)
+	s := newSecondary(t, p)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitFor := func(condition func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !condition() {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// the first refresh fails
+	waitFor(func() bool { return p.polls.Load() > 0 })
+	if _, ok := s.Store.Get(s.Origin); ok {
+		t.Fatal("the zone was transferred from a primary that is down")
+	}
+
+	p.down.Store(false)
+	s.Notified()
+	waitFor(func() bool {
+		_, ok := s.Store.Get(s.Origin)
+		return ok
+	})
+}
+
+func TestExpire(t *testing.T) {
+	p := startPrimary(t, newZone(t, 1, "192.0.2.1"))
+	s := newSecondary(t, p)
+	if err := s.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	z, _ := s.Store.Get(s.Origin)
+	h := &zone.Handler{Store: s.Store}
+	query := &server.Request{Message: &dns.Message{Questions: []*dns.Question{{QNAME: "www.example.com.", QTYPE: "A", QCLASS: "IN"}}}}
+
+	// no refresh succeeded for longer than EXPIRE
+	p.down.Store(true)
+	s.refreshed = time.Now().Add(-expire * time.Second)
+	if err := s.Refresh(context.Background()); err == nil {
+		t.Fatal("expected the refresh to fail")
+	}
+	if _, ok := s.expire(); ok || !z.Expired() {
+		t.Fatal("expected the copy to expire")
+	}
+	if response, _ := h.ServeDNS(query); response.Header.RCODE != dns.RcodeServFail {
+		t.Fatalf("expected SERVFAIL once expired, got %s", dns.RcodeString(response.Header.RCODE))
+	}
+
+	// a successful refresh serves it again, even without a transfer
+	p.down.Store(false)
+	if err := s.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if z.Expired() {
+		t.Fatal("expected the copy to be served again after a refresh")
+	}
+	if response, _ := h.ServeDNS(query); response.Header.RCODE != dns.RcodeNoError || len(response.Answers) != 1 {
+		t.Fatalf("expected the answer, got %s with %d answers", dns.RcodeString(response.Header.RCODE), len(response.Answers))
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		age     time.Duration
+		expired bool
+	}{
+		{"recent copy", time.Minute, false},
+		{"copy older than EXPIRE", 2 * expire * time.Second, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "example.com.zone")
+			if err := save(newZone(t, 7, "192.0.2.7"), path); err != nil {
+				t.Fatal(err)
+			}
+			modified := time.Now().Add(-test.age)
+			if err := os.Chtimes(path, modified, modified); err != nil {
+				t.Fatal(err)
+			}
+
+			// the primary is unreachable, the copy on disk is served before any transfer
+			s := &Zone{Origin: "example.com.", Class: "IN", Primaries: []string{"127.0.0.1:1"}, Store: zone.NewStore(), File: path}
+			if err := s.Load(); err != nil {
+				t.Fatal(err)
+			}
+			z, ok := s.Store.Get(s.Origin)
+			if !ok {
+				t.Fatal("expected the saved copy to be served")
+			}
+			if serial, _ := z.Serial(); serial != 7 || address(t, s) != "192.0.2.7" || !z.Secondary() {
+				t.Fatalf("unexpected copy, serial %d, secondary %t", serial, z.Secondary())
+			}
+			if z.Expired() != test.expired {
+				t.Fatalf("expected expired %t, got %t", test.expired, z.Expired())
+			}
+		})
+	}
+}
+
+// Load without a saved copy leaves the store empty for the first transfer
+func TestLoadWithoutFile(t *testing.T) {
+	s := &Zone{Origin: "example.com.", Class: "IN", Store: zone.NewStore(), File: filepath.Join(t.TempDir(), "missing")}
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Store.Get(s.Origin); ok {
+		t.Fatal("expected no zone in the store")
+	}
+}
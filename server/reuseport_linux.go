@@ -0,0 +1,6 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+
+package server
+
+// the syscall package doesn't define SO_REUSEPORT for every linux architecture
+const soReusePort = 0xf
@@ -0,0 +1,362 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/tsig"
+)
+
+const (
+	// largest payload a UDP client can receive without EDNS (RFC 1035 section 4.2.1)
+	minUDPSize = 512
+	// the UDP payload size advertised when Server.UDPSize is not set,
+	// it avoids IP fragmentation on most paths (DNS flag day 2020)
+	defaultUDPSize = 1232
+	// largest possible UDP payload, the read buffer must hold any datagram
+	maxUDPSize = 65535
+)
+
+// Request is what a Handler receives for every decoded query
+type Request struct {
+	Message    *dns.Message
+	RemoteAddr net.Addr
+	// "udp" or "tcp"
+	Network string
+	// name of the TSIG key the query was signed with, empty when it's not signed.
+	// queries with a TSIG that doesn't verify never reach the Handler
+	Key string
+}
+
+// Handler answers a decoded query.
+// The returned message only needs to carry the sections the handler is responsible for,
+// the Server echoes the query ID, OPCODE, RD and questions into the reply before encoding it.
+// Returning an error (or a nil message) makes the server reply with SERVFAIL.
+type Handler interface {
+	ServeDNS(req *Request) (*dns.Message, error)
+}
+
+// StreamHandler is implemented by handlers answering some queries with a sequence of messages,
+// which the Server only does over TCP for zone transfers (AXFR and IXFR questions)
+type StreamHandler interface {
+	Handler
+	// ServeDNSStream passes every message of the response to send, in order. Like with ServeDNS
+	// the Server echoes the query identity into each of them. An error before anything was sent
+	// makes the server reply with SERVFAIL, after that the connection is closed
+	ServeDNSStream(req *Request, send func(*dns.Message) error) error
+}
+
+// HandlerFunc allows using ordinary functions as a Handler
+type HandlerFunc func(req *Request) (*dns.Message, error)
+
+func (f HandlerFunc) ServeDNS(req *Request) (*dns.Message, error) {
+	return f(req)
+}
+
+type Server struct {
+	// address to listen on, e.g. "127.0.0.1:2053"
+	Addr    string
+	Handler Handler
+	// largest UDP payload the server advertises with EDNS(0), defaults to 1232
+	UDPSize uint16
+	// how long a TCP connection may stay without receiving a query, defaults to 10s
+	IdleTimeout time.Duration
+	// how many TCP connections a single client IP may keep open at the same time, defaults to 16
+	MaxTCPConnsPerClient int
+	// goroutines handling UDP queries of each socket, defaults to 64
+	Workers int
+	// UDP packets waiting for a worker, when it's full new packets are dropped so the reader
+	// keeps draining the socket and the clients retry. defaults to 4 * Workers
+	QueueSize int
+	// number of UDP sockets bound to Addr with SO_REUSEPORT, each one with its own reader
+	// and workers so the kernel spreads the load between cores. 0 or 1 opens a single regular socket
+	ReusePort int
+	// keys accepted in signed queries, the responses to them are signed with the same key (RFC 8945)
+	Keyring tsig.Keyring
+
+	tcpConnsMutex sync.Mutex
+	tcpConns      map[string]int
+
+	// everything Shutdown has to stop, guarded by mutex
+	mutex       sync.Mutex
+	closing     bool
+	udpConns    map[*net.UDPConn]struct{}
+	listeners   map[net.Listener]struct{}
+	activeConns map[net.Conn]struct{}
+	// serve loops and TCP connections still running
+	active sync.WaitGroup
+}
+
+// ErrServerClosed is returned by the Serve and ListenAndServe methods after Shutdown
+var ErrServerClosed = errors.New("server closed")
+
+// ListenAndServe listens on Addr over both UDP and TCP,
+// it returns when any of them stops with the error that stopped it
+func (s *Server) ListenAndServe() error {
+	udpConns, err := s.listenUDP()
+	if err != nil {
+		return err
+	}
+	defer closeAll(udpConns)
+
+	tcpListener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to bind the TCP listener, cause: %w", err)
+	}
+	defer tcpListener.Close()
+
+	errs := make(chan error, len(udpConns)+1)
+	for _, conn := range udpConns {
+		go func() { errs <- s.ServeUDP(conn) }()
+	}
+	go func() { errs <- s.ServeTCP(tcpListener) }()
+
+	err = <-errs
+	// the sockets are only closed once every loop is done, so in flight queries can still be answered
+	if !errors.Is(err, ErrServerClosed) {
+		closeAll(udpConns)
+		tcpListener.Close()
+	}
+	for range len(udpConns) {
+		<-errs
+	}
+	return err
+}
+
+// listenUDP opens a single socket, or ReusePort sockets bound to the same address with SO_REUSEPORT
+func (s *Server) listenUDP() ([]*net.UDPConn, error) {
+	if s.ReusePort <= 1 {
+		udpAddr, err := net.ResolveUDPAddr("udp", s.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve UDP address, cause: %w", err)
+		}
+		conn, err := net.ListenUDP("udp", udpAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to bind to address, cause: %w", err)
+		}
+		return []*net.UDPConn{conn}, nil
+	}
+
+	config := net.ListenConfig{Control: setReusePort}
+	conns := make([]*net.UDPConn, 0, s.ReusePort)
+	for range s.ReusePort {
+		conn, err := config.ListenPacket(context.Background(), "udp", s.Addr)
+		if err != nil {
+			closeAll(conns)
+			return nil, fmt.Errorf("failed to bind to address with SO_REUSEPORT, cause: %w", err)
+		}
+		conns = append(conns, conn.(*net.UDPConn))
+	}
+	return conns, nil
+}
+
+func closeAll(conns []*net.UDPConn) {
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// Shutdown stops reading new queries and waits until every query already read is answered,
+// or until the context is done. Sockets opened by ListenAndServe are closed afterwards,
+// the ones given to ServeUDP/ServeTCP are left to the caller
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.closing = true
+	now := time.Now()
+	// an expired read deadline unblocks readers without closing the socket, replies can still be written
+	for conn := range s.udpConns {
+		conn.SetReadDeadline(now)
+	}
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.activeConns {
+		conn.SetReadDeadline(now)
+	}
+	s.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) isClosing() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closing
+}
+
+// track registers something Shutdown has to wait for, returns false when the server is already closing.
+// registering under the mutex guarantees no active.Add happens after Shutdown starts waiting
+func (s *Server) track(register func()) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closing {
+		return false
+	}
+	if s.udpConns == nil {
+		s.udpConns = map[*net.UDPConn]struct{}{}
+		s.listeners = map[net.Listener]struct{}{}
+		s.activeConns = map[net.Conn]struct{}{}
+	}
+	register()
+	s.active.Add(1)
+	return true
+}
+
+func (s *Server) untrack(unregister func()) {
+	s.mutex.Lock()
+	unregister()
+	s.mutex.Unlock()
+	s.active.Done()
+}
+
+func (s *Server) udpSize() uint16 {
+	if s.UDPSize < minUDPSize {
+		return defaultUDPSize
+	}
+	return s.UDPSize
+}
+
+// handle decodes the payload and dispatches it to the Handler.
+// returns the reply (nil when nothing should be sent back), the largest
+// UDP payload the client is able to receive and the TSIG session signing the reply
+func (s *Server) handle(payload []byte, source net.Addr, network string) (*dns.Message, int, *tsig.Session) {
+	query, reply, maxSize, session := s.decodeQuery(payload, source)
+	if query == nil {
+		return reply, maxSize, session
+	}
+	return s.dispatch(query, source, network, session), maxSize, session
+}
+
+// decodeQuery returns the query that should go to the Handler, or the reply to send
+// without calling it (nil when nothing should be sent back), the largest UDP payload
+// the client is able to receive and, for signed queries, the TSIG session signing the reply
+func (s *Server) decodeQuery(payload []byte, source net.Addr) (*dns.Message, *dns.Message, int, *tsig.Session) {
+	query, err := dns.DecodeMessage(payload)
+	if err != nil {
+		log.Printf("failed to decode the query from %s, cause: %s", source, err)
+		// without a header there is no ID to answer to
+		header, err := dns.DecodeHeader(payload)
+		if err != nil || header.QR {
+			return nil, nil, 0, nil
+		}
+		return nil, s.errorReply(&dns.Message{Header: header}, dns.RcodeFormErr), minUDPSize, nil
+	}
+
+	// never answer to responses, it could be used to make two servers talk to each other forever
+	if query.Header.QR {
+		return nil, nil, 0, nil
+	}
+
+	maxSize := minUDPSize
+	if query.EDNS != nil {
+		maxSize = max(minUDPSize, int(min(query.EDNS.UDPSIZE, s.udpSize())))
+	}
+
+	var session *tsig.Session
+	if isTSIG := func(record dns.Answer) bool { return record.TYPE == "TSIG" }; slices.ContainsFunc(query.Additional, isTSIG) {
+		query.Additional = slices.DeleteFunc(query.Additional, isTSIG)
+		session, err = s.Keyring.Accept(payload, time.Now())
+		if err != nil {
+			log.Printf("rejecting the signed query %d from %s, cause: %s", query.Header.ID, source, err)
+			// without a session the TSIG record itself is malformed
+			if session == nil {
+				return nil, s.errorReply(query, dns.RcodeFormErr), maxSize, nil
+			}
+			return nil, s.errorReply(query, dns.RcodeNotAuth), maxSize, session
+		}
+	}
+
+	// only version 0 exists, the reply must use the highest version the server implements (RFC 6891 section 6.1.3)
+	if query.EDNS != nil && query.EDNS.VERSION > 0 {
+		return nil, s.errorReply(query, dns.RcodeBadVers), maxSize, session
+	}
+
+	return query, nil, maxSize, session
+}
+
+func (s *Server) dispatch(query *dns.Message, source net.Addr, network string, session *tsig.Session) *dns.Message {
+	req := &Request{Message: query, RemoteAddr: source, Network: network}
+	if session != nil {
+		req.Key = session.Key.Name
+	}
+	response, err := s.Handler.ServeDNS(req)
+	if err != nil || response == nil {
+		if err != nil {
+			log.Printf("handler failed for query %d from %s, cause: %s", query.Header.ID, source, err)
+		}
+		return s.errorReply(query, dns.RcodeServFail)
+	}
+
+	return s.makeReply(query, response)
+}
+
+// makeReply copies the query identity into the handler response.
+// the response only carries an OPT record when the query had one
+func (s *Server) makeReply(query *dns.Message, response *dns.Message) *dns.Message {
+	response.Header.ID = query.Header.ID
+	response.Header.QR = true
+	response.Header.OPCODE = query.Header.OPCODE
+	response.Header.RD = query.Header.RD
+	response.Questions = query.Questions
+
+	if query.EDNS == nil {
+		response.EDNS = nil
+		return response
+	}
+
+	var options []dns.EDNSOption
+	if response.EDNS != nil {
+		options = response.EDNS.OPTIONS
+	}
+	response.EDNS = &dns.EDNS{
+		UDPSIZE: s.udpSize(),
+		// DO is copied from the query (RFC 3225 section 3)
+		DO:      query.EDNS.DO,
+		OPTIONS: options,
+	}
+
+	return response
+}
+
+// encode builds the wire format of the reply within limit, signing it when the query was signed.
+// a reply that fails to encode is replaced by SERVFAIL
+func (s *Server) encode(reply *dns.Message, limit int, session *tsig.Session) ([]byte, error) {
+	if session != nil {
+		limit -= session.Size()
+	}
+	response, err := reply.EncodeMessageWithLimit(limit)
+	if err != nil {
+		log.Printf("failed to encode the response for query %d, cause: %s", reply.Header.ID, err)
+		if response, err = s.errorReply(reply, dns.RcodeServFail).EncodeMessageWithLimit(limit); err != nil {
+			return nil, err
+		}
+	}
+	if session == nil {
+		return response, nil
+	}
+	return session.Sign(response, time.Now())
+}
+
+func (s *Server) errorReply(query *dns.Message, rcode uint16) *dns.Message {
+	response := s.makeReply(query, &dns.Message{})
+	response.Header.RCODE = rcode
+	return response
+}
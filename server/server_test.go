@@ -0,0 +1,112 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+func TestHandle(t *testing.T) {
+	query := encodeQuery(t, 0xBEEF, "www.example.")
+	response := encodeQuery(t, 0xBEEF, "www.example.")
+	// the same message with QR set
+	response[2] |= 0x80
+	badVersion := &dns.Message{
+		Header:    dns.Header{ID: 0xBEEF},
+		Questions: []*dns.Question{{QNAME: "www.example.", QTYPE: "A", QCLASS: "IN"}},
+		EDNS:      &dns.EDNS{UDPSIZE: 1232, VERSION: 1},
+	}
+	badVersionPayload, err := badVersion.EncodeMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answer := HandlerFunc(func(req *Request) (*dns.Message, error) {
+		// nothing of the query identity, the server fills it in
+		return &dns.Message{Header: dns.Header{ID: 1, AA: true}}, nil
+	})
+	failing := HandlerFunc(func(req *Request) (*dns.Message, error) {
+		return nil, errors.New("failed")
+	})
+	empty := HandlerFunc(func(req *Request) (*dns.Message, error) {
+		return nil, nil
+	})
+
+	tests := []struct {
+		name    string
+		handler Handler
+		payload []byte
+		// -1 when nothing is sent back
+		rcode     int
+		questions int
+		called    bool
+	}{
+		{"answered", answer, query, int(dns.RcodeNoError), 1, true},
+		{"handler error", failing, query, int(dns.RcodeServFail), 1, true},
+		{"no response", empty, query, int(dns.RcodeServFail), 1, true},
+		{"undecodable question", answer, query[:len(query)-3], int(dns.RcodeFormErr), 0, false},
+		{"no header", answer, query[:5], -1, 0, false},
+		{"response", answer, response, -1, 0, false},
+		{"undecodable response", answer, response[:len(response)-3], -1, 0, false},
+		{"EDNS version 1", answer, badVersionPayload, int(dns.RcodeBadVers), 1, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			called := false
+			s := &Server{Handler: HandlerFunc(func(req *Request) (*dns.Message, error) {
+				called = true
+				return test.handler.ServeDNS(req)
+			})}
+			reply, _, _ := s.handle(test.payload, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}, "udp")
+			if called != test.called {
+				t.Fatalf("expected the handler to be called: %t", test.called)
+			}
+			if test.rcode < 0 {
+				if reply != nil {
+					t.Fatalf("expected no reply, got %s", dns.RcodeString(reply.Header.RCODE))
+				}
+				return
+			}
+			if reply == nil {
+				t.Fatal("expected a reply")
+			}
+			if reply.Header.RCODE != uint16(test.rcode) {
+				t.Fatalf("expected %s, got %s", dns.RcodeString(uint16(test.rcode)), dns.RcodeString(reply.Header.RCODE))
+			}
+			if reply.Header.ID != 0xBEEF || !reply.Header.QR {
+				t.Fatalf("expected the reply to query 0xBEEF, got ID %#x with QR %t", reply.Header.ID, reply.Header.QR)
+			}
+			if len(reply.Questions) != test.questions || (test.questions > 0 && reply.Questions[0].QNAME != "www.example.") {
+				t.Fatalf("expected %d questions echoed, got %v", test.questions, reply.Questions)
+			}
+		})
+	}
+}
+
+// the reply on the wire carries the identity of the query whatever the handler set
+func TestHandleEcho(t *testing.T) {
+	conn, _ := serveUDP(t, &Server{Handler: HandlerFunc(func(req *Request) (*dns.Message, error) {
+		return &dns.Message{Header: dns.Header{ID: 1, RD: false, AA: true}}, nil
+	})})
+	query := &dns.Message{
+		Header:    dns.Header{ID: 0xBEEF, RD: true},
+		Questions: []*dns.Question{{QNAME: "www.example.", QTYPE: "MX", QCLASS: "IN"}},
+	}
+	payload, err := query.EncodeMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := exchangeUDP(t, dialUDP(t, conn), payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := response.Header
+	if header.ID != 0xBEEF || !header.QR || !header.RD || !header.AA || header.OPCODE != dns.OpcodeQuery {
+		t.Fatalf("unexpected header %+v", header)
+	}
+	if len(response.Questions) != 1 || *response.Questions[0] != *query.Questions[0] {
+		t.Fatalf("expected the question to be echoed, got %v", response.Questions)
+	}
+}
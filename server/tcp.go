@@ -0,0 +1,233 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/tsig"
+)
+
+const (
+	defaultIdleTimeout          = 10 * time.Second
+	defaultMaxTCPConnsPerClient = 16
+	// queries of a single connection handled at the same time, reading stops while it's reached
+	maxTCPInFlight = 32
+	// time allowed to write a response before giving up on the connection
+	tcpWriteTimeout = 10 * time.Second
+)
+
+// ServeTCP accepts connections until the listener fails.
+// Messages are framed with a 2 byte length prefix (RFC 1035 section 4.2.2), a connection may
+// pipeline several queries and the responses are sent as soon as each one is ready,
+// so they can go out of order (RFC 7766 section 6.2.1.1)
+func (s *Server) ServeTCP(l net.Listener) error {
+	if !s.track(func() { s.listeners[l] = struct{}{} }) {
+		return ErrServerClosed
+	}
+	defer s.untrack(func() { delete(s.listeners, l) })
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosing() {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return fmt.Errorf("failed to accept connection, cause: %w", err)
+		}
+
+		client := clientIP(conn.RemoteAddr())
+		if !s.acquireTCPConn(client) {
+			log.Printf("closing connection from %s, too many connections from the same client", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		if !s.track(func() { s.activeConns[conn] = struct{}{} }) {
+			s.releaseTCPConn(client)
+			conn.Close()
+			return ErrServerClosed
+		}
+
+		go func() {
+			defer s.untrack(func() { delete(s.activeConns, conn) })
+			defer s.releaseTCPConn(client)
+			s.serveTCPConn(conn)
+		}()
+	}
+}
+
+func (s *Server) serveTCPConn(conn net.Conn) {
+	defer conn.Close()
+
+	var writeMutex sync.Mutex
+	var inFlight sync.WaitGroup
+	slots := make(chan struct{}, maxTCPInFlight)
+
+	for {
+		if !s.armIdleTimeout(conn) {
+			break
+		}
+		payload, err := dns.ReadTCP(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !s.isClosing() {
+				log.Printf("closing connection from %s, cause: %s", conn.RemoteAddr(), err)
+			}
+			break
+		}
+
+		slots <- struct{}{}
+		inFlight.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				inFlight.Done()
+			}()
+
+			query, reply, _, session := s.decodeQuery(payload, conn.RemoteAddr())
+			if stream, ok := s.Handler.(StreamHandler); ok && query != nil && isTransfer(query) {
+				writeMutex.Lock()
+				defer writeMutex.Unlock()
+				s.serveStream(conn, stream, query, session)
+				return
+			}
+			if query != nil {
+				reply = s.dispatch(query, conn.RemoteAddr(), "tcp", session)
+			}
+			if reply == nil {
+				return
+			}
+			response, err := s.encode(reply, dns.MaxTCPSize, session)
+			if err != nil {
+				log.Printf("failed to encode the response for query %d, cause: %s", reply.Header.ID, err)
+				return
+			}
+
+			writeMutex.Lock()
+			defer writeMutex.Unlock()
+			if err := writeTCPMessage(conn, response); err != nil {
+				log.Printf("failed to send response to %s, cause: %s", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+
+	// let the queries already read finish before closing
+	inFlight.Wait()
+}
+
+// isTransfer reports zone transfer queries, they're answered by a StreamHandler
+func isTransfer(query *dns.Message) bool {
+	if len(query.Questions) != 1 {
+		return false
+	}
+	qtype := query.Questions[0].QTYPE
+	return qtype == "AXFR" || qtype == "IXFR"
+}
+
+// serveStream writes every message of the StreamHandler response, the caller holds the write mutex
+// so the stream isn't interleaved with other responses. When the stream breaks half way the
+// connection is closed, as the client has no other way to know it won't get the rest.
+// every message of a signed query is signed, each one covering the previous (RFC 8945 section 5.3.1)
+func (s *Server) serveStream(conn net.Conn, stream StreamHandler, query *dns.Message, session *tsig.Session) {
+	sent := 0
+	send := func(message *dns.Message) error {
+		response, err := s.makeReply(query, message).EncodeMessage()
+		if err == nil && session != nil {
+			response, err = session.Sign(response, time.Now())
+		}
+		if err != nil {
+			return fmt.Errorf("failed to encode message %d of the stream, cause: %w", sent, err)
+		}
+		if err := writeTCPMessage(conn, response); err != nil {
+			return err
+		}
+		sent++
+		return nil
+	}
+
+	req := &Request{Message: query, RemoteAddr: conn.RemoteAddr(), Network: "tcp"}
+	if session != nil {
+		req.Key = session.Key.Name
+	}
+	err := stream.ServeDNSStream(req, send)
+	if err == nil {
+		return
+	}
+	log.Printf("failed to stream the response of query %d to %s, cause: %s", query.Header.ID, conn.RemoteAddr(), err)
+	if sent > 0 {
+		conn.Close()
+		return
+	}
+	if err := send(s.errorReply(query, dns.RcodeServFail)); err != nil {
+		conn.Close()
+	}
+}
+
+// armIdleTimeout sets the read deadline for the next query, returns false when the connection
+// should stop reading. It's done under the mutex so it can't override the deadline set by Shutdown
+func (s *Server) armIdleTimeout(conn net.Conn) bool {
+	idleTimeout := s.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closing {
+		return false
+	}
+	return conn.SetReadDeadline(time.Now().Add(idleTimeout)) == nil
+}
+
+func (s *Server) acquireTCPConn(client string) bool {
+	limit := s.MaxTCPConnsPerClient
+	if limit <= 0 {
+		limit = defaultMaxTCPConnsPerClient
+	}
+
+	s.tcpConnsMutex.Lock()
+	defer s.tcpConnsMutex.Unlock()
+	if s.tcpConns == nil {
+		s.tcpConns = map[string]int{}
+	}
+	if s.tcpConns[client] >= limit {
+		return false
+	}
+	s.tcpConns[client]++
+	return true
+}
+
+func (s *Server) releaseTCPConn(client string) {
+	s.tcpConnsMutex.Lock()
+	defer s.tcpConnsMutex.Unlock()
+	s.tcpConns[client]--
+	if s.tcpConns[client] <= 0 {
+		delete(s.tcpConns, client)
+	}
+}
+
+func clientIP(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func writeTCPMessage(conn net.Conn, payload []byte) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout)); err != nil {
+		return err
+	}
+	return dns.WriteTCP(conn, payload)
+}
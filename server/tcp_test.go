@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+func encodeQuery(t *testing.T, id uint16, name string) []byte {
+	t.Helper()
+	query := &dns.Message{
+		Header:    dns.Header{ID: id, RD: true},
+		Questions: []*dns.Question{{QNAME: name, QTYPE: "A", QCLASS: "IN"}},
+	}
+	payload, err := query.EncodeMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+// frame adds the 2 byte length prefix
+func frame(payload []byte) []byte {
+	return append([]byte{byte(len(payload) >> 8), byte(len(payload))}, payload...)
+}
+
+// serveTCP serves on a loopback listener until the test ends, returns its address
+func serveTCP(t *testing.T, s *Server) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- s.ServeTCP(l) }()
+	t.Cleanup(func() {
+		s.Shutdown(context.Background())
+		if err := <-errs; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("expected ErrServerClosed, got %v", err)
+		}
+	})
+	return l.Addr().String()
+}
+
+func dialTCP(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func readResponse(t *testing.T, conn net.Conn) *dns.Message {
+	t.Helper()
+	payload, err := dns.ReadTCP(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := dns.DecodeMessage(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+// expectClosed fails unless the server closes the connection within the given time
+func expectClosed(t *testing.T, conn net.Conn, within time.Duration) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(within))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !isReset(err) {
+		t.Fatalf("expected the server to close the connection, got %v", err)
+	}
+}
+
+func isReset(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && !opErr.Timeout()
+}
+
+func TestTCPFraming(t *testing.T) {
+	addr := serveTCP(t, &Server{Handler: HandlerFunc(answerHandler)})
+	conn := dialTCP(t, addr)
+
+	// two messages in a single write
+	if _, err := conn.Write(append(frame(encodeQuery(t, 1, "a.example.")), frame(encodeQuery(t, 2, "b.example."))...)); err != nil {
+		t.Fatal(err)
+	}
+	seen := map[uint16]string{}
+	for range 2 {
+		response := readResponse(t, conn)
+		seen[response.Header.ID] = response.Answers[0].NAME
+	}
+	if seen[1] != "a.example." || seen[2] != "b.example." {
+		t.Fatalf("unexpected responses %v", seen)
+	}
+
+	// a message split across several writes, the length prefix included
+	framed := frame(encodeQuery(t, 3, "c.example."))
+	for _, part := range [][]byte{framed[:1], framed[1:5], framed[5:]} {
+		if _, err := conn.Write(part); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if response := readResponse(t, conn); response.Header.ID != 3 || response.Answers[0].NAME != "c.example." {
+		t.Fatalf("unexpected response %d for %s", response.Header.ID, response.Answers[0].NAME)
+	}
+}
+
+// a slow query doesn't hold back the ones pipelined after it (RFC 7766 section 6.2.1.1)
+func TestTCPPipelining(t *testing.T) {
+	release := make(chan struct{})
+	s := &Server{Handler: HandlerFunc(func(req *Request) (*dns.Message, error) {
+		if req.Message.Questions[0].QNAME == "slow.example." {
+			<-release
+		}
+		return answerHandler(req)
+	})}
+	addr := serveTCP(t, s)
+	conn := dialTCP(t, addr)
+
+	if _, err := conn.Write(append(frame(encodeQuery(t, 1, "slow.example.")), frame(encodeQuery(t, 2, "fast.example."))...)); err != nil {
+		t.Fatal(err)
+	}
+	if response := readResponse(t, conn); response.Header.ID != 2 {
+		t.Fatalf("expected the fast query to be answered first, got %d", response.Header.ID)
+	}
+	close(release)
+	if response := readResponse(t, conn); response.Header.ID != 1 {
+		t.Fatalf("expected the slow query to be answered, got %d", response.Header.ID)
+	}
+}
+
+func TestTCPIdleTimeout(t *testing.T) {
+	addr := serveTCP(t, &Server{Handler: HandlerFunc(answerHandler), IdleTimeout: 100 * time.Millisecond})
+
+	// the timeout starts again after each query
+	conn := dialTCP(t, addr)
+	for i := range 3 {
+		time.Sleep(50 * time.Millisecond)
+		if _, err := conn.Write(frame(encodeQuery(t, uint16(i), "a.example."))); err != nil {
+			t.Fatal(err)
+		}
+		readResponse(t, conn)
+	}
+	expectClosed(t, conn, time.Second)
+
+	// a connection that never sends anything
+	expectClosed(t, dialTCP(t, addr), time.Second)
+}
+
+func TestTCPConnLimit(t *testing.T) {
+	addr := serveTCP(t, &Server{Handler: HandlerFunc(answerHandler), MaxTCPConnsPerClient: 2})
+
+	var conns []net.Conn
+	for i := range 2 {
+		conn := dialTCP(t, addr)
+		// answered, so the server counted it
+		if _, err := conn.Write(frame(encodeQuery(t, uint16(i), "a.example."))); err != nil {
+			t.Fatal(err)
+		}
+		readResponse(t, conn)
+		conns = append(conns, conn)
+	}
+	expectClosed(t, dialTCP(t, addr), time.Second)
+
+	// a closed connection frees its slot
+	conns[0].Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn := dialTCP(t, addr)
+		conn.Write(frame(encodeQuery(t, 3, "a.example.")))
+		if _, err := dns.ReadTCP(conn); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected a new connection to be accepted once another one closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// a client that stops half way through a message is dropped after the idle timeout
+func TestTCPTruncated(t *testing.T) {
+	addr := serveTCP(t, &Server{Handler: HandlerFunc(answerHandler), IdleTimeout: 100 * time.Millisecond})
+	framed := frame(encodeQuery(t, 1, "a.example."))
+
+	tests := []struct {
+		name    string
+		payload []byte
+	}{
+		{"half the length prefix", framed[:1]},
+		{"part of the message", framed[:10]},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := dialTCP(t, addr)
+			if _, err := conn.Write(test.payload); err != nil {
+				t.Fatal(err)
+			}
+			expectClosed(t, conn, time.Second)
+		})
+	}
+}
+
+// Shutdown doesn't wait for the idle timeout of a connection stuck on a truncated message
+func TestTCPTruncatedShutdown(t *testing.T) {
+	s := &Server{Handler: HandlerFunc(answerHandler), IdleTimeout: time.Minute}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- s.ServeTCP(l) }()
+
+	conn := dialTCP(t, l.Addr().String())
+	if _, err := conn.Write(frame(encodeQuery(t, 1, "a.example."))[:1]); err != nil {
+		t.Fatal(err)
+	}
+	// connections are accepted in order, once a later one is answered the first one is served
+	probe := dialTCP(t, l.Addr().String())
+	probe.Write(frame(encodeQuery(t, 2, "a.example.")))
+	readResponse(t, probe)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("expected Shutdown to close the connection, got %v", err)
+	}
+	if err := <-errs; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("expected ErrServerClosed, got %v", err)
+	}
+	expectClosed(t, conn, time.Second)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+)
+
+const defaultWorkers = 64
+
+type udpPacket struct {
+	buf    *[]byte
+	size   int
+	source *net.UDPAddr
+}
+
+// every packet gets its own buffer while it waits for and is handled by a worker
+var udpBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, maxUDPSize)
+		return &buf
+	},
+}
+
+// ServeUDP reads queries from the connection and hands them to a pool of workers,
+// it returns when reading fails or after Shutdown, once every packet already read is answered
+func (s *Server) ServeUDP(conn *net.UDPConn) error {
+	if !s.track(func() { s.udpConns[conn] = struct{}{} }) {
+		return ErrServerClosed
+	}
+	defer s.untrack(func() { delete(s.udpConns, conn) })
+
+	workers := s.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	queueSize := s.QueueSize
+	if queueSize <= 0 {
+		queueSize = 4 * workers
+	}
+
+	queue := make(chan udpPacket, queueSize)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for packet := range queue {
+				s.serveUDPPacket(conn, packet)
+			}
+		}()
+	}
+	// the workers drain the queue before the loop returns
+	defer wg.Wait()
+	defer close(queue)
+
+	for {
+		buf := udpBufferPool.Get().(*[]byte)
+		size, source, err := conn.ReadFromUDP(*buf)
+		if err != nil {
+			udpBufferPool.Put(buf)
+			if s.isClosing() {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return fmt.Errorf("failed to receive data, cause: %w", err)
+		}
+
+		// while every worker is busy and the queue is full the packet is dropped (load shedding),
+		// blocking here would leave every client waiting on the slowest queries
+		select {
+		case queue <- udpPacket{buf: buf, size: size, source: source}:
+		default:
+			udpBufferPool.Put(buf)
+		}
+	}
+}
+
+func (s *Server) serveUDPPacket(conn *net.UDPConn, packet udpPacket) {
+	defer udpBufferPool.Put(packet.buf)
+
+	reply, maxSize, session := s.handle((*packet.buf)[:packet.size], packet.source, "udp")
+	if reply == nil {
+		return
+	}
+
+	response, err := s.encode(reply, maxSize, session)
+	if err != nil {
+		log.Printf("failed to encode the response for query %d, cause: %s", reply.Header.ID, err)
+		return
+	}
+
+	if _, err := conn.WriteToUDP(response, packet.source); err != nil {
+		log.Printf("failed to send response to %s, cause: %s", packet.source, err)
+	}
+}
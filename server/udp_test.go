@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+func answerHandler(req *Request) (*dns.Message, error) {
+	return &dns.Message{Answers: []dns.Answer{{
+		NAME: req.Message.Questions[0].QNAME, TYPE: "A", CLASS: "IN", TTL: 60,
+		RDATA: &dns.A{ADDRESS: netip.MustParseAddr("192.0.2.1")},
+	}}}, nil
+}
+
+// serveUDP serves on a loopback socket, the returned function shuts the server down
+// and gives what ServeUDP returned
+func serveUDP(t *testing.T, s *Server) (*net.UDPConn, func() error) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	errs := make(chan error, 1)
+	go func() { errs <- s.ServeUDP(conn) }()
+	var once sync.Once
+	var served error
+	shutdown := func() error {
+		once.Do(func() {
+			s.Shutdown(context.Background())
+			served = <-errs
+		})
+		return served
+	}
+	t.Cleanup(func() { shutdown() })
+	return conn, shutdown
+}
+
+func dialUDP(t *testing.T, conn *net.UDPConn) *net.UDPConn {
+	t.Helper()
+	client, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func exchangeUDP(t *testing.T, client *net.UDPConn, payload []byte) (*dns.Message, error) {
+	t.Helper()
+	if _, err := client.Write(payload); err != nil {
+		return nil, err
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, maxUDPSize)
+	size, err := client.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return dns.DecodeMessage(buf[:size])
+}
+
+// blockingHandler holds slow.example. queries until release is closed
+type blockingHandler struct {
+	started chan struct{}
+	release chan struct{}
+	handled atomic.Int32
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{started: make(chan struct{}, 100), release: make(chan struct{})}
+}
+
+func (h *blockingHandler) ServeDNS(req *Request) (*dns.Message, error) {
+	if req.Message.Questions[0].QNAME == "slow.example." {
+		h.handled.Add(1)
+		h.started <- struct{}{}
+		<-h.release
+	}
+	return answerHandler(req)
+}
+
+// with the worker busy and the queue full the reader drops packets instead of waiting
+func TestUDPQueueFull(t *testing.T) {
+	h := newBlockingHandler()
+	conn, _ := serveUDP(t, &Server{Handler: h, Workers: 1, QueueSize: 1})
+	client := dialUDP(t, conn)
+
+	client.Write(encodeQuery(t, 1, "slow.example."))
+	<-h.started
+	// one waits in the queue, the rest is dropped
+	for i := range 10 {
+		client.Write(encodeQuery(t, uint16(2+i), "slow.example."))
+	}
+	// the reader isn't blocked, it keeps draining the socket
+	time.Sleep(100 * time.Millisecond)
+	close(h.release)
+
+	deadline := time.Now().Add(time.Second)
+	for h.handled.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if handled := h.handled.Load(); handled != 2 {
+		t.Fatalf("expected the worker and the queue to hold 2 queries, %d were handled", handled)
+	}
+
+	// the server recovers once the worker is free
+	other := dialUDP(t, conn)
+	if response, err := exchangeUDP(t, other, encodeQuery(t, 100, "a.example.")); err != nil || response.Header.ID != 100 {
+		t.Fatalf("expected the query to be answered, got %v", err)
+	}
+}
+
+// Shutdown stops reading but waits for the queries being handled, and their replies are sent
+func TestUDPShutdownDrains(t *testing.T) {
+	h := newBlockingHandler()
+	s := &Server{Handler: h}
+	conn, shutdown := serveUDP(t, s)
+	client := dialUDP(t, conn)
+	client.Write(encodeQuery(t, 1, "slow.example."))
+	<-h.started
+
+	served := make(chan error, 1)
+	go func() { served <- shutdown() }()
+	select {
+	case err := <-served:
+		t.Fatalf("ServeUDP returned %v with a query in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(h.release)
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("expected ErrServerClosed, got %v", err)
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, maxUDPSize)
+	size, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("expected the reply of the query in flight, got %v", err)
+	}
+	if response, err := dns.DecodeMessage(buf[:size]); err != nil || response.Header.ID != 1 {
+		t.Fatalf("unexpected reply %v", err)
+	}
+
+	if err := s.ServeUDP(conn); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("expected a closed server to refuse serving, got %v", err)
+	}
+}
+
+// every packet keeps its own buffer until its reply is sent, run with -race
+func TestUDPBuffersNotShared(t *testing.T) {
+	conn, _ := serveUDP(t, &Server{Handler: HandlerFunc(func(req *Request) (*dns.Message, error) {
+		// long enough for other packets to be read meanwhile
+		time.Sleep(time.Millisecond)
+		return answerHandler(req)
+	}), Workers: 8})
+
+	var wg sync.WaitGroup
+	for c := range 8 {
+		client := dialUDP(t, conn)
+		queries := make([][]byte, 50)
+		for i := range queries {
+			queries[i] = encodeQuery(t, uint16(c*1000+i), fmt.Sprintf("q%d-%d.example.", c, i))
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, query := range queries {
+				id := uint16(c*1000 + i)
+				name := fmt.Sprintf("q%d-%d.example.", c, i)
+				response, err := exchangeUDP(t, client, query)
+				if err != nil {
+					t.Errorf("query %s failed, cause: %s", name, err)
+					return
+				}
+				if response.Header.ID != id || response.Questions[0].QNAME != name || response.Answers[0].NAME != name {
+					t.Errorf("query %d for %s got the reply %d for %s", id, name, response.Header.ID, response.Answers[0].NAME)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// a whole query and response over loopback: reading, the worker pool, decoding, the handler and encoding
+func BenchmarkServeUDP(b *testing.B) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	s := &Server{Handler: HandlerFunc(answerHandler)}
+	errs := make(chan error, 1)
+	go func() { errs <- s.ServeUDP(conn) }()
+	defer func() {
+		s.Shutdown(context.Background())
+		<-errs
+	}()
+
+	query := &dns.Message{
+		Header:    dns.Header{ID: 1, RD: true},
+		Questions: []*dns.Question{{QNAME: "www.example.com.", QTYPE: "A", QCLASS: "IN"}},
+		EDNS:      &dns.EDNS{UDPSIZE: 1232},
+	}
+	payload, err := query.EncodeMessage()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	// every goroutine is a client with one query in flight
+	b.RunParallel(func(pb *testing.PB) {
+		client, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		defer client.Close()
+		buf := make([]byte, 1232)
+		for pb.Next() {
+			if _, err := client.Write(payload); err != nil {
+				b.Error(err)
+				return
+			}
+			client.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := client.Read(buf); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package tsig
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+// HMAC algorithms, by their name in the TSIG record (RFC 8945 section 6)
+const (
+	HmacSHA256 = "hmac-sha256."
+	HmacSHA384 = "hmac-sha384."
+	HmacSHA512 = "hmac-sha512."
+)
+
+var algorithms = map[string]func() hash.Hash{
+	HmacSHA256: sha256.New,
+	HmacSHA384: sha512.New384,
+	HmacSHA512: sha512.New,
+}
+
+// Key is a secret shared with another server, both sides know it by the same name
+type Key struct {
+	// lower case and fully qualified
+	Name string
+	// one of the Hmac constants
+	Algorithm string
+	Secret    []byte
+}
+
+// Keyring holds the known keys by name
+type Keyring map[string]*Key
+
+func (k Keyring) Get(name string) (*Key, bool) {
+	key, ok := k[strings.ToLower(dns.Fqdn(name))]
+	return key, ok
+}
+
+// LoadKeyring reads the keys of a file, see { ParseKeyring }
+func LoadKeyring(path string) (Keyring, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the keys file, cause: %w", err)
+	}
+	defer file.Close()
+	return ParseKeyring(file, path)
+}
+
+// ParseKeyring reads one key per line as: name algorithm secret, the secret in base64
+// as printed by tsig-keygen. Blank lines and lines starting with # are skipped, e.g.
+//
+//	transfer.example.com. hmac-sha256 4Wt8Bo0jIz3rVzVc9uVbUXkBlJ7xq1GgkF0kqy0AvgY=
+func ParseKeyring(r io.Reader, name string) (Keyring, error) {
+	keyring := Keyring{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected name algorithm secret, got %d fields", name, line, len(fields))
+		}
+
+		algorithm := strings.ToLower(dns.Fqdn(fields[1]))
+		if _, ok := algorithms[algorithm]; !ok {
+			return nil, fmt.Errorf("%s:%d: unsupported algorithm %s", name, line, fields[1])
+		}
+		secret, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid secret, cause: %w", name, line, err)
+		}
+		key := &Key{Name: strings.ToLower(dns.Fqdn(fields[0])), Algorithm: algorithm, Secret: secret}
+		if _, ok := keyring[key.Name]; ok {
+			return nil, fmt.Errorf("%s:%d: the key %s is defined twice", name, line, key.Name)
+		}
+		keyring[key.Name] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s, cause: %w", name, err)
+	}
+	return keyring, nil
+}
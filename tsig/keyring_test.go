@@ -0,0 +1,53 @@
+package tsig
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseKeyring(t *testing.T) {
+	content := `# transfers and updates
+transfer.example.com. hmac-sha256 NoTCJU+DMqFWywaPyxSijrDEA/eC3nK0xi3AMEZuPVk=
+
+Update.Example.COM HMAC-SHA512. 4Wt8Bo0jIz3rVzVc9uVbUXkBlJ7xq1GgkF0kqy0AvgY=
+`
+	keyring, err := ParseKeyring(strings.NewReader(content), "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keyring) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keyring))
+	}
+	key, ok := keyring.Get("update.example.com")
+	if !ok {
+		t.Fatal("expected the key to be found by its name in any case")
+	}
+	if key.Name != "update.example.com." || key.Algorithm != HmacSHA512 || len(key.Secret) != 32 {
+		t.Fatalf("unexpected key %s %s with %d octets of secret", key.Name, key.Algorithm, len(key.Secret))
+	}
+}
+
+func TestParseKeyringErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		message string
+	}{
+		{"bad base64", "a.example. hmac-sha256 not*base64\n", "keys:1: invalid secret"},
+		{"unknown algorithm", "a.example. hmac-md5 NoTCJU+DMqFWywaPyxSijrDEA/eC3nK0xi3AMEZuPVk=\n", "keys:1: unsupported algorithm hmac-md5"},
+		{
+			"duplicate name",
+			"a.example. hmac-sha256 NoTCJU+DMqFWywaPyxSijrDEA/eC3nK0xi3AMEZuPVk=\nA.EXAMPLE hmac-sha512 NoTCJU+DMqFWywaPyxSijrDEA/eC3nK0xi3AMEZuPVk=\n",
+			"keys:2: the key a.example. is defined twice",
+		},
+		{"missing secret", "\na.example. hmac-sha256\n", "keys:2: expected name algorithm secret"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseKeyring(strings.NewReader(test.content), "keys")
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Fatalf("expected an error with %q, got %v", test.message, err)
+			}
+		})
+	}
+}
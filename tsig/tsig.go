@@ -0,0 +1,219 @@
+package tsig
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+const (
+	// seconds of clock difference allowed between the two sides (RFC 8945 section 10)
+	DefaultFudge = 300
+	// messages of a zone transfer that may follow each other without a TSIG (RFC 8945 section 5.3.1)
+	maxUnsigned = 99
+)
+
+var (
+	ErrBadKey  = errors.New("unknown TSIG key or algorithm")
+	ErrBadSig  = errors.New("the TSIG MAC doesn't match")
+	ErrBadTime = errors.New("the TSIG time is outside the fudge")
+	// ErrUnsigned is returned when a message that must be signed has no TSIG record
+	ErrUnsigned = errors.New("the message is not signed")
+)
+
+// Session signs and verifies the messages of a single exchange (RFC 8945 section 5): a request
+// and its responses, where each MAC covers the previous one. Zone transfers answer with many
+// messages, the ones after the first only cover the time of the TSIG (section 5.3.1)
+type Session struct {
+	// nil when the request was signed with an unknown key
+	Key *Key
+	// of the request, sent back even when the key is unknown
+	name      string
+	algorithm string
+	// TSIG error of the responses, see { Accept }
+	err         uint16
+	requestTime uint64
+	// MAC of the last signed message and the messages signed or verified so far
+	mac      []byte
+	messages int
+	// unsigned messages since the last signed one, the next MAC covers them
+	pending  []byte
+	unsigned int
+}
+
+// NewSession starts an exchange signed with the key, the first message signed is the request
+func NewSession(key *Key) *Session {
+	return &Session{Key: key, name: key.Name, algorithm: key.Algorithm}
+}
+
+// Accept verifies a signed request (RFC 8945 section 5.2), the returned session signs its responses.
+// With ErrBadKey, ErrBadSig and ErrBadTime the session is returned too, the responses then report
+// the error to the client. Any other error is a malformed TSIG record, answered with FORMERR
+func (k Keyring) Accept(wire []byte, now time.Time) (*Session, error) {
+	unsigned, record, ok, err := dns.SplitTSIG(wire)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrUnsigned
+	}
+	t := record.RDATA.(*dns.TSIG)
+	s := &Session{name: strings.ToLower(dns.Fqdn(record.NAME)), algorithm: t.ALGORITHM, requestTime: t.TIMESIGNED}
+
+	key, ok := k.Get(record.NAME)
+	if !ok || !strings.EqualFold(dns.Fqdn(t.ALGORITHM), key.Algorithm) {
+		s.err = dns.RcodeBadKey
+		return s, fmt.Errorf("%w: %s %s", ErrBadKey, record.NAME, t.ALGORITHM)
+	}
+	s.Key = key
+	if err := s.verify(unsigned, record, now); err != nil {
+		if errors.Is(err, ErrBadTime) {
+			s.err = dns.RcodeBadTime
+		} else {
+			s.err = dns.RcodeBadSig
+		}
+		return s, err
+	}
+	return s, nil
+}
+
+// Sign appends the TSIG record to the encoded message, which is the request for sessions from
+// NewSession and a response for sessions from Accept
+func (s *Session) Sign(wire []byte, now time.Time) ([]byte, error) {
+	if len(wire) < 12 {
+		return nil, dns.ErrTruncated
+	}
+	t := &dns.TSIG{
+		ALGORITHM:  s.algorithm,
+		TIMESIGNED: uint64(now.Unix()),
+		FUDGE:      DefaultFudge,
+		ORIGINALID: binary.BigEndian.Uint16(wire),
+		ERROR:      s.err,
+	}
+	record := dns.Answer{NAME: s.name, TYPE: "TSIG", CLASS: "ANY", RDATA: t}
+
+	switch s.err {
+	case dns.RcodeBadKey, dns.RcodeBadSig:
+		// the client couldn't trust a MAC made without the right key, it's left empty (RFC 8945 section 5.3.2)
+		return dns.AppendTSIG(wire, record)
+	case dns.RcodeBadTime:
+		t.TIMESIGNED = s.requestTime
+		t.OTHERDATA = binary.BigEndian.AppendUint16(nil, uint16(now.Unix()>>32))
+		t.OTHERDATA = binary.BigEndian.AppendUint32(t.OTHERDATA, uint32(now.Unix()))
+	}
+
+	variables, err := dns.TSIGVariables(record, s.messages > 1)
+	if err != nil {
+		return nil, err
+	}
+	t.MAC = s.Key.mac(s.previous(), wire, variables)
+	s.mac = t.MAC
+	s.messages++
+	return dns.AppendTSIG(wire, record)
+}
+
+// Verify checks a response to the request of the session, returns false for the unsigned messages
+// a zone transfer may have between signed ones, the next signed message covers them. The first
+// response must be signed and so must be the last one, which the caller has to check
+func (s *Session) Verify(wire []byte, now time.Time) (bool, error) {
+	unsigned, record, ok, err := dns.SplitTSIG(wire)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		if s.messages < 2 || s.unsigned >= maxUnsigned {
+			return false, ErrUnsigned
+		}
+		s.pending = append(s.pending, wire...)
+		s.unsigned++
+		return false, nil
+	}
+
+	t := record.RDATA.(*dns.TSIG)
+	if t.ERROR != dns.RcodeNoError && len(t.MAC) == 0 {
+		return true, fmt.Errorf("the server rejected the TSIG, cause: %w", errorOf(t.ERROR))
+	}
+	if err := s.verify(unsigned, record, now); err != nil {
+		return true, err
+	}
+	if t.ERROR != dns.RcodeNoError {
+		return true, fmt.Errorf("the server rejected the TSIG, cause: %w", errorOf(t.ERROR))
+	}
+	return true, nil
+}
+
+// Size is an upper bound of the TSIG record Sign appends, the room to leave in the messages
+func (s *Session) Size() int {
+	macSize := 0
+	if s.Key != nil {
+		macSize = algorithms[s.Key.Algorithm]().Size()
+	}
+	// name, fixed part of the record, algorithm, fixed part of the RDATA and the server time of BADTIME
+	return len(dns.Fqdn(s.name)) + 1 + 10 + len(dns.Fqdn(s.algorithm)) + 1 + 16 + macSize + 6
+}
+
+// verify checks the MAC and then the time of a signed message, the ID is set back to the one it
+// had when it was signed
+func (s *Session) verify(unsigned []byte, record dns.Answer, now time.Time) error {
+	t := record.RDATA.(*dns.TSIG)
+	if !dns.EqualNames(record.NAME, s.Key.Name) || !strings.EqualFold(dns.Fqdn(t.ALGORITHM), s.Key.Algorithm) {
+		return fmt.Errorf("%w: %s %s", ErrBadKey, record.NAME, t.ALGORITHM)
+	}
+	binary.BigEndian.PutUint16(unsigned, t.ORIGINALID)
+
+	variables, err := dns.TSIGVariables(record, s.messages > 1)
+	if err != nil {
+		return err
+	}
+	expected := s.Key.mac(s.previous(), append(s.pending, unsigned...), variables)
+	if !hmac.Equal(expected, t.MAC) {
+		return ErrBadSig
+	}
+	s.mac = t.MAC
+	s.messages++
+	s.pending = nil
+	s.unsigned = 0
+
+	if drift := now.Unix() - int64(t.TIMESIGNED); drift > int64(t.FUDGE) || -drift > int64(t.FUDGE) {
+		return fmt.Errorf("%w: signed %ds away", ErrBadTime, drift)
+	}
+	return nil
+}
+
+// previous returns the MAC the next message has to cover, nil for the request
+func (s *Session) previous() []byte {
+	if s.messages == 0 {
+		return nil
+	}
+	return s.mac
+}
+
+// mac computes the HMAC of the previous MAC (prefixed by its size), the message and the TSIG variables
+func (k *Key) mac(previous []byte, message []byte, variables []byte) []byte {
+	h := hmac.New(algorithms[k.Algorithm], k.Secret)
+	if previous != nil {
+		h.Write(binary.BigEndian.AppendUint16(nil, uint16(len(previous))))
+		h.Write(previous)
+	}
+	h.Write(message)
+	h.Write(variables)
+	return h.Sum(nil)
+}
+
+func errorOf(rcode uint16) error {
+	switch rcode {
+	case dns.RcodeBadKey:
+		return ErrBadKey
+	case dns.RcodeBadSig:
+		return ErrBadSig
+	case dns.RcodeBadTime:
+		return ErrBadTime
+	default:
+		return fmt.Errorf("TSIG error %d", rcode)
+	}
+}
@@ -0,0 +1,350 @@
+package tsig
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+// the time of the vectors, see { TestSignInteroperates }
+var signed = time.Unix(1594855491, 0)
+
+func newKey(t *testing.T, name string, algorithm string, secret string) *Key {
+	t.Helper()
+	decoded, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Key{Name: name, Algorithm: algorithm, Secret: decoded}
+}
+
+func transferKey(t *testing.T) *Key {
+	return newKey(t, "transfer.example.com.", HmacSHA256, "NoTCJU+DMqFWywaPyxSijrDEA/eC3nK0xi3AMEZuPVk=")
+}
+
+func encode(t *testing.T, message *dns.Message) []byte {
+	t.Helper()
+	wire, err := message.EncodeMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wire
+}
+
+func axfrQuery(t *testing.T) []byte {
+	return encode(t, &dns.Message{
+		Header:    dns.Header{ID: 0x1234},
+		Questions: []*dns.Question{{QNAME: "example.com.", QTYPE: "AXFR", QCLASS: "IN"}},
+	})
+}
+
+func response(t *testing.T, serial uint32) []byte {
+	return encode(t, &dns.Message{
+		Header:    dns.Header{ID: 0x1234, QR: true, AA: true},
+		Questions: []*dns.Question{{QNAME: "example.com.", QTYPE: "AXFR", QCLASS: "IN"}},
+		Answers: []dns.Answer{{
+			NAME: "example.com.", TYPE: "SOA", CLASS: "IN", TTL: 3600,
+			RDATA: &dns.SOA{MNAME: "ns.example.com.", RNAME: "hostmaster.example.com.", SERIAL: serial},
+		}},
+	})
+}
+
+func tsigOf(t *testing.T, wire []byte) *dns.TSIG {
+	t.Helper()
+	_, record, ok, err := dns.SplitTSIG(wire)
+	if err != nil || !ok {
+		t.Fatalf("expected a TSIG record, got %t and %v", ok, err)
+	}
+	return record.RDATA.(*dns.TSIG)
+}
+
+// the signed messages were generated by github.com/miekg/dns (TsigGenerate), the same
+// AXFR query signed with each algorithm at the same time
+func TestSignInteroperates(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		secret    string
+		signed    string
+	}{
+		{
+			HmacSHA256, "NoTCJU+DMqFWywaPyxSijrDEA/eC3nK0xi3AMEZuPVk=",
+			"123400000001000000000001076578616d706c6503636f6d0000fc0001087472616e73666572076578616d706c6503636f6d00" +
+				"00fa00ff00000000003d0b686d61632d7368613235360000005f0f9043012c0020" +
+				"25a315bc14915e43493444a6acd71613c2cca32bc487e4b25c0e14c0a2c23d45" +
+				"123400000000",
+		},
+		{
+			HmacSHA384, "Qjer2TL2lAdpq9w6Gjs98/ClCQx/L3vtgVHCmrZ8l/oKEPjqUUMFO18gMCRwd5H4",
+			"123400000001000000000001076578616d706c6503636f6d0000fc0001087472616e73666572076578616d706c6503636f6d00" +
+				"00fa00ff00000000004d0b686d61632d7368613338340000005f0f9043012c0030" +
+				"cc02d408cc49b2359cd0bd7139f74e9103f3449fb156b74f2f6bc22613186f857ad2cb0e07decffc30f05795f1d63f49" +
+				"123400000000",
+		},
+		{
+			HmacSHA512, "4Wt8Bo0jIz3rVzVc9uVbUXkBlJ7xq1GgkF0kqy0AvgY=",
+			"123400000001000000000001076578616d706c6503636f6d0000fc0001087472616e73666572076578616d706c6503636f6d00" +
+				"00fa00ff00000000005d0b686d61632d7368613531320000005f0f9043012c0040" +
+				"993582de8ed585065e88751ddee0583e2341e331e8eab27be831bbd3a9c7655d6da5026708049b449be42713939cbe54ca7fbae2885978b6a45c6aa53b875e27" +
+				"123400000000",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.algorithm, func(t *testing.T) {
+			key := newKey(t, "transfer.example.com.", test.algorithm, test.secret)
+			expected, err := hex.DecodeString(test.signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wire, err := NewSession(key).Sign(axfrQuery(t), signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(wire, expected) {
+				t.Fatalf("expected\n%x\ngot\n%x", expected, wire)
+			}
+			if _, err := (Keyring{key.Name: key}).Accept(expected, signed); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// an UPDATE signed by github.com/miekg/dns, from its own tests (tsig_test.go, wireMsg)
+func TestAcceptPublishedVector(t *testing.T) {
+	wire, err := hex.DecodeString("c60028000001000000010001076578616d706c6503636f6d00000600010161c00c0001000100000e100004c0000201" +
+		"07746573746b65790000fa00ff00000000003d0b686d61632d73686132353600" +
+		"00005f0f9043" +
+		"012c00208cf23e0081d915478a182edcea7ff48ad102948e6c7ef8e887536957d1fa5616c60000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := newKey(t, "testkey.", HmacSHA256, "NoTCJU+DMqFWywaPyxSijrDEA/eC3nK0xi3AMEZuPVk=")
+	keyring := Keyring{key.Name: key}
+
+	if _, err := keyring.Accept(bytes.Clone(wire), signed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyring.Accept(bytes.Clone(wire), signed.Add(301*time.Second)); !errors.Is(err, ErrBadTime) {
+		t.Fatalf("expected %v past the fudge, got %v", ErrBadTime, err)
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		secret    string
+	}{
+		{HmacSHA256, "NoTCJU+DMqFWywaPyxSijrDEA/eC3nK0xi3AMEZuPVk="},
+		{HmacSHA384, "Qjer2TL2lAdpq9w6Gjs98/ClCQx/L3vtgVHCmrZ8l/oKEPjqUUMFO18gMCRwd5H4"},
+		{HmacSHA512, "4Wt8Bo0jIz3rVzVc9uVbUXkBlJ7xq1GgkF0kqy0AvgY="},
+	}
+	for _, test := range tests {
+		t.Run(test.algorithm, func(t *testing.T) {
+			key := newKey(t, "transfer.example.com.", test.algorithm, test.secret)
+			client := NewSession(key)
+			request, err := client.Sign(axfrQuery(t), signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			server, err := Keyring{key.Name: key}.Accept(request, signed.Add(time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+			wire, err := server.Sign(response(t, 1), signed.Add(time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := len(wire) - len(response(t, 1)); size > server.Size() {
+				t.Fatalf("the TSIG record takes %d octets, Size says %d", size, server.Size())
+			}
+
+			ok, err := client.Verify(wire, signed.Add(2*time.Second))
+			if err != nil || !ok {
+				t.Fatalf("expected a verified response, got %t and %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestAcceptErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		// changes the signed request
+		tamper func(wire []byte)
+		now    time.Time
+		err    error
+		rcode  uint16
+	}{
+		{
+			// the MAC is followed by the original ID, the error and the other data size
+			"flipped MAC byte", func(wire []byte) { wire[len(wire)-7] ^= 0x01 }, signed, ErrBadSig, dns.RcodeBadSig,
+		},
+		{
+			"unknown key", func(wire []byte) {
+				// the key name of the TSIG starts right after the question
+				offset := bytes.Index(wire, []byte("\x08transfer"))
+				copy(wire[offset+1:], "unknown!")
+			}, signed, ErrBadKey, dns.RcodeBadKey,
+		},
+		{"signed past the fudge", func([]byte) {}, signed.Add((DefaultFudge + 1) * time.Second), ErrBadTime, dns.RcodeBadTime},
+		{"signed before the fudge", func([]byte) {}, signed.Add(-(DefaultFudge + 1) * time.Second), ErrBadTime, dns.RcodeBadTime},
+		{"at the edge of the fudge", func([]byte) {}, signed.Add(DefaultFudge * time.Second), nil, dns.RcodeNoError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key := transferKey(t)
+			client := NewSession(key)
+			request, err := client.Sign(axfrQuery(t), signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.tamper(request)
+
+			server, err := Keyring{key.Name: key}.Accept(request, test.now)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			wire, err := server.Sign(response(t, 1), test.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			record := tsigOf(t, wire)
+			if record.ERROR != test.rcode {
+				t.Fatalf("expected the TSIG error %d, got %d", test.rcode, record.ERROR)
+			}
+
+			switch test.rcode {
+			case dns.RcodeBadKey, dns.RcodeBadSig:
+				// the MAC can't be trusted without the right key (RFC 8945 section 5.3.2)
+				if len(record.MAC) != 0 {
+					t.Fatalf("expected an empty MAC, got %x", record.MAC)
+				}
+			case dns.RcodeBadTime:
+				// signed with the time of the request, the server time in the other data (RFC 8945 section 5.2.3)
+				serverTime := binary.BigEndian.AppendUint16(nil, uint16(test.now.Unix()>>32))
+				serverTime = binary.BigEndian.AppendUint32(serverTime, uint32(test.now.Unix()))
+				if record.TIMESIGNED != uint64(signed.Unix()) || !bytes.Equal(record.OTHERDATA, serverTime) {
+					t.Fatalf("expected the request time and the server time %x, got %d and %x", serverTime, record.TIMESIGNED, record.OTHERDATA)
+				}
+			}
+
+			// the client learns why, even from a response without a MAC
+			if _, err := client.Verify(wire, signed); !errors.Is(err, test.err) {
+				t.Fatalf("expected the client to get %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+// signTimers signs the messages the way the ones following the first of a zone transfer are:
+// the previous MAC, the messages since and only the timers of the TSIG (RFC 8945 section 5.3.1)
+func signTimers(t *testing.T, key *Key, previous []byte, messages []byte, wire []byte, now time.Time) ([]byte, []byte) {
+	h := hmac.New(algorithms[key.Algorithm], key.Secret)
+	h.Write(binary.BigEndian.AppendUint16(nil, uint16(len(previous))))
+	h.Write(previous)
+	h.Write(messages)
+	h.Write(binary.BigEndian.AppendUint16(nil, uint16(now.Unix()>>32)))
+	h.Write(binary.BigEndian.AppendUint32(nil, uint32(now.Unix())))
+	h.Write(binary.BigEndian.AppendUint16(nil, DefaultFudge))
+	mac := h.Sum(nil)
+
+	signedWire, err := dns.AppendTSIG(wire, dns.Answer{NAME: key.Name, TYPE: "TSIG", CLASS: "ANY", RDATA: &dns.TSIG{
+		ALGORITHM:  key.Algorithm,
+		TIMESIGNED: uint64(now.Unix()),
+		FUDGE:      DefaultFudge,
+		MAC:        mac,
+		ORIGINALID: binary.BigEndian.Uint16(wire),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signedWire, mac
+}
+
+func TestSessionChain(t *testing.T) {
+	key := transferKey(t)
+	client := NewSession(key)
+	request, err := client.Sign(axfrQuery(t), signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := Keyring{key.Name: key}.Accept(request, signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first response covers the request MAC and every variable
+	first, err := server.Sign(response(t, 1), signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := client.Verify(first, signed); err != nil || !ok {
+		t.Fatalf("expected the first response to verify, got %t and %v", ok, err)
+	}
+
+	// the following ones chain on the previous MAC with the timers only
+	previous := tsigOf(t, first).MAC
+	for serial := uint32(2); serial <= 3; serial++ {
+		now := signed.Add(time.Duration(serial) * time.Second)
+		wire, err := server.Sign(response(t, serial), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, expected := signTimers(t, key, previous, response(t, serial), response(t, serial), now)
+		if mac := tsigOf(t, wire).MAC; !bytes.Equal(mac, expected) {
+			t.Fatalf("message %d: expected the MAC %x, got %x", serial, expected, mac)
+		}
+		if ok, err := client.Verify(wire, now); err != nil || !ok {
+			t.Fatalf("message %d: expected it to verify, got %t and %v", serial, ok, err)
+		}
+		previous = expected
+	}
+
+}
+
+// a zone transfer may leave messages unsigned, the next signed one covers them
+func TestSessionChainCoversUnsigned(t *testing.T) {
+	key := transferKey(t)
+	client := NewSession(key)
+	request, _ := client.Sign(axfrQuery(t), signed)
+	server, err := Keyring{key.Name: key}.Accept(request, signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := server.Sign(response(t, 1), signed)
+	if _, err := client.Verify(first, signed); err != nil {
+		t.Fatal(err)
+	}
+
+	second, _ := server.Sign(response(t, 2), signed)
+	if _, err := client.Verify(second, signed); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := client.Verify(response(t, 3), signed); err != nil || ok {
+		t.Fatalf("expected the unsigned message to be accepted for now, got %t and %v", ok, err)
+	}
+	wire, _ := signTimers(t, key, tsigOf(t, second).MAC, append(response(t, 3), response(t, 4)...), response(t, 4), signed)
+	if ok, err := client.Verify(wire, signed); err != nil || !ok {
+		t.Fatalf("expected the MAC over both messages to verify, got %t and %v", ok, err)
+	}
+}
+
+// the first response must be signed
+func TestVerifyUnsignedFirstResponse(t *testing.T) {
+	client := NewSession(transferKey(t))
+	if _, err := client.Sign(axfrQuery(t), signed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Verify(response(t, 1), signed); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("expected %v, got %v", ErrUnsigned, err)
+	}
+}
@@ -0,0 +1,80 @@
+package zone
+
+import (
+	"net/netip"
+	"slices"
+
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/server"
+)
+
+// Handler answers authoritatively from the zones of the Store. Queries for names outside
+// every zone go to Next, or are REFUSED when there is no Next
+type Handler struct {
+	Store *Store
+	Next  server.Handler
+	// clients allowed to transfer the zones, nobody by default
+	TransferACL []netip.Prefix
+	// TSIG keys allowed to transfer the zones, from any address
+	TransferKeys []string
+	// clients allowed to change the zones with UPDATE, nobody by default
+	UpdateACL []netip.Prefix
+	// TSIG keys allowed to change the zones with UPDATE, from any address
+	UpdateKeys []string
+}
+
+func (h *Handler) ServeDNS(req *server.Request) (*dns.Message, error) {
+	if req.Message.Header.OPCODE == dns.OpcodeUpdate {
+		return h.serveUpdate(req), nil
+	}
+	if req.Message.Header.OPCODE != dns.OpcodeQuery {
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeNotImp}}, nil
+	}
+	if len(req.Message.Questions) != 1 {
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeFormErr}}, nil
+	}
+
+	question := req.Message.Questions[0]
+	z, ok := h.Store.Find(question.QNAME)
+	if !ok || z.Class != question.QCLASS {
+		if h.Next != nil {
+			return h.Next.ServeDNS(req)
+		}
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeRefused}}, nil
+	}
+	if z.Expired() {
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeServFail}}, nil
+	}
+
+	// zone transfers are only served over TCP (RFC 5936 section 4.2), see { ServeDNSStream }.
+	// FORMERR like other servers, NOTIMP would tell the client the OPCODE isn't supported
+	if question.QTYPE == "AXFR" {
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeFormErr}}, nil
+	}
+	if question.QTYPE == "IXFR" {
+		if !dns.EqualNames(question.QNAME, z.Origin) {
+			return &dns.Message{Header: dns.Header{RCODE: dns.RcodeNotAuth}}, nil
+		}
+		return h.serveIXFR(req, z), nil
+	}
+
+	return z.Lookup(question.QNAME, question.QTYPE), nil
+}
+
+// allowed checks the query was signed with one of the keys or comes from an address in the ACL
+func allowed(acl []netip.Prefix, keys []string, req *server.Request) bool {
+	if req.Key != "" && slices.ContainsFunc(keys, func(key string) bool { return dns.EqualNames(key, req.Key) }) {
+		return true
+	}
+	addrPort, err := netip.ParseAddrPort(req.RemoteAddr.String())
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range acl {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package zone
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+// diffs kept by each zone, older ones are dropped and the clients behind them get a full transfer
+const maxJournal = 256
+
+// ErrSerialMismatch is returned when a Diff doesn't start at the serial the zone is at
+var ErrSerialMismatch = errors.New("the diff doesn't start at the zone serial")
+
+// Diff is the change from one version of a zone to the next (RFC 1995 section 4),
+// the SOA records are the versions and are not part of Deleted and Added
+type Diff struct {
+	From    dns.Answer
+	To      dns.Answer
+	Deleted []dns.Answer
+	Added   []dns.Answer
+}
+
+func serial(soa dns.Answer) uint32 {
+	if rdata, ok := soa.RDATA.(*dns.SOA); ok {
+		return rdata.SERIAL
+	}
+	return 0
+}
+
+// Serial returns the SERIAL of the zone SOA, false when the zone has none yet
+func (z *Zone) Serial() (uint32, bool) {
+	soa, ok := z.SOA()
+	return serial(soa), ok
+}
+
+// Apply changes the zone with each diff in order, as a single step: either every change is made
+// or the zone is left untouched, and lookups never see the zone half way. The diffs are recorded
+// in the journal to serve IXFR
+func (z *Zone) Apply(diffs ...Diff) error {
+	z.mutex.Lock()
+	err := z.apply(diffs)
+	z.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if len(diffs) > 0 {
+		z.changed()
+	}
+	return nil
+}
+
+// the caller must hold the mutex
+func (z *Zone) apply(diffs []Diff) error {
+	var undo []func()
+	rollback := func(err error) error {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+		return err
+	}
+	insert := func(record dns.Answer) error {
+		replaced, err := z.insert(record)
+		if err != nil {
+			return err
+		}
+		undo = append(undo, func() {
+			z.remove(record)
+			for _, previous := range replaced {
+				z.insert(previous)
+			}
+		})
+		return nil
+	}
+
+	for _, diff := range diffs {
+		soa, ok := z.soa()
+		if !ok || serial(soa) != serial(diff.From) {
+			return rollback(fmt.Errorf("failed to apply the diff from serial %d to %d, cause: %w", serial(diff.From), serial(diff.To), ErrSerialMismatch))
+		}
+		for _, record := range diff.Deleted {
+			if !z.remove(record) {
+				return rollback(fmt.Errorf("failed to apply the diff to serial %d, %s is not in the zone", serial(diff.To), record))
+			}
+			undo = append(undo, func() { z.insert(record) })
+		}
+		for _, record := range diff.Added {
+			if err := insert(record); err != nil {
+				return rollback(fmt.Errorf("failed to apply the diff to serial %d, cause: %w", serial(diff.To), err))
+			}
+		}
+		if err := insert(diff.To); err != nil {
+			return rollback(fmt.Errorf("failed to apply the diff to serial %d, cause: %w", serial(diff.To), err))
+		}
+	}
+
+	z.journal = append(z.journal, diffs...)
+	if len(z.journal) > maxJournal {
+		z.journal = z.journal[len(z.journal)-maxJournal:]
+	}
+	return nil
+}
+
+// Replace makes records the new content of the zone, the difference with the current content
+// is applied as a Diff so it's journaled. When the serial doesn't move forward the history
+// can't describe the change and the journal is cleared
+func (z *Zone) Replace(records []dns.Answer) error {
+	next := New(z.Origin, z.Class)
+	for _, record := range records {
+		if err := next.Insert(record); err != nil {
+			return err
+		}
+	}
+	to, ok := next.SOA()
+	if !ok {
+		return fmt.Errorf("failed to replace the zone %s, the new content has no SOA", z.Origin)
+	}
+
+	z.mutex.Lock()
+	from, hasSOA := z.soa()
+	if !hasSOA || dns.CompareSerial(serial(to), serial(from)) <= 0 {
+		z.root = next.root
+		z.journal = nil
+		z.mutex.Unlock()
+		if serial(to) != serial(from) {
+			z.changed()
+		}
+		return nil
+	}
+	diff := Diff{From: from, To: to}
+	current := z.records()
+	z.mutex.Unlock()
+
+	diff.Deleted, diff.Added = difference(current[1:], next.Records()[1:])
+	return z.Apply(diff)
+}
+
+// difference returns the records of before missing from after and the records of after missing
+// from before. records are compared by their presentation, so a TTL change is a deletion and an
+// addition too
+func difference(before []dns.Answer, after []dns.Answer) ([]dns.Answer, []dns.Answer) {
+	key := func(record dns.Answer) string {
+		record.NAME = strings.ToLower(dns.Fqdn(record.NAME))
+		return record.String()
+	}
+	inBefore := map[string]bool{}
+	for _, record := range before {
+		inBefore[key(record)] = true
+	}
+	var deleted, added []dns.Answer
+	inAfter := map[string]bool{}
+	for _, record := range after {
+		inAfter[key(record)] = true
+		if !inBefore[key(record)] {
+			added = append(added, record)
+		}
+	}
+	for _, record := range before {
+		if !inAfter[key(record)] {
+			deleted = append(deleted, record)
+		}
+	}
+	return deleted, added
+}
+
+// changes returns the journaled diffs going from serial to the current version,
+// false when the journal doesn't reach that far back. the caller must hold the mutex
+func (z *Zone) changes(from uint32) ([]Diff, bool) {
+	for i, diff := range z.journal {
+		if serial(diff.From) != from {
+			continue
+		}
+		// every diff must start where the previous one ended
+		chain := z.journal[i:]
+		for j := 1; j < len(chain); j++ {
+			if serial(chain[j].From) != serial(chain[j-1].To) {
+				return nil, false
+			}
+		}
+		return chain, true
+	}
+	return nil, false
+}
+
+// ixfrRecords returns the answer of an IXFR from a client at serial (RFC 1995 section 4):
+// the current SOA alone when the client is up to date, the journaled diffs between two copies
+// of the current SOA, or the whole zone like an AXFR when the journal doesn't go back to serial
+func (z *Zone) ixfrRecords(from uint32) []dns.Answer {
+	z.mutex.RLock()
+	defer z.mutex.RUnlock()
+
+	soa, ok := z.soa()
+	if !ok {
+		return nil
+	}
+	if dns.CompareSerial(from, serial(soa)) >= 0 {
+		return []dns.Answer{soa}
+	}
+
+	diffs, ok := z.changes(from)
+	if !ok {
+		return append(z.records(), soa)
+	}
+	records := []dns.Answer{soa}
+	for _, diff := range diffs {
+		records = append(records, diff.From)
+		records = append(records, diff.Deleted...)
+		records = append(records, diff.To)
+		records = append(records, diff.Added...)
+	}
+	return append(records, soa)
+}
@@ -0,0 +1,217 @@
+package zone
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+func soaRecord(serial uint32) dns.Answer {
+	return dns.Answer{
+		NAME: "example.com.", TYPE: "SOA", CLASS: "IN", TTL: 300,
+		RDATA: &dns.SOA{MNAME: "ns.example.com.", RNAME: "hostmaster.example.com.", SERIAL: serial, REFRESH: 3600, RETRY: 600, EXPIRE: 86400, MINIMUM: 60},
+	}
+}
+
+func aRecord(name string, addr string) dns.Answer {
+	return dns.Answer{NAME: name, TYPE: "A", CLASS: "IN", TTL: 300, RDATA: &dns.A{ADDRESS: netip.MustParseAddr(addr)}}
+}
+
+// the zone of newTestZone at serial 1 with www.example.com. added in serial 2
+func journaledZone(t *testing.T) *Zone {
+	t.Helper()
+	z := newTestZone(t)
+	if err := z.Apply(Diff{From: soaRecord(1), To: soaRecord(2), Added: []dns.Answer{aRecord("www.example.com.", "192.0.2.10")}}); err != nil {
+		t.Fatal(err)
+	}
+	return z
+}
+
+func TestApplyRollback(t *testing.T) {
+	missing := aRecord("missing.example.com.", "192.0.2.99")
+	tests := []struct {
+		name  string
+		diffs []Diff
+		err   error
+	}{
+		{
+			"deleted record missing after other changes", []Diff{{
+				From: soaRecord(2), To: soaRecord(3),
+				Deleted: []dns.Answer{aRecord("www.example.com.", "192.0.2.10"), missing},
+				Added:   []dns.Answer{aRecord("new.example.com.", "192.0.2.11")},
+			}}, nil,
+		},
+		{
+			"second diff failing", []Diff{
+				{From: soaRecord(2), To: soaRecord(3), Deleted: []dns.Answer{aRecord("ns.example.com.", "192.0.2.1")}, Added: []dns.Answer{aRecord("ns.example.com.", "192.0.2.5")}},
+				{From: soaRecord(3), To: soaRecord(4), Deleted: []dns.Answer{missing}},
+			}, nil,
+		},
+		{
+			"serial mismatch", []Diff{
+				{From: soaRecord(2), To: soaRecord(3), Added: []dns.Answer{aRecord("new.example.com.", "192.0.2.11")}},
+				{From: soaRecord(5), To: soaRecord(6)},
+			}, ErrSerialMismatch,
+		},
+		{
+			"added record out of the zone", []Diff{{
+				From: soaRecord(2), To: soaRecord(3),
+				Deleted: []dns.Answer{aRecord("www.example.com.", "192.0.2.10")},
+				Added:   []dns.Answer{aRecord("www.example.net.", "192.0.2.11")},
+			}}, ErrOutOfZone,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			z := journaledZone(t)
+			records := render(z.Records())
+			journal := fmt.Sprint(z.journal)
+			changes := 0
+			z.OnChange(func(*Zone) { changes++ })
+
+			err := z.Apply(test.diffs...)
+			if err == nil || (test.err != nil && !errors.Is(err, test.err)) {
+				t.Fatalf("expected the diffs to fail with %v, got %v", test.err, err)
+			}
+			if got := render(z.Records()); got != records {
+				t.Fatalf("expected the zone to be left as it was\n%s\ngot\n%s", records, got)
+			}
+			if got := fmt.Sprint(z.journal); got != journal {
+				t.Fatalf("expected the journal to be left as it was\n%s\ngot\n%s", journal, got)
+			}
+			if changes != 0 {
+				t.Fatal("the listeners were told about a change that didn't happen")
+			}
+		})
+	}
+}
+
+func TestReplace(t *testing.T) {
+	z := journaledZone(t)
+	next := []dns.Answer{
+		soaRecord(3),
+		{NAME: "example.com.", TYPE: "NS", CLASS: "IN", TTL: 300, RDATA: &dns.NS{NSDNAME: "ns.example.com."}},
+		aRecord("ns.example.com.", "192.0.2.1"),
+		aRecord("www.example.com.", "192.0.2.20"),
+	}
+	if err := z.Replace(next); err != nil {
+		t.Fatal(err)
+	}
+	if len(z.journal) != 2 {
+		t.Fatalf("expected the replacement to be journaled, got %d diffs", len(z.journal))
+	}
+	diff := z.journal[1]
+	if got := render(diff.Deleted) + " / " + render(diff.Added); got != "www.example.com. 300 IN A 192.0.2.10 / www.example.com. 300 IN A 192.0.2.20" {
+		t.Fatalf("unexpected diff %s", got)
+	}
+
+	// a serial that doesn't move forward can't be described by the journal
+	next[0] = soaRecord(3)
+	next[3] = aRecord("www.example.com.", "192.0.2.30")
+	if err := z.Replace(next); err != nil {
+		t.Fatal(err)
+	}
+	if len(z.journal) != 0 {
+		t.Fatalf("expected the journal to be cleared, got %d diffs", len(z.journal))
+	}
+	if answers := z.Lookup("www.example.com.", "A").Answers; len(answers) != 1 || answers[0].RDATA.String() != "192.0.2.30" {
+		t.Fatalf("expected the new content, got %v", answers)
+	}
+}
+
+func TestDifference(t *testing.T) {
+	before := []dns.Answer{aRecord("a.example.com.", "192.0.2.1"), aRecord("B.example.com", "192.0.2.2"), aRecord("c.example.com.", "192.0.2.3")}
+	after := []dns.Answer{aRecord("b.example.com.", "192.0.2.2"), aRecord("c.example.com.", "192.0.2.4")}
+	changed := aRecord("a.example.com.", "192.0.2.1")
+	changed.TTL = 60
+	after = append(after, changed)
+
+	deleted, added := difference(before, after)
+	if got := render(deleted); got != "a.example.com. 300 IN A 192.0.2.1\nc.example.com. 300 IN A 192.0.2.3" {
+		t.Fatalf("unexpected deleted records\n%s", got)
+	}
+	if got := render(added); got != "c.example.com. 300 IN A 192.0.2.4\na.example.com. 60 IN A 192.0.2.1" {
+		t.Fatalf("unexpected added records\n%s", got)
+	}
+}
+
+// clients behind the oldest journaled diff get the whole zone
+func TestJournalTrimming(t *testing.T) {
+	z := newTestZone(t)
+	for serial := uint32(1); serial <= maxJournal+1; serial++ {
+		diff := Diff{From: soaRecord(serial), To: soaRecord(serial + 1), Added: []dns.Answer{aRecord(fmt.Sprintf("host-%d.example.com.", serial), "192.0.2.1")}}
+		if err := z.Apply(diff); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(z.journal) != maxJournal {
+		t.Fatalf("expected %d diffs in the journal, got %d", maxJournal, len(z.journal))
+	}
+	current := maxJournal + 2
+
+	full := z.ixfrRecords(1)
+	if expected := append(z.Records(), soaRecord(uint32(current))); render(full) != render(expected) {
+		t.Fatalf("expected the whole zone for a serial out of the journal, got %d records", len(full))
+	}
+
+	// each diff has its two SOA and one added record
+	incremental := z.ixfrRecords(2)
+	if len(incremental) != 2+3*maxJournal || serial(incremental[1]) != 2 {
+		t.Fatalf("expected the journaled diffs from serial 2, got %d records", len(incremental))
+	}
+	if upToDate := z.ixfrRecords(uint32(current)); len(upToDate) != 1 {
+		t.Fatalf("expected the lone SOA for an up to date client, got %d records", len(upToDate))
+	}
+}
+
+func TestServeIXFRSize(t *testing.T) {
+	z := newTestZone(t)
+	diff := Diff{From: soaRecord(1), To: soaRecord(2)}
+	for i := range 40 {
+		diff.Added = append(diff.Added, aRecord(fmt.Sprintf("host-%d.example.com.", i), "192.0.2.1"))
+	}
+	if err := z.Apply(diff); err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore()
+	store.Add(z)
+	h := &Handler{Store: store, TransferACL: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}}
+
+	// the current SOA, the diff with its two SOA and 40 records, the current SOA again
+	diffRecords := 44
+	tests := []struct {
+		network string
+		edns    *dns.EDNS
+		records int
+	}{
+		// over 512 octets only the SOA is sent, the client retries over TCP
+		{"udp", nil, 1},
+		{"udp", &dns.EDNS{UDPSIZE: 4096}, diffRecords},
+		{"tcp", nil, diffRecords},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s %v", test.network, test.edns != nil), func(t *testing.T) {
+			req := update("example.com.")
+			req.Network = test.network
+			req.Message = &dns.Message{
+				Questions: []*dns.Question{{QNAME: "example.com.", QTYPE: "IXFR", QCLASS: "IN"}},
+				Authority: []dns.Answer{soaRecord(1)},
+				EDNS:      test.edns,
+			}
+			response, err := h.ServeDNS(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Answers) != test.records || serial(response.Answers[0]) != 2 {
+				t.Fatalf("expected %d records starting with the SOA of serial 2, got\n%s", test.records, render(response.Answers))
+			}
+			if !strings.HasPrefix(render(response.Answers), "example.com. 300 IN SOA") {
+				t.Fatalf("expected the answer to start with the SOA, got\n%s", render(response.Answers))
+			}
+		})
+	}
+}
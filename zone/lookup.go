@@ -0,0 +1,204 @@
+package zone
+
+import (
+	"strings"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+// CNAME and DNAME records followed inside the zone before giving up
+const maxCNAMEs = 8
+
+// Lookup answers a question about a name of the zone (RFC 1034 section 4.3.2). The response is
+// authoritative unless it's a referral to a delegated child zone. Negative responses carry the
+// SOA in the authority section, with NXDOMAIN when the name doesn't exist and NOERROR with no
+// answers (NODATA) when the name exists without records of the type. CNAME and DNAME are
+// followed while their targets stay in the zone
+func (z *Zone) Lookup(qname string, qtype string) *dns.Message {
+	z.mutex.RLock()
+	defer z.mutex.RUnlock()
+
+	m := &dns.Message{Header: dns.Header{AA: true}}
+	seen := map[string]bool{}
+	for range maxCNAMEs + 1 {
+		seen[strings.ToLower(qname)] = true
+		target := z.lookup(m, qname, qtype)
+		if target == "" || seen[strings.ToLower(target)] {
+			return m
+		}
+		// the resolver chases targets in other zones
+		if !dns.IsSubdomain(target, z.Origin) {
+			return m
+		}
+		qname = target
+	}
+	return m
+}
+
+// lookup adds the records answering qname to m, returns the target to continue with when
+// an alias was found
+func (z *Zone) lookup(m *dns.Message, qname string, qtype string) string {
+	labels, ok := z.labels(qname)
+	if !ok {
+		return ""
+	}
+
+	n := z.root
+	for i := 0; ; i++ {
+		// NS records below the apex are a zone cut, the data there belongs to the child zone.
+		// DS records live on the parent side of the cut (RFC 4035 section 3.1.4.1)
+		if n != z.root {
+			if ns := n.rrsets["NS"]; len(ns) > 0 && !(i == len(labels) && qtype == "DS") {
+				z.referral(m, ns)
+				return ""
+			}
+		}
+		if i == len(labels) {
+			return z.answer(m, qname, qtype, n, false)
+		}
+		// a DNAME redirects every name below its owner (RFC 6672 section 2.3)
+		if dname := n.rrsets["DNAME"]; len(dname) > 0 {
+			return z.synthesizeCNAME(m, qname, dname[0])
+		}
+
+		child, ok := n.children[labels[i]]
+		if !ok {
+			// n is the closest encloser, qname may still match its wildcard (RFC 4592 section 3.3.1)
+			if wildcard, ok := n.children["*"]; ok {
+				return z.answer(m, qname, qtype, wildcard, true)
+			}
+			m.Header.RCODE = dns.RcodeNXDomain
+			z.negative(m)
+			return ""
+		}
+		n = child
+	}
+}
+
+// answer adds the records of the node, or the CNAME to follow, or the SOA when the node has
+// no data of the type. The owner of the records of a wildcard node becomes qname
+func (z *Zone) answer(m *dns.Message, qname string, qtype string, n *node, wildcard bool) string {
+	owned := func(rrset []dns.Answer) []dns.Answer {
+		if !wildcard {
+			return rrset
+		}
+		synthesized := make([]dns.Answer, len(rrset))
+		for i, record := range rrset {
+			record.NAME = qname
+			synthesized[i] = record
+		}
+		return synthesized
+	}
+
+	if qtype == "*" && len(n.rrsets) > 0 {
+		for _, recordType := range sortedKeys(n.rrsets) {
+			m.Answers = append(m.Answers, owned(n.rrsets[recordType])...)
+		}
+		return ""
+	}
+
+	if rrset := n.rrsets[qtype]; len(rrset) > 0 {
+		m.Answers = append(m.Answers, owned(rrset)...)
+		z.additional(m, rrset)
+		return ""
+	}
+
+	if cname := n.rrsets["CNAME"]; len(cname) > 0 {
+		m.Answers = append(m.Answers, owned(cname)...)
+		if rdata, ok := cname[0].RDATA.(*dns.CNAME); ok {
+			return dns.Fqdn(rdata.CNAME)
+		}
+		return ""
+	}
+
+	z.negative(m)
+	return ""
+}
+
+// synthesizeCNAME answers with the DNAME and a CNAME from qname to the name with the DNAME
+// owner replaced by its target (RFC 6672 section 3.1)
+func (z *Zone) synthesizeCNAME(m *dns.Message, qname string, dname dns.Answer) string {
+	m.Answers = append(m.Answers, dname)
+	rdata, ok := dname.RDATA.(*dns.DNAME)
+	if !ok {
+		return ""
+	}
+
+	// qname is always strictly below the owner, the prefix keeps its final dot, a root target adds nothing
+	prefix := dns.Fqdn(qname)[:len(dns.Fqdn(qname))-len(dns.Fqdn(dname.NAME))]
+	target := prefix + strings.TrimPrefix(dns.Fqdn(rdata.TARGET), ".")
+	// the substitution can't produce a name longer than 255 octets
+	if len(target)+1 > 255 {
+		m.Header.RCODE = dns.RcodeYXDomain
+		return ""
+	}
+
+	m.Answers = append(m.Answers, dns.Answer{
+		NAME:  qname,
+		TYPE:  "CNAME",
+		CLASS: dname.CLASS,
+		TTL:   dname.TTL,
+		RDATA: &dns.CNAME{CNAME: target},
+	})
+	return target
+}
+
+// referral points to the servers of a child zone, it's not authoritative (RFC 1034 section 4.3.2 step 3.b)
+func (z *Zone) referral(m *dns.Message, ns []dns.Answer) {
+	if len(m.Answers) == 0 {
+		m.Header.AA = false
+	}
+	m.Authority = append(m.Authority, ns...)
+	z.additional(m, ns)
+}
+
+// additional adds the addresses of the hosts named by NS, MX and SRV records when they're in the zone,
+// for NS below a zone cut these are the glue records
+func (z *Zone) additional(m *dns.Message, rrset []dns.Answer) {
+	for _, record := range rrset {
+		var host string
+		switch rdata := record.RDATA.(type) {
+		case *dns.NS:
+			host = rdata.NSDNAME
+		case *dns.MX:
+			host = rdata.EXCHANGE
+		case *dns.SRV:
+			host = rdata.TARGET
+		default:
+			continue
+		}
+
+		n := z.find(host)
+		if n == nil {
+			continue
+		}
+		for _, address := range append(n.rrsets["A"], n.rrsets["AAAA"]...) {
+			if !containsRecord(m.Additional, address) {
+				m.Additional = append(m.Additional, address)
+			}
+		}
+	}
+}
+
+// negative adds the SOA with the TTL a resolver can cache the negative response for,
+// the smaller of its TTL and MINIMUM field (RFC 2308 section 3). A SOA kept as opaque
+// RDATA (dns.Unknown) has no MINIMUM to read, its own TTL is used
+func (z *Zone) negative(m *dns.Message) {
+	soa, ok := z.soa()
+	if !ok {
+		return
+	}
+	if rdata, ok := soa.RDATA.(*dns.SOA); ok {
+		soa.TTL = min(soa.TTL, int32(min(rdata.MINIMUM, 1<<31-1)))
+	}
+	m.Authority = append(m.Authority, soa)
+}
+
+func containsRecord(records []dns.Answer, record dns.Answer) bool {
+	for _, existing := range records {
+		if dns.EqualNames(existing.NAME, record.NAME) && existing.TYPE == record.TYPE && dns.EqualRData(existing.RDATA, record.RDATA) {
+			return true
+		}
+	}
+	return false
+}
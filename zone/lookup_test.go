@@ -0,0 +1,192 @@
+package zone
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+func lookupZone(t *testing.T) *Zone {
+	t.Helper()
+	content := `$TTL 3600
+@ SOA ns hostmaster 1 3600 600 86400 60
+@ NS ns
+ns A 192.0.2.1
+www A 192.0.2.10
+alias CNAME alias2
+alias2 CNAME www
+outside CNAME www.example.net.
+loop CNAME loop
+*.wild A 192.0.2.20
+host.wild AAAA 2001:db8::1
+old DNAME new
+www.new A 192.0.2.30
+child NS ns.child
+ns.child A 192.0.2.53
+deep.child A 192.0.2.54
+`
+	records, err := Parse(strings.NewReader(content), "db.example", "example.com.", "IN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := New("example.com.", "IN")
+	for _, record := range records {
+		if err := z.Insert(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return z
+}
+
+// describeResponse renders the RCODE, AA and every section of the response
+func describeResponse(m *dns.Message) string {
+	return fmt.Sprintf("%s aa=%t\nanswer:\n%s\nauthority:\n%s\nadditional:\n%s",
+		dns.RcodeString(m.Header.RCODE), m.Header.AA, render(m.Answers), render(m.Authority), render(m.Additional))
+}
+
+func TestLookup(t *testing.T) {
+	// the negative responses carry the SOA with its TTL capped at MINIMUM
+	negativeSOA := "example.com. 60 IN SOA ns.example.com. hostmaster.example.com. 1 3600 600 86400 60"
+
+	tests := []struct {
+		name     string
+		qname    string
+		qtype    string
+		response string
+	}{
+		{
+			"answer", "www.example.com.", "A",
+			"NOERROR aa=true\nanswer:\nwww.example.com. 3600 IN A 192.0.2.10\nauthority:\n\nadditional:\n",
+		},
+		{
+			"NXDOMAIN", "missing.example.com.", "A",
+			"NXDOMAIN aa=true\nanswer:\n\nauthority:\n" + negativeSOA + "\nadditional:\n",
+		},
+		{
+			"NODATA", "www.example.com.", "AAAA",
+			"NOERROR aa=true\nanswer:\n\nauthority:\n" + negativeSOA + "\nadditional:\n",
+		},
+		{
+			// empty non-terminal, it exists as the parent of www.new
+			"NODATA for an empty non-terminal", "new.example.com.", "A",
+			"NOERROR aa=true\nanswer:\n\nauthority:\n" + negativeSOA + "\nadditional:\n",
+		},
+		{
+			"name case", "WWW.Example.COM", "A",
+			"NOERROR aa=true\nanswer:\nwww.example.com. 3600 IN A 192.0.2.10\nauthority:\n\nadditional:\n",
+		},
+		{
+			"wildcard", "foo.wild.example.com.", "A",
+			"NOERROR aa=true\nanswer:\nfoo.wild.example.com. 3600 IN A 192.0.2.20\nauthority:\n\nadditional:\n",
+		},
+		{
+			"wildcard below the closest encloser", "a.b.wild.example.com.", "A",
+			"NOERROR aa=true\nanswer:\na.b.wild.example.com. 3600 IN A 192.0.2.20\nauthority:\n\nadditional:\n",
+		},
+		{
+			"wildcard NODATA", "foo.wild.example.com.", "MX",
+			"NOERROR aa=true\nanswer:\n\nauthority:\n" + negativeSOA + "\nadditional:\n",
+		},
+		{
+			// the name exists, the wildcard doesn't apply to it (RFC 4592 section 2.2.1)
+			"no synthesis for an existing name", "host.wild.example.com.", "A",
+			"NOERROR aa=true\nanswer:\n\nauthority:\n" + negativeSOA + "\nadditional:\n",
+		},
+		{
+			"CNAME chain", "alias.example.com.", "A",
+			"NOERROR aa=true\nanswer:\nalias.example.com. 3600 IN CNAME alias2.example.com.\nalias2.example.com. 3600 IN CNAME www.example.com.\nwww.example.com. 3600 IN A 192.0.2.10\nauthority:\n\nadditional:\n",
+		},
+		{
+			"CNAME queried", "alias.example.com.", "CNAME",
+			"NOERROR aa=true\nanswer:\nalias.example.com. 3600 IN CNAME alias2.example.com.\nauthority:\n\nadditional:\n",
+		},
+		{
+			// the resolver follows targets in other zones
+			"CNAME out of the zone", "outside.example.com.", "A",
+			"NOERROR aa=true\nanswer:\noutside.example.com. 3600 IN CNAME www.example.net.\nauthority:\n\nadditional:\n",
+		},
+		{
+			"CNAME loop", "loop.example.com.", "A",
+			"NOERROR aa=true\nanswer:\nloop.example.com. 3600 IN CNAME loop.example.com.\nauthority:\n\nadditional:\n",
+		},
+		{
+			"DNAME", "www.old.example.com.", "A",
+			"NOERROR aa=true\nanswer:\nold.example.com. 3600 IN DNAME new.example.com.\nwww.old.example.com. 3600 IN CNAME www.new.example.com.\nwww.new.example.com. 3600 IN A 192.0.2.30\nauthority:\n\nadditional:\n",
+		},
+		{
+			// the DNAME owner itself isn't redirected
+			"DNAME owner", "old.example.com.", "A",
+			"NOERROR aa=true\nanswer:\n\nauthority:\n" + negativeSOA + "\nadditional:\n",
+		},
+		{
+			"referral", "www.child.example.com.", "A",
+			"NOERROR aa=false\nanswer:\n\nauthority:\nchild.example.com. 3600 IN NS ns.child.example.com.\nadditional:\nns.child.example.com. 3600 IN A 192.0.2.53",
+		},
+		{
+			"referral at the cut", "child.example.com.", "NS",
+			"NOERROR aa=false\nanswer:\n\nauthority:\nchild.example.com. 3600 IN NS ns.child.example.com.\nadditional:\nns.child.example.com. 3600 IN A 192.0.2.53",
+		},
+		{
+			// the data below the cut belongs to the child zone
+			"no data below the cut", "deep.child.example.com.", "A",
+			"NOERROR aa=false\nanswer:\n\nauthority:\nchild.example.com. 3600 IN NS ns.child.example.com.\nadditional:\nns.child.example.com. 3600 IN A 192.0.2.53",
+		},
+		{
+			"no glue as an answer", "ns.child.example.com.", "A",
+			"NOERROR aa=false\nanswer:\n\nauthority:\nchild.example.com. 3600 IN NS ns.child.example.com.\nadditional:\nns.child.example.com. 3600 IN A 192.0.2.53",
+		},
+		{
+			// DS is answered by the parent
+			"DS at the cut", "child.example.com.", "DS",
+			"NOERROR aa=true\nanswer:\n\nauthority:\n" + negativeSOA + "\nadditional:\n",
+		},
+		{
+			"NS of the apex with the addresses", "example.com.", "NS",
+			"NOERROR aa=true\nanswer:\nexample.com. 3600 IN NS ns.example.com.\nauthority:\n\nadditional:\nns.example.com. 3600 IN A 192.0.2.1",
+		},
+		{"out of the zone", "www.example.net.", "A", "NOERROR aa=true\nanswer:\n\nauthority:\n\nadditional:\n"},
+	}
+	z := lookupZone(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := describeResponse(z.Lookup(test.qname, test.qtype)); got != test.response {
+				t.Fatalf("expected\n%s\ngot\n%s", test.response, got)
+			}
+		})
+	}
+}
+
+func TestLookupNegativeTTL(t *testing.T) {
+	opaque := dns.Answer{NAME: "example.com.", TYPE: "SOA", CLASS: "IN", TTL: 120, RDATA: &dns.Unknown{DATA: []byte{1, 2, 3}}}
+	lowTTL := soaRecord(1)
+	lowTTL.TTL = 30
+
+	tests := []struct {
+		name string
+		soa  dns.Answer
+		ttl  int32
+	}{
+		{"MINIMUM lower than the TTL", soaRecord(1), 60},
+		{"TTL lower than MINIMUM", lowTTL, 30},
+		// without a MINIMUM to read the TTL is kept
+		{"opaque SOA", opaque, 120},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			z := New("example.com.", "IN")
+			if err := z.Insert(test.soa); err != nil {
+				t.Fatal(err)
+			}
+			m := z.Lookup("missing.example.com.", "A")
+			if m.Header.RCODE != dns.RcodeNXDomain || len(m.Authority) != 1 || m.Authority[0].TTL != test.ttl {
+				t.Fatalf("expected NXDOMAIN with the SOA at TTL %d, got\n%s", test.ttl, describeResponse(m))
+			}
+			// the record of the zone keeps its TTL
+			if soa, _ := z.SOA(); soa.TTL != test.soa.TTL {
+				t.Fatalf("the SOA of the zone changed to TTL %d", soa.TTL)
+			}
+		})
+	}
+}
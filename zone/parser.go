@@ -0,0 +1,532 @@
+package zone
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+const (
+	// $INCLUDE files including each other would never end
+	maxIncludeDepth = 16
+	// records a single $GENERATE may expand to, a typo in the range shouldn't exhaust the memory
+	maxGenerateRecords = 65536
+)
+
+// ParseError locates an error of a master file
+type ParseError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// entry is a logical line of a master file, parentheses may have joined several physical lines
+type entry struct {
+	tokens []string
+	// physical line where the entry starts
+	line int
+	// the line starts with a blank, the owner is the previous one
+	blankOwner bool
+}
+
+// parser holds the state carried from one entry to the next (RFC 1035 section 5.1)
+type parser struct {
+	origin string
+	class  string
+	// set by $TTL, zero when there was none
+	defaultTTL    uint32
+	hasDefaultTTL bool
+	// the last owner and TTL are used by the entries that omit them
+	lastOwner  string
+	lastTTL    uint32
+	hasLastTTL bool
+	records    []dns.Answer
+}
+
+// Load reads a master file into a new zone, the zone must have a SOA
+func Load(path string, origin string, class string) (*Zone, error) {
+	class, err := dns.ParseClass(class)
+	if err != nil {
+		return nil, err
+	}
+	records, err := ParseFile(path, origin, class)
+	if err != nil {
+		return nil, err
+	}
+	z := New(origin, class)
+	for _, record := range records {
+		if err := z.Insert(record); err != nil {
+			return nil, fmt.Errorf("failed to load %s, cause: %w", path, err)
+		}
+	}
+	if _, ok := z.SOA(); !ok {
+		return nil, fmt.Errorf("failed to load %s, the zone %s has no SOA", path, z.Origin)
+	}
+	return z, nil
+}
+
+// ParseFile reads the records of a master file (RFC 1035 section 5), relative names are completed
+// with origin until a $ORIGIN changes it. Records without a class take class
+func ParseFile(path string, origin string, class string) ([]dns.Answer, error) {
+	p, err := newParser(origin, class)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.include(path, 0); err != nil {
+		return nil, err
+	}
+	return p.records, nil
+}
+
+// Parse reads the records of a master file from r, name is only used in the errors.
+// $INCLUDE paths are relative to the working directory
+func Parse(r io.Reader, name string, origin string, class string) ([]dns.Answer, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s, cause: %w", name, err)
+	}
+	p, err := newParser(origin, class)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.parse(string(content), name, ".", 0); err != nil {
+		return nil, err
+	}
+	return p.records, nil
+}
+
+func newParser(origin string, class string) (*parser, error) {
+	canonical, err := dns.ParseClass(class)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{origin: dns.Fqdn(origin), class: canonical}, nil
+}
+
+func (p *parser) include(path string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("failed to include %s, more than %d nested $INCLUDE", path, maxIncludeDepth)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the master file, cause: %w", err)
+	}
+	return p.parse(string(content), path, filepath.Dir(path), depth)
+}
+
+func (p *parser) parse(content string, file string, dir string, depth int) error {
+	entries, err := tokenize(content)
+	if err != nil {
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) {
+			parseErr.File = file
+		}
+		return err
+	}
+
+	for _, e := range entries {
+		if err := p.entry(e, dir, depth); err != nil {
+			var parseErr *ParseError
+			if errors.As(err, &parseErr) {
+				// already located inside an included file
+				return err
+			}
+			return &ParseError{File: file, Line: e.line, Err: err}
+		}
+	}
+	return nil
+}
+
+func (p *parser) entry(e entry, dir string, depth int) error {
+	tokens := e.tokens
+	if !e.blankOwner && strings.HasPrefix(tokens[0], "$") {
+		return p.directive(tokens, dir, depth)
+	}
+
+	owner := p.lastOwner
+	if !e.blankOwner {
+		name, err := dns.ParseName(tokens[0], p.origin)
+		if err != nil {
+			return fmt.Errorf("invalid owner %s, cause: %w", tokens[0], err)
+		}
+		owner = name
+		tokens = tokens[1:]
+	}
+	if owner == "" {
+		return fmt.Errorf("the first record has no owner")
+	}
+	p.lastOwner = owner
+
+	return p.record(owner, tokens)
+}
+
+// record parses [<TTL>] [<class>] <type> <RDATA>, TTL and class may come in any order
+func (p *parser) record(owner string, tokens []string) error {
+	var ttl uint32
+	hasTTL := false
+	class := ""
+	for range 2 {
+		if len(tokens) == 0 {
+			break
+		}
+		if !hasTTL && len(tokens[0]) > 0 && tokens[0][0] >= '0' && tokens[0][0] <= '9' {
+			value, err := dns.ParseTTL(tokens[0])
+			if err != nil {
+				return err
+			}
+			ttl, hasTTL = value, true
+			tokens = tokens[1:]
+			continue
+		}
+		if parsed, err := dns.ParseClass(tokens[0]); class == "" && err == nil {
+			class = parsed
+			tokens = tokens[1:]
+		}
+	}
+	if len(tokens) == 0 {
+		return fmt.Errorf("the record of %s has no type", owner)
+	}
+
+	if class == "" {
+		class = p.class
+	}
+	if class != p.class {
+		return fmt.Errorf("the record of %s has class %s, the zone is %s", owner, class, p.class)
+	}
+
+	recordType, err := dns.ParseType(tokens[0])
+	if err != nil {
+		return err
+	}
+	rdata, err := dns.ParseRData(recordType, tokens[1:], p.origin)
+	if err != nil {
+		return err
+	}
+
+	if !hasTTL {
+		switch {
+		case p.hasDefaultTTL:
+			ttl = p.defaultTTL
+		case p.hasLastTTL:
+			ttl = p.lastTTL
+		case recordType == "SOA":
+			// without $TTL BIND uses the SOA MINIMUM
+			ttl = rdata.(*dns.SOA).MINIMUM
+		default:
+			return fmt.Errorf("the record of %s has no TTL and there is no $TTL", owner)
+		}
+	}
+	p.lastTTL, p.hasLastTTL = ttl, true
+
+	p.records = append(p.records, dns.Answer{
+		NAME:  owner,
+		TYPE:  recordType,
+		CLASS: class,
+		TTL:   int32(min(ttl, 1<<31-1)),
+		RDATA: rdata,
+	})
+	return nil
+}
+
+func (p *parser) directive(tokens []string, dir string, depth int) error {
+	switch strings.ToUpper(tokens[0]) {
+	case "$ORIGIN":
+		if len(tokens) != 2 {
+			return fmt.Errorf("expected $ORIGIN <domain-name>")
+		}
+		origin, err := dns.ParseName(tokens[1], p.origin)
+		if err != nil {
+			return err
+		}
+		p.origin = origin
+		return nil
+
+	case "$TTL":
+		if len(tokens) != 2 {
+			return fmt.Errorf("expected $TTL <ttl>")
+		}
+		ttl, err := dns.ParseTTL(tokens[1])
+		if err != nil {
+			return err
+		}
+		p.defaultTTL, p.hasDefaultTTL = ttl, true
+		return nil
+
+	case "$INCLUDE":
+		if len(tokens) != 2 && len(tokens) != 3 {
+			return fmt.Errorf("expected $INCLUDE <file-name> [<domain-name>]")
+		}
+		path := tokens[1]
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		// the origin given to the included file doesn't change the origin of the including one (RFC 1035 section 5.1)
+		origin := p.origin
+		defer func() { p.origin = origin }()
+		if len(tokens) == 3 {
+			includeOrigin, err := dns.ParseName(tokens[2], p.origin)
+			if err != nil {
+				return err
+			}
+			p.origin = includeOrigin
+		}
+		return p.include(path, depth+1)
+
+	case "$GENERATE":
+		return p.generate(tokens[1:])
+
+	default:
+		return fmt.Errorf("unknown directive %s", tokens[0])
+	}
+}
+
+// generate expands the BIND $GENERATE directive: <range> <lhs> [<ttl>] [<class>] <type> <rhs>,
+// with range as start-stop[/step]. Every $ in lhs and rhs is replaced by the iterator, ${offset,width,base}
+// adds an offset and formats it (base d, o, x, X or n/N for reversed nibbles), \$ is a literal $
+func (p *parser) generate(tokens []string) error {
+	if len(tokens) < 4 {
+		return fmt.Errorf("expected $GENERATE <range> <lhs> [<ttl>] [<class>] <type> <rhs>")
+	}
+
+	start, stop, step, err := parseRange(tokens[0])
+	if err != nil {
+		return err
+	}
+	// compared before adding one, stop-start can be the largest int
+	if (stop-start)/step >= maxGenerateRecords {
+		return fmt.Errorf("the $GENERATE range %s expands to more than %d records", tokens[0], maxGenerateRecords)
+	}
+	count := (stop-start)/step + 1
+
+	// counting the records rather than comparing with stop, i can't overflow
+	for n := range count {
+		i := start + n*step
+		expanded := make([]string, 0, len(tokens)-1)
+		for _, token := range tokens[1:] {
+			// TTL, class and type have no $ to replace
+			if token, err = expandTemplate(token, i); err != nil {
+				return err
+			}
+			expanded = append(expanded, token)
+		}
+
+		owner, err := dns.ParseName(expanded[0], p.origin)
+		if err != nil {
+			return fmt.Errorf("invalid owner %s, cause: %w", expanded[0], err)
+		}
+		if err := p.record(owner, expanded[1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseRange(field string) (int, int, int, error) {
+	bounds, stepField, hasStep := strings.Cut(field, "/")
+	startField, stopField, ok := strings.Cut(bounds, "-")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid $GENERATE range %s", field)
+	}
+	start, errStart := strconv.Atoi(startField)
+	stop, errStop := strconv.Atoi(stopField)
+	step := 1
+	var errStep error
+	if hasStep {
+		step, errStep = strconv.Atoi(stepField)
+	}
+	if errStart != nil || errStop != nil || errStep != nil || start < 0 || stop < start || step < 1 {
+		return 0, 0, 0, fmt.Errorf("invalid $GENERATE range %s", field)
+	}
+	return start, stop, step, nil
+}
+
+func expandTemplate(template string, iterator int) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if c == '\\' && i+1 < len(template) && template[i+1] == '$' {
+			sb.WriteByte('$')
+			i++
+			continue
+		}
+		if c != '$' {
+			sb.WriteByte(c)
+			continue
+		}
+
+		offset, width, base := 0, 0, "d"
+		if i+1 < len(template) && template[i+1] == '{' {
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated modifier in %s", template)
+			}
+			modifiers := strings.Split(template[i+2:i+end], ",")
+			var err error
+			if offset, err = strconv.Atoi(modifiers[0]); err != nil {
+				return "", fmt.Errorf("invalid offset in %s", template)
+			}
+			if len(modifiers) > 1 {
+				if width, err = strconv.Atoi(modifiers[1]); err != nil || width < 0 {
+					return "", fmt.Errorf("invalid width in %s", template)
+				}
+			}
+			if len(modifiers) > 2 {
+				base = modifiers[2]
+			}
+			i += end
+		}
+
+		formatted, err := formatIterator(iterator+offset, width, base)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(formatted)
+	}
+	return sb.String(), nil
+}
+
+func formatIterator(value int, width int, base string) (string, error) {
+	switch base {
+	case "d":
+		return fmt.Sprintf("%0*d", width, value), nil
+	case "o":
+		return fmt.Sprintf("%0*o", width, value), nil
+	case "x":
+		return fmt.Sprintf("%0*x", width, value), nil
+	case "X":
+		return fmt.Sprintf("%0*X", width, value), nil
+	case "n", "N":
+		// nibbles in reverse order separated by dots, as used by ip6.arpa names
+		digits := []rune(fmt.Sprintf("%0*x", width, value))
+		if base == "N" {
+			digits = []rune(strings.ToUpper(string(digits)))
+		}
+		nibbles := make([]string, len(digits))
+		for i, digit := range digits {
+			nibbles[len(digits)-1-i] = string(digit)
+		}
+		return strings.Join(nibbles, "."), nil
+	default:
+		return "", fmt.Errorf("invalid base %s", base)
+	}
+}
+
+// tokenize splits the master file into entries. Comments start with ; and go to the end of the line,
+// parentheses let an entry continue on the next lines, quoted strings keep their quotes and
+// escape sequences are kept as written for the RDATA parsers
+func tokenize(content string) ([]entry, error) {
+	var entries []entry
+	var current entry
+	var token strings.Builder
+	inToken := false
+	line := 1
+	depth := 0
+	// line of the parenthesis left open, for the error
+	openLine := 0
+	lineStart := true
+
+	flushToken := func() {
+		if inToken {
+			if len(current.tokens) == 0 {
+				current.line = line
+			}
+			current.tokens = append(current.tokens, token.String())
+			token.Reset()
+			inToken = false
+		}
+	}
+	flushEntry := func() {
+		flushToken()
+		if len(current.tokens) > 0 {
+			entries = append(entries, current)
+		}
+		current = entry{}
+	}
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+
+		if lineStart {
+			lineStart = false
+			if depth == 0 && (c == ' ' || c == '\t') {
+				current.blankOwner = true
+			}
+		}
+
+		switch {
+		case c == '\\':
+			if i+1 >= len(content) {
+				return nil, &ParseError{Line: line, Err: fmt.Errorf("dangling escape")}
+			}
+			token.WriteByte(c)
+			token.WriteByte(content[i+1])
+			inToken = true
+			if content[i+1] == '\n' {
+				line++
+			}
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(content) && content[end] != '"'; end++ {
+				if content[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(content) {
+				return nil, &ParseError{Line: line, Err: fmt.Errorf("unterminated quoted string")}
+			}
+			token.WriteString(content[i : end+1])
+			inToken = true
+			line += strings.Count(content[i:end+1], "\n")
+			i = end
+		case c == ';':
+			for i+1 < len(content) && content[i+1] != '\n' {
+				i++
+			}
+		case c == '(':
+			flushToken()
+			if depth == 0 {
+				openLine = line
+			}
+			depth++
+		case c == ')':
+			flushToken()
+			if depth == 0 {
+				return nil, &ParseError{Line: line, Err: fmt.Errorf("unbalanced closing parenthesis")}
+			}
+			depth--
+		case c == '\n':
+			flushToken()
+			if depth == 0 {
+				flushEntry()
+			}
+			line++
+			lineStart = true
+		case c == ' ' || c == '\t' || c == '\r':
+			flushToken()
+		default:
+			token.WriteByte(c)
+			inToken = true
+		}
+	}
+
+	if depth > 0 {
+		return nil, &ParseError{Line: openLine, Err: fmt.Errorf("unbalanced opening parenthesis")}
+	}
+	flushEntry()
+	return entries, nil
+}
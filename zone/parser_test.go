@@ -0,0 +1,212 @@
+package zone
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+// render writes every record as NAME TTL CLASS TYPE RDATA, one per line
+func render(records []dns.Answer) string {
+	lines := make([]string, len(records))
+	for i, record := range records {
+		lines[i] = fmt.Sprintf("%s %d %s %s %s", record.NAME, record.TTL, record.CLASS, record.TYPE, record.RDATA)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		records string
+	}{
+		{
+			"relative names and @",
+			`$TTL 300
+@ NS ns
+ns A 192.0.2.1
+www.example.com. CNAME ns
+`,
+			`example.com. 300 IN NS ns.example.com.
+ns.example.com. 300 IN A 192.0.2.1
+www.example.com. 300 IN CNAME ns.example.com.`,
+		},
+		{
+			"blank owner repeats the last one",
+			`$TTL 300
+www A 192.0.2.1
+    A 192.0.2.2
+`,
+			`www.example.com. 300 IN A 192.0.2.1
+www.example.com. 300 IN A 192.0.2.2`,
+		},
+		{
+			"$ORIGIN",
+			`$TTL 300
+$ORIGIN sub
+www A 192.0.2.1
+$ORIGIN example.net.
+www A 192.0.2.2
+@ MX 10 mail
+`,
+			`www.sub.example.com. 300 IN A 192.0.2.1
+www.example.net. 300 IN A 192.0.2.2
+example.net. 300 IN MX 10 mail.example.net.`,
+		},
+		{
+			"$TTL, explicit and last TTL",
+			`www 60 A 192.0.2.1
+www A 192.0.2.2
+$TTL 1h
+www A 192.0.2.3
+www 1d IN A 192.0.2.4
+www IN 30 A 192.0.2.5
+`,
+			`www.example.com. 60 IN A 192.0.2.1
+www.example.com. 60 IN A 192.0.2.2
+www.example.com. 3600 IN A 192.0.2.3
+www.example.com. 86400 IN A 192.0.2.4
+www.example.com. 30 IN A 192.0.2.5`,
+		},
+		{
+			"SOA MINIMUM without $TTL",
+			`@ SOA ns hostmaster 1 3600 600 86400 120
+`,
+			`example.com. 120 IN SOA ns.example.com. hostmaster.example.com. 1 3600 600 86400 120`,
+		},
+		{
+			"parentheses and comments",
+			`@ 3600 SOA ns hostmaster ( ; the SOA on several lines
+	2024010101 ; serial
+	3600 600
+	86400 60 )
+txt 60 TXT "a (quoted) ; string"
+`,
+			`example.com. 3600 IN SOA ns.example.com. hostmaster.example.com. 2024010101 3600 600 86400 60
+txt.example.com. 60 IN TXT "a (quoted) ; string"`,
+		},
+		{
+			"$GENERATE",
+			`$TTL 300
+$GENERATE 1-3 host-$ A 192.0.2.$
+$GENERATE 0-4/2 ${10,3,d} PTR host-$.example.net.
+`,
+			`host-1.example.com. 300 IN A 192.0.2.1
+host-2.example.com. 300 IN A 192.0.2.2
+host-3.example.com. 300 IN A 192.0.2.3
+010.example.com. 300 IN PTR host-0.example.net.
+012.example.com. 300 IN PTR host-2.example.net.
+014.example.com. 300 IN PTR host-4.example.net.`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records, err := Parse(strings.NewReader(test.content), "db.example", "example.com.", "IN")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := render(records); got != test.records {
+				t.Fatalf("expected\n%s\ngot\n%s", test.records, got)
+			}
+		})
+	}
+}
+
+func TestParseInclude(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"db.example": `$TTL 300
+@ NS ns
+$INCLUDE hosts sub.example.com.
+www A 192.0.2.1
+`,
+		// the origin given by $INCLUDE, restored once the file ends
+		"hosts": `mail A 192.0.2.2
+$INCLUDE more
+`,
+		"more": `@ TXT "included twice"
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := ParseFile(filepath.Join(dir, "db.example"), "example.com.", "IN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `example.com. 300 IN NS ns.example.com.
+mail.sub.example.com. 300 IN A 192.0.2.2
+sub.example.com. 300 IN TXT "included twice"
+www.example.com. 300 IN A 192.0.2.1`
+	if got := render(records); got != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestParseIncludeLoop(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db.loop")
+	if err := os.WriteFile(path, []byte("$INCLUDE db.loop\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseFile(path, "example.com.", "IN"); err == nil || !strings.Contains(err.Error(), "nested $INCLUDE") {
+		t.Fatalf("expected the nesting to be limited, got %v", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		line    int
+		message string
+	}{
+		{"unknown directive", "$TTL 300\n$FOO bar\n", 2, "unknown directive"},
+		{"no TTL", "www A 192.0.2.1\n", 1, "no TTL"},
+		{"no type", "$TTL 300\nwww IN\n", 2, "no type"},
+		{"another class", "$TTL 300\nwww CH A 192.0.2.1\n", 2, "class CH"},
+		{"bad RDATA", "$TTL 300\n\nwww A 192.0.2\n", 3, ""},
+		{"bad owner", "$TTL 300\na\\046b A 192.0.2.1\n", 2, ""},
+		{"unterminated string", "$TTL 300\ntxt TXT \"open\n", 2, "unterminated"},
+		{"unbalanced opening parenthesis", "$TTL 300\n@ SOA ns hostmaster (\n1 2 3 4 5\n", 2, "opening parenthesis"},
+		{"unbalanced closing parenthesis", "$TTL 300\nwww A 192.0.2.1 )\n", 2, "closing parenthesis"},
+		{"$GENERATE range", "$TTL 300\n$GENERATE 5-1 host-$ A 192.0.2.1\n", 2, "range"},
+		{"$GENERATE too many records", "$TTL 300\n$GENERATE 0-65536 host-$ A 192.0.2.1\n", 2, "more than 65536"},
+		{"$GENERATE largest range", "$TTL 300\n$GENERATE 0-9223372036854775807 host-$ A 192.0.2.1\n", 2, "more than 65536"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(test.content), "db.example", "example.com.", "IN")
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("expected a ParseError, got %v", err)
+			}
+			if parseErr.File != "db.example" || parseErr.Line != test.line {
+				t.Fatalf("expected the error at db.example:%d, got %v", test.line, err)
+			}
+			if !strings.Contains(err.Error(), test.message) {
+				t.Fatalf("expected the error to mention %q, got %v", test.message, err)
+			}
+		})
+	}
+}
+
+// the largest range allowed still expands
+func TestParseGenerateLimit(t *testing.T) {
+	records, err := Parse(strings.NewReader("$TTL 300\n$GENERATE 1-65536 host-$ A 192.0.2.1\n"), "db.example", "example.com.", "IN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != maxGenerateRecords {
+		t.Fatalf("expected %d records, got %d", maxGenerateRecords, len(records))
+	}
+}
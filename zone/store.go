@@ -0,0 +1,71 @@
+package zone
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+// Store holds the zones the server is authoritative for, by origin
+type Store struct {
+	mutex sync.RWMutex
+	zones map[string]*Zone
+}
+
+func NewStore() *Store {
+	return &Store{zones: map[string]*Zone{}}
+}
+
+// Add serves the zone, replacing any zone with the same origin
+func (s *Store) Add(z *Zone) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.zones[z.Origin] = z
+}
+
+func (s *Store) Remove(origin string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.zones, strings.ToLower(dns.Fqdn(origin)))
+}
+
+func (s *Store) Get(origin string) (*Zone, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	z, ok := s.zones[strings.ToLower(dns.Fqdn(origin))]
+	return z, ok
+}
+
+// Find returns the closest zone enclosing the name, the one with the longest origin
+func (s *Store) Find(name string) (*Zone, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	name = strings.ToLower(dns.Fqdn(name))
+	for {
+		if z, ok := s.zones[name]; ok {
+			return z, true
+		}
+		if name == "." {
+			return nil, false
+		}
+		_, parent, _ := strings.Cut(name, ".")
+		if parent == "" {
+			parent = "."
+		}
+		name = parent
+	}
+}
+
+// Zones returns every zone in the store
+func (s *Store) Zones() []*Zone {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	zones := make([]*Zone, 0, len(s.zones))
+	for _, origin := range sortedKeys(s.zones) {
+		zones = append(zones, s.zones[origin])
+	}
+	return zones
+}
@@ -0,0 +1,299 @@
+package zone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/alissonbk/dns-server/client"
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/server"
+)
+
+// records are packed in the transfer messages until they reach this size,
+// far below the 64KB limit of TCP messages so a single big record still fits
+const transferMessageSize = 16 * 1024
+
+// ErrBadTransfer is returned when a zone transfer response doesn't follow RFC 5936
+var ErrBadTransfer = errors.New("malformed zone transfer")
+
+// ServeDNSStream answers zone transfers of the zones in the Store (RFC 5936), to the clients allowed by TransferACL or TransferKeys
+func (h *Handler) ServeDNSStream(req *server.Request, send func(*dns.Message) error) error {
+	question := req.Message.Questions[0]
+	z, ok := h.Store.Get(question.QNAME)
+	if !ok || z.Class != question.QCLASS {
+		return send(&dns.Message{Header: dns.Header{RCODE: dns.RcodeNotAuth}})
+	}
+	if z.Expired() {
+		return send(&dns.Message{Header: dns.Header{RCODE: dns.RcodeServFail}})
+	}
+	if !allowed(h.TransferACL, h.TransferKeys, req) {
+		return send(&dns.Message{Header: dns.Header{RCODE: dns.RcodeRefused}})
+	}
+
+	var records []dns.Answer
+	if question.QTYPE == "IXFR" {
+		from, ok := clientSerial(req.Message)
+		if !ok {
+			return send(&dns.Message{Header: dns.Header{RCODE: dns.RcodeFormErr}})
+		}
+		records = z.ixfrRecords(from)
+	} else if records = z.Records(); len(records) > 0 {
+		// the SOA starts and ends the transfer (RFC 5936 section 2.2)
+		records = append(records, records[0])
+	}
+	if len(records) == 0 || records[0].TYPE != "SOA" {
+		return fmt.Errorf("the zone %s has no SOA", z.Origin)
+	}
+	return sendRecords(send, records)
+}
+
+// serveIXFR answers an IXFR over UDP, or from a server that doesn't stream. When the answer
+// doesn't fit in a single message only the current SOA is sent, the client then retries over
+// TCP (RFC 1995 section 2)
+func (h *Handler) serveIXFR(req *server.Request, z *Zone) *dns.Message {
+	if !allowed(h.TransferACL, h.TransferKeys, req) {
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeRefused}}
+	}
+	from, ok := clientSerial(req.Message)
+	if !ok {
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeFormErr}}
+	}
+	records := z.ixfrRecords(from)
+	if len(records) == 0 {
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeServFail}}
+	}
+
+	limit := dns.MaxTCPSize
+	if req.Network == "udp" {
+		limit = 512
+		if req.Message.EDNS != nil {
+			limit = max(limit, int(req.Message.EDNS.UDPSIZE))
+		}
+	}
+	// header and question
+	size := 12 + len(z.Origin) + 1 + 4
+	for _, record := range records {
+		size += record.Len()
+	}
+	if size > limit {
+		records = records[:1]
+	}
+	return &dns.Message{Header: dns.Header{AA: true}, Answers: records}
+}
+
+// clientSerial returns the serial of the SOA an IXFR query carries in the authority section
+func clientSerial(query *dns.Message) (uint32, bool) {
+	for _, record := range query.Authority {
+		if soa, ok := record.RDATA.(*dns.SOA); ok && record.TYPE == "SOA" {
+			return soa.SERIAL, true
+		}
+	}
+	return 0, false
+}
+
+// sendRecords packs the records in as many authoritative messages as needed
+func sendRecords(send func(*dns.Message) error, records []dns.Answer) error {
+	message := &dns.Message{Header: dns.Header{AA: true}}
+	size := 0
+	for _, record := range records {
+		length := record.Len()
+		if size+length > transferMessageSize && len(message.Answers) > 0 {
+			if err := send(message); err != nil {
+				return err
+			}
+			message = &dns.Message{Header: dns.Header{AA: true}}
+			size = 0
+		}
+		message.Answers = append(message.Answers, record)
+		size += length
+	}
+	return send(message)
+}
+
+// AXFR pulls the whole zone from the primary (host:port) into a new Zone
+func AXFR(ctx context.Context, c *client.Client, origin string, class string, primary string) (*Zone, error) {
+	z := New(origin, class)
+	query := &dns.Message{Questions: []*dns.Question{{QNAME: z.Origin, QTYPE: "AXFR", QCLASS: class}}}
+
+	var records []dns.Answer
+	err := c.Transfer(ctx, query, primary, func(response *dns.Message) (bool, error) {
+		if response.Header.RCODE != dns.RcodeNoError {
+			return false, fmt.Errorf("the primary answered %s", dns.RcodeString(response.Header.RCODE))
+		}
+		for i, record := range response.Answers {
+			if len(records) == 0 && (record.TYPE != "SOA" || !dns.EqualNames(record.NAME, z.Origin)) {
+				return false, fmt.Errorf("the transfer doesn't start with the SOA of %s, cause: %w", z.Origin, ErrBadTransfer)
+			}
+			// the second SOA ends the transfer, it must be the last record
+			if len(records) > 0 && record.TYPE == "SOA" {
+				if i != len(response.Answers)-1 {
+					return false, fmt.Errorf("records after the final SOA, cause: %w", ErrBadTransfer)
+				}
+				return true, nil
+			}
+			records = append(records, record)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to transfer %s from %s, cause: %w", z.Origin, primary, err)
+	}
+
+	for _, record := range records {
+		if err := z.Insert(record); err != nil {
+			return nil, fmt.Errorf("failed to transfer %s from %s, cause: %w", z.Origin, primary, err)
+		}
+	}
+	return z, nil
+}
+
+// IXFR brings the zone up to date with the primary (host:port) over TCP (RFC 1995). The diffs
+// sent by the primary are applied to the zone at once, or its whole content is replaced when the
+// primary sends the full zone instead. When the primary doesn't support IXFR or the diffs don't
+// apply to the zone, the zone is transferred again with AXFR
+func IXFR(ctx context.Context, c *client.Client, z *Zone, primary string) error {
+	soa, ok := z.SOA()
+	if !ok {
+		return fmt.Errorf("failed to request an IXFR of %s, the zone has no SOA", z.Origin)
+	}
+	query := &dns.Message{
+		Questions: []*dns.Question{{QNAME: z.Origin, QTYPE: "IXFR", QCLASS: z.Class}},
+		Authority: []dns.Answer{soa},
+	}
+
+	ixfr := &ixfrReader{current: serial(soa)}
+	err := c.Transfer(ctx, query, primary, func(response *dns.Message) (bool, error) {
+		if response.Header.RCODE != dns.RcodeNoError {
+			return false, fmt.Errorf("the primary answered %s", dns.RcodeString(response.Header.RCODE))
+		}
+		return ixfr.read(response.Answers)
+	})
+	if err == nil {
+		switch {
+		case ixfr.upToDate:
+			return nil
+		case ixfr.full:
+			err = z.Replace(ixfr.records)
+		default:
+			err = z.Apply(ixfr.diffs...)
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	log.Printf("failed to transfer %s incrementally from %s, falling back to AXFR, cause: %s", z.Origin, primary, err)
+	transferred, err := AXFR(ctx, c, z.Origin, z.Class, primary)
+	if err != nil {
+		return err
+	}
+	return z.Replace(transferred.Records())
+}
+
+// ixfrReader interprets the records of an IXFR response as they arrive. After the SOA of the
+// primary version comes either the SOA of the client version starting the diffs, each one being
+// the old SOA, the deleted records, the new SOA and the added records, or the rest of the zone
+// as in an AXFR. Both end with the SOA of the primary version again
+type ixfrReader struct {
+	// serial of the client
+	current uint32
+	// SOA of the primary version, the first record
+	latest   *dns.Answer
+	count    int
+	upToDate bool
+	// AXFR format, records has the whole zone
+	full    bool
+	records []dns.Answer
+	diffs   []Diff
+	// inside the added records of the last diff, otherwise the deleted ones
+	adding bool
+}
+
+// read consumes the answers of a message, returns true once the response is complete
+func (x *ixfrReader) read(answers []dns.Answer) (bool, error) {
+	for i, record := range answers {
+		x.count++
+		last := i == len(answers)-1
+		isSOA := record.TYPE == "SOA"
+
+		switch {
+		case x.latest == nil:
+			if !isSOA {
+				return false, fmt.Errorf("the transfer doesn't start with a SOA, cause: %w", ErrBadTransfer)
+			}
+			x.latest = &record
+			// a single SOA not newer than ours means there is nothing to transfer
+			if last && dns.CompareSerial(serial(record), x.current) <= 0 {
+				x.upToDate = true
+				return true, nil
+			}
+
+		case x.count == 2:
+			if isSOA && serial(record) == x.current && serial(record) != serial(*x.latest) {
+				x.diffs = append(x.diffs, Diff{From: record})
+				continue
+			}
+			x.full = true
+			x.records = append(x.records, *x.latest)
+			if isSOA {
+				return x.end(last)
+			}
+			x.records = append(x.records, record)
+
+		case x.full:
+			if isSOA {
+				return x.end(last)
+			}
+			x.records = append(x.records, record)
+
+		case !isSOA:
+			diff := &x.diffs[len(x.diffs)-1]
+			if x.adding {
+				diff.Added = append(diff.Added, record)
+			} else {
+				diff.Deleted = append(diff.Deleted, record)
+			}
+
+		case !x.adding:
+			x.diffs[len(x.diffs)-1].To = record
+			x.adding = true
+
+		case serial(record) == serial(*x.latest):
+			return x.end(last)
+
+		default:
+			x.diffs = append(x.diffs, Diff{From: record})
+			x.adding = false
+		}
+	}
+	return false, nil
+}
+
+// end checks the final SOA is the last record of the response
+func (x *ixfrReader) end(last bool) (bool, error) {
+	if !last {
+		return false, fmt.Errorf("records after the final SOA, cause: %w", ErrBadTransfer)
+	}
+	return true, nil
+}
+
+// TransferIn pulls the zone from the primary and serves it from the store as a secondary zone,
+// with IXFR when the store already has a copy of the zone and AXFR otherwise. The zone keeps
+// answering from its current content during the transfer
+func (s *Store) TransferIn(ctx context.Context, c *client.Client, origin string, class string, primary string) (*Zone, error) {
+	if z, ok := s.Get(origin); ok {
+		if _, hasSOA := z.SOA(); hasSOA {
+			return z, IXFR(ctx, c, z, primary)
+		}
+	}
+
+	z, err := AXFR(ctx, c, origin, class, primary)
+	if err != nil {
+		return nil, err
+	}
+	z.SetSecondary(true)
+	s.Add(z)
+	return z, nil
+}
@@ -0,0 +1,246 @@
+package zone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alissonbk/dns-server/client"
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/server"
+)
+
+// describe renders what the reader made of the response
+func describe(x *ixfrReader) string {
+	switch {
+	case x.upToDate:
+		return "up to date"
+	case x.full:
+		return "full\n" + render(x.records)
+	}
+	var diffs []string
+	for _, diff := range x.diffs {
+		diffs = append(diffs, fmt.Sprintf("%d -> %d\n-%s\n+%s", serial(diff.From), serial(diff.To), render(diff.Deleted), render(diff.Added)))
+	}
+	return strings.Join(diffs, "\n")
+}
+
+func TestIXFRReader(t *testing.T) {
+	www1 := aRecord("www.example.com.", "192.0.2.1")
+	www2 := aRecord("www.example.com.", "192.0.2.2")
+	mail := aRecord("mail.example.com.", "192.0.2.3")
+	ns := dns.Answer{NAME: "example.com.", TYPE: "NS", CLASS: "IN", TTL: 300, RDATA: &dns.NS{NSDNAME: "ns.example.com."}}
+	severalDiffs := []dns.Answer{soaRecord(7), soaRecord(5), www1, soaRecord(6), www2, soaRecord(6), soaRecord(7), mail, soaRecord(7)}
+	severalDiffsResult := `5 -> 6
+-www.example.com. 300 IN A 192.0.2.1
++www.example.com. 300 IN A 192.0.2.2
+6 -> 7
+-
++mail.example.com. 300 IN A 192.0.2.3`
+
+	tests := []struct {
+		name string
+		// serial of the client
+		current  uint32
+		messages [][]dns.Answer
+		result   string
+		err      error
+	}{
+		{"up to date", 5, [][]dns.Answer{{soaRecord(5)}}, "up to date", nil},
+		{"primary behind", 5, [][]dns.Answer{{soaRecord(4)}}, "up to date", nil},
+		{
+			"full zone", 5, [][]dns.Answer{{soaRecord(6), ns, www1, soaRecord(6)}},
+			"full\n" + render([]dns.Answer{soaRecord(6), ns, www1}), nil,
+		},
+		{
+			"full zone over several messages", 5, [][]dns.Answer{{soaRecord(6)}, {ns}, {www1, soaRecord(6)}},
+			"full\n" + render([]dns.Answer{soaRecord(6), ns, www1}), nil,
+		},
+		{"several diffs", 5, [][]dns.Answer{severalDiffs}, severalDiffsResult, nil},
+		{
+			"diffs split across messages", 5,
+			[][]dns.Answer{severalDiffs[:2], severalDiffs[2:4], severalDiffs[4:7], severalDiffs[7:]},
+			severalDiffsResult, nil,
+		},
+		{
+			"one record per message", 5,
+			[][]dns.Answer{{severalDiffs[0]}, {severalDiffs[1]}, {severalDiffs[2]}, {severalDiffs[3]}, {severalDiffs[4]}, {severalDiffs[5]}, {severalDiffs[6]}, {severalDiffs[7]}, {severalDiffs[8]}},
+			severalDiffsResult, nil,
+		},
+		{
+			"records after the final SOA of the diffs", 5,
+			[][]dns.Answer{{soaRecord(6), soaRecord(5), www1, soaRecord(6), www2, soaRecord(6), mail}}, "", ErrBadTransfer,
+		},
+		{
+			"records after the final SOA of the full zone", 5,
+			[][]dns.Answer{{soaRecord(6), ns, soaRecord(6), www1}}, "", ErrBadTransfer,
+		},
+		{"no SOA first", 5, [][]dns.Answer{{ns, soaRecord(6)}}, "", ErrBadTransfer},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			x := &ixfrReader{current: test.current}
+			for i, answers := range test.messages {
+				done, err := x.read(answers)
+				if err != nil {
+					if !errors.Is(err, test.err) {
+						t.Fatalf("expected %v, got %v", test.err, err)
+					}
+					return
+				}
+				if last := i == len(test.messages)-1; done != last {
+					t.Fatalf("message %d: expected done to be %t, got %t", i, last, done)
+				}
+			}
+			if test.err != nil {
+				t.Fatalf("expected %v", test.err)
+			}
+			if got := describe(x); got != test.result {
+				t.Fatalf("expected\n%s\ngot\n%s", test.result, got)
+			}
+		})
+	}
+}
+
+// serveTCP serves the handler on a loopback TCP listener, returns its address
+func serveTCP(t *testing.T, handler server.Handler) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server.Server{Handler: handler}
+	errs := make(chan error, 1)
+	go func() { errs <- s.ServeTCP(l) }()
+	t.Cleanup(func() {
+		s.Shutdown(context.Background())
+		<-errs
+	})
+	return l.Addr().String()
+}
+
+// largeZone has enough records to need several messages of transferMessageSize
+func largeZone(t *testing.T) *Zone {
+	z := newTestZone(t)
+	for i := range 2000 {
+		if err := z.Insert(aRecord(fmt.Sprintf("host-%d.example.com.", i), "192.0.2.1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return z
+}
+
+func TestServeAXFR(t *testing.T) {
+	z := largeZone(t)
+	store := NewStore()
+	store.Add(z)
+	addr := serveTCP(t, &Handler{Store: store, TransferACL: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}})
+	c := &client.Client{Timeout: 2 * time.Second}
+	query := &dns.Message{Questions: []*dns.Question{{QNAME: "example.com.", QTYPE: "AXFR", QCLASS: "IN"}}}
+
+	var messages int
+	var records []dns.Answer
+	err := c.Transfer(context.Background(), query, addr, func(response *dns.Message) (bool, error) {
+		if response.Header.RCODE != dns.RcodeNoError || !response.Header.AA {
+			return false, fmt.Errorf("unexpected response %s, AA %t", dns.RcodeString(response.Header.RCODE), response.Header.AA)
+		}
+		messages++
+		records = append(records, response.Answers...)
+		// the final SOA ends the stream
+		return len(records) > 1 && records[len(records)-1].TYPE == "SOA", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messages < 2 {
+		t.Fatalf("expected the zone to span several messages, got %d", messages)
+	}
+	expected := append(z.Records(), soaRecord(1))
+	expected[len(expected)-1] = expected[0]
+	if render(records) != render(expected) {
+		t.Fatalf("expected the zone between two SOA, got %d records starting with %s", len(records), records[0].TYPE)
+	}
+
+	transferred, err := AXFR(context.Background(), c, "example.com.", "IN", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if render(transferred.Records()) != render(z.Records()) {
+		t.Fatal("the transferred zone differs from the primary one")
+	}
+}
+
+func TestServeAXFRRefused(t *testing.T) {
+	store := NewStore()
+	store.Add(newTestZone(t))
+	addr := serveTCP(t, &Handler{Store: store, TransferACL: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}})
+
+	_, err := AXFR(context.Background(), &client.Client{Timeout: 2 * time.Second}, "example.com.", "IN", addr)
+	if err == nil || !strings.Contains(err.Error(), "REFUSED") {
+		t.Fatalf("expected the transfer to be refused, got %v", err)
+	}
+}
+
+// AXFR over UDP is a malformed query, not an unsupported OPCODE
+func TestServeAXFROverUDP(t *testing.T) {
+	store := NewStore()
+	store.Add(newTestZone(t))
+	h := &Handler{Store: store, TransferACL: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}}
+	req := update("example.com.")
+	req.Message = &dns.Message{Questions: []*dns.Question{{QNAME: "example.com.", QTYPE: "AXFR", QCLASS: "IN"}}}
+	response, err := h.ServeDNS(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Header.RCODE != dns.RcodeFormErr {
+		t.Fatalf("expected FORMERR, got %s", dns.RcodeString(response.Header.RCODE))
+	}
+}
+
+// stream is a primary answering every transfer with the same messages
+type stream [][]dns.Answer
+
+func (s stream) ServeDNS(req *server.Request) (*dns.Message, error) {
+	return &dns.Message{Header: dns.Header{RCODE: dns.RcodeNotImp}}, nil
+}
+
+func (s stream) ServeDNSStream(req *server.Request, send func(*dns.Message) error) error {
+	for _, answers := range s {
+		if err := send(&dns.Message{Header: dns.Header{AA: true}, Answers: answers}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestAXFRMalformed(t *testing.T) {
+	www := aRecord("www.example.com.", "192.0.2.1")
+	otherSOA := soaRecord(1)
+	otherSOA.NAME = "example.net."
+	tests := []struct {
+		name   string
+		stream stream
+		err    error
+	}{
+		// the client waits for the rest until it times out
+		{"no final SOA", stream{{soaRecord(1), www}, {www}}, nil},
+		{"records after the final SOA", stream{{soaRecord(1), www, soaRecord(1), www}}, ErrBadTransfer},
+		{"no SOA first", stream{{www, soaRecord(1)}}, ErrBadTransfer},
+		{"SOA of another zone first", stream{{otherSOA, www, otherSOA}}, ErrBadTransfer},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr := serveTCP(t, test.stream)
+			_, err := AXFR(context.Background(), &client.Client{Timeout: 200 * time.Millisecond}, "example.com.", "IN", addr)
+			if err == nil || (test.err != nil && !errors.Is(err, test.err)) {
+				t.Fatalf("expected the transfer to fail with %v, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package zone
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/server"
+)
+
+// serveUpdate answers a dynamic UPDATE (RFC 2136 section 3) of a primary zone in the Store,
+// from the clients allowed by UpdateACL or UpdateKeys. Secondary zones answer NOTAUTH
+func (h *Handler) serveUpdate(req *server.Request) *dns.Message {
+	if len(req.Message.Questions) != 1 || req.Message.Questions[0].QTYPE != "SOA" {
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeFormErr}}
+	}
+	question := req.Message.Questions[0]
+	z, ok := h.Store.Get(question.QNAME)
+	if !ok || z.Class != question.QCLASS {
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeNotAuth}}
+	}
+	// a copy would be overwritten by the next transfer, the update must be sent to the primary
+	if z.Secondary() {
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeNotAuth}}
+	}
+	if z.Expired() {
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeServFail}}
+	}
+	if !allowed(h.UpdateACL, h.UpdateKeys, req) {
+		return &dns.Message{Header: dns.Header{RCODE: dns.RcodeRefused}}
+	}
+	return &dns.Message{Header: dns.Header{RCODE: z.Update(req.Message.Answers, req.Message.Authority)}}
+}
+
+// Update checks the prerequisites and makes the updates of an UPDATE message (RFC 2136 section 3),
+// returning the RCODE of the response. It's a single step: nothing changes unless every prerequisite
+// holds and every update is well formed, and lookups never see the zone half way. Unless the updates
+// replace the SOA with a newer one, the serial is incremented when anything changed
+func (z *Zone) Update(prerequisites []dns.Answer, updates []dns.Answer) uint16 {
+	z.mutex.Lock()
+	rcode, changed := z.update(prerequisites, updates)
+	z.mutex.Unlock()
+	if changed {
+		z.changed()
+	}
+	return rcode
+}
+
+// the caller must hold the mutex
+func (z *Zone) update(prerequisites []dns.Answer, updates []dns.Answer) (uint16, bool) {
+	if rcode := z.checkPrerequisites(prerequisites); rcode != dns.RcodeNoError {
+		return rcode, false
+	}
+	if rcode := z.prescan(updates); rcode != dns.RcodeNoError {
+		return rcode, false
+	}
+	from, ok := z.soa()
+	if !ok {
+		return dns.RcodeServFail, false
+	}
+
+	// the updates are made on a copy of the names they touch and the apex,
+	// what differs afterwards is applied to the zone as a Diff
+	names := []string{z.Origin}
+	for _, record := range updates {
+		name := strings.ToLower(dns.Fqdn(record.NAME))
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	before := []dns.Answer{}
+	scratch := New(z.Origin, z.Class)
+	for _, name := range names {
+		if n := z.find(name); n != nil {
+			for _, recordType := range sortedKeys(n.rrsets) {
+				for _, record := range n.rrsets[recordType] {
+					scratch.insert(record)
+					if record.TYPE != "SOA" {
+						before = append(before, record)
+					}
+				}
+			}
+		}
+	}
+	for _, record := range updates {
+		scratch.updateRecord(record)
+	}
+
+	var after []dns.Answer
+	for _, record := range scratch.records() {
+		if record.TYPE != "SOA" {
+			after = append(after, record)
+		}
+	}
+	diff := Diff{From: from}
+	diff.Deleted, diff.Added = difference(before, after)
+	diff.To, _ = scratch.soa()
+	if serial(diff.To) == serial(from) {
+		if len(diff.Deleted) == 0 && len(diff.Added) == 0 {
+			return dns.RcodeNoError, false
+		}
+		diff.To = incrementSerial(from)
+	}
+
+	if err := z.apply([]Diff{diff}); err != nil {
+		return dns.RcodeServFail, false
+	}
+	return dns.RcodeNoError, true
+}
+
+// checkPrerequisites returns the RCODE of the first prerequisite that doesn't hold (RFC 2136 section 3.2).
+// the caller must hold the mutex
+func (z *Zone) checkPrerequisites(prerequisites []dns.Answer) uint16 {
+	// value dependent prerequisites, by name and type
+	expected := map[[2]string][]dns.Answer{}
+	for _, record := range prerequisites {
+		if record.TTL != 0 {
+			return dns.RcodeFormErr
+		}
+		if !dns.IsSubdomain(record.NAME, z.Origin) {
+			return dns.RcodeNotZone
+		}
+		n := z.find(record.NAME)
+		switch record.CLASS {
+		case "ANY":
+			if record.RDATA != nil {
+				return dns.RcodeFormErr
+			}
+			if record.TYPE == "*" && (n == nil || len(n.rrsets) == 0) {
+				return dns.RcodeNXDomain
+			}
+			if record.TYPE != "*" && (n == nil || len(n.rrsets[record.TYPE]) == 0) {
+				return dns.RcodeNXRRSet
+			}
+		case "NONE":
+			if record.RDATA != nil {
+				return dns.RcodeFormErr
+			}
+			if record.TYPE == "*" && n != nil && len(n.rrsets) > 0 {
+				return dns.RcodeYXDomain
+			}
+			if record.TYPE != "*" && n != nil && len(n.rrsets[record.TYPE]) > 0 {
+				return dns.RcodeYXRRSet
+			}
+		case z.Class:
+			key := [2]string{strings.ToLower(dns.Fqdn(record.NAME)), record.TYPE}
+			expected[key] = append(expected[key], record)
+		default:
+			return dns.RcodeFormErr
+		}
+	}
+
+	// the RRsets must be exactly the same, TTLs aside
+	for key, records := range expected {
+		n := z.find(key[0])
+		if n == nil {
+			return dns.RcodeNXRRSet
+		}
+		rrset := n.rrsets[key[1]]
+		for _, record := range records {
+			if !containsRecord(rrset, record) {
+				return dns.RcodeNXRRSet
+			}
+		}
+		for _, existing := range rrset {
+			if !containsRecord(records, existing) {
+				return dns.RcodeNXRRSet
+			}
+		}
+	}
+	return dns.RcodeNoError
+}
+
+// prescan checks every update is well formed before making any of them (RFC 2136 section 3.4.1)
+func (z *Zone) prescan(updates []dns.Answer) uint16 {
+	for _, record := range updates {
+		if !dns.IsSubdomain(record.NAME, z.Origin) {
+			return dns.RcodeNotZone
+		}
+		switch record.TYPE {
+		case "AXFR", "IXFR", "MAILA", "MAILB", "OPT", "TSIG":
+			return dns.RcodeFormErr
+		}
+		switch record.CLASS {
+		case z.Class:
+			if record.TYPE == "*" || record.RDATA == nil {
+				return dns.RcodeFormErr
+			}
+		case "ANY":
+			if record.TTL != 0 || record.RDATA != nil {
+				return dns.RcodeFormErr
+			}
+		case "NONE":
+			if record.TTL != 0 || record.TYPE == "*" || record.RDATA == nil {
+				return dns.RcodeFormErr
+			}
+		default:
+			return dns.RcodeFormErr
+		}
+	}
+	return dns.RcodeNoError
+}
+
+// updateRecord makes a single update (RFC 2136 section 3.4.2), the ones the RFC says to
+// silently ignore are ignored. the caller must hold the mutex
+func (z *Zone) updateRecord(record dns.Answer) {
+	apex := dns.EqualNames(record.NAME, z.Origin)
+	switch record.CLASS {
+	case z.Class:
+		if record.TYPE == "SOA" {
+			current, ok := z.soa()
+			if !ok || dns.CompareSerial(serial(record), serial(current)) <= 0 {
+				return
+			}
+		}
+		// a CNAME conflict or a SOA outside the apex are ignored too
+		z.insert(record)
+	case "ANY":
+		n := z.find(record.NAME)
+		if n == nil {
+			return
+		}
+		if record.TYPE != "*" {
+			if apex && (record.TYPE == "SOA" || record.TYPE == "NS") {
+				return
+			}
+			delete(n.rrsets, record.TYPE)
+		} else {
+			for recordType := range n.rrsets {
+				if !apex || (recordType != "SOA" && recordType != "NS") {
+					delete(n.rrsets, recordType)
+				}
+			}
+		}
+		z.prune(record.NAME)
+	case "NONE":
+		if record.TYPE == "SOA" {
+			return
+		}
+		// the zone keeps at least one NS at the apex
+		if apex && record.TYPE == "NS" && len(z.root.rrsets["NS"]) <= 1 {
+			return
+		}
+		record.CLASS = z.Class
+		z.remove(record)
+	}
+}
+
+// incrementSerial returns a copy of the SOA with the next serial
+func incrementSerial(soa dns.Answer) dns.Answer {
+	rdata := *soa.RDATA.(*dns.SOA)
+	rdata.SERIAL++
+	soa.RDATA = &rdata
+	return soa
+}
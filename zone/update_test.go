@@ -0,0 +1,89 @@
+package zone
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/alissonbk/dns-server/dns"
+	"github.com/alissonbk/dns-server/server"
+)
+
+func newTestZone(t *testing.T) *Zone {
+	t.Helper()
+	content := `$TTL 300
+@ SOA ns hostmaster 1 3600 600 86400 60
+@ NS ns
+ns A 192.0.2.1
+`
+	records, err := Parse(strings.NewReader(content), "db.example", "example.com.", "IN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := New("example.com.", "IN")
+	for _, record := range records {
+		if err := z.Insert(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return z
+}
+
+// update adds an A record to www.example.com.
+func update(origin string) *server.Request {
+	return &server.Request{
+		Message: &dns.Message{
+			Header:    dns.Header{OPCODE: dns.OpcodeUpdate},
+			Questions: []*dns.Question{{QNAME: origin, QTYPE: "SOA", QCLASS: "IN"}},
+			Authority: []dns.Answer{{
+				NAME: "www.example.com.", TYPE: "A", CLASS: "IN", TTL: 300,
+				RDATA: &dns.A{ADDRESS: netip.MustParseAddr("192.0.2.2")},
+			}},
+		},
+		RemoteAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353},
+		Network:    "udp",
+	}
+}
+
+func TestServeUpdate(t *testing.T) {
+	tests := []struct {
+		name      string
+		origin    string
+		secondary bool
+		expired   bool
+		acl       string
+		rcode     uint16
+	}{
+		{"primary zone", "example.com.", false, false, "127.0.0.0/8", dns.RcodeNoError},
+		{"client outside the ACL", "example.com.", false, false, "192.0.2.0/24", dns.RcodeRefused},
+		{"zone not served", "example.net.", false, false, "127.0.0.0/8", dns.RcodeNotAuth},
+		{"secondary zone", "example.com.", true, false, "127.0.0.0/8", dns.RcodeNotAuth},
+		{"expired secondary zone", "example.com.", true, true, "127.0.0.0/8", dns.RcodeNotAuth},
+		{"expired zone", "example.com.", false, true, "127.0.0.0/8", dns.RcodeServFail},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			z := newTestZone(t)
+			z.SetSecondary(test.secondary)
+			z.SetExpired(test.expired)
+			store := NewStore()
+			store.Add(z)
+			h := &Handler{Store: store, UpdateACL: []netip.Prefix{netip.MustParsePrefix(test.acl)}}
+
+			response, err := h.ServeDNS(update(test.origin))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Header.RCODE != test.rcode {
+				t.Fatalf("expected %s, got %s", dns.RcodeString(test.rcode), dns.RcodeString(response.Header.RCODE))
+			}
+
+			z.SetExpired(false)
+			updated := len(z.Lookup("www.example.com.", "A").Answers) == 1
+			if updated != (test.rcode == dns.RcodeNoError) {
+				t.Fatalf("the zone was updated: %t", updated)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package zone
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+// Write serializes the zone as a master file in canonical order: the SOA first and then
+// every other record sorted as RFC 4034 section 6 describes, with fully qualified names
+// so the output doesn't depend on an origin
+func (z *Zone) Write(w io.Writer) error {
+	records := z.Records()
+	if len(records) > 0 && records[0].TYPE == "SOA" {
+		slices.SortStableFunc(records[1:], dns.CompareRecords)
+	} else {
+		slices.SortStableFunc(records, dns.CompareRecords)
+	}
+
+	buffered := bufio.NewWriter(w)
+	fmt.Fprintf(buffered, "$ORIGIN %s\n", z.Origin)
+	for _, record := range records {
+		fmt.Fprintln(buffered, record.String())
+	}
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to write the zone %s, cause: %w", z.Origin, err)
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package zone
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/alissonbk/dns-server/dns"
+)
+
+var (
+	// ErrOutOfZone is returned when a record owner is not below the zone origin
+	ErrOutOfZone = errors.New("the record is out of the zone")
+	// ErrCNAMEConflict is returned when a CNAME would share its owner with other data (RFC 1034 section 3.6.2)
+	ErrCNAMEConflict = errors.New("a CNAME can't coexist with other records")
+)
+
+// node is a name in the zone tree, children are keyed by their lower case label
+type node struct {
+	children map[string]*node
+	// RRsets by TYPE
+	rrsets map[string][]dns.Answer
+}
+
+func newNode() *node {
+	return &node{children: map[string]*node{}, rrsets: map[string][]dns.Answer{}}
+}
+
+// Zone is the data a server is authoritative for, kept in a tree of labels rooted at the origin.
+// names without records but with descendants (empty non-terminals) are nodes too, so they
+// exist for the lookup algorithm
+type Zone struct {
+	// lower case and fully qualified
+	Origin string
+	Class  string
+
+	mutex sync.RWMutex
+	root  *node
+	// oldest first, see { Apply }
+	journal []Diff
+	// called after every change of the serial, see { OnChange }
+	listeners []func(*Zone)
+	// a copy transferred from a primary, see { SetSecondary }
+	secondary bool
+	// a secondary copy not refreshed within the SOA EXPIRE, see { SetExpired }
+	expired bool
+}
+
+func New(origin string, class string) *Zone {
+	return &Zone{Origin: strings.ToLower(dns.Fqdn(origin)), Class: class, root: newNode()}
+}
+
+// OnChange registers a function called after every change of the zone serial,
+// from the goroutine that changed it and without holding the zone lock
+func (z *Zone) OnChange(listener func(*Zone)) {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	z.listeners = append(z.listeners, listener)
+}
+
+func (z *Zone) changed() {
+	z.mutex.RLock()
+	listeners := z.listeners
+	z.mutex.RUnlock()
+	for _, listener := range listeners {
+		listener(z)
+	}
+}
+
+// SetSecondary marks the zone as a copy of a primary, it's only changed by transfers from
+// the primary so UPDATE is refused (RFC 2136 section 6)
+func (z *Zone) SetSecondary(secondary bool) {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	z.secondary = secondary
+}
+
+func (z *Zone) Secondary() bool {
+	z.mutex.RLock()
+	defer z.mutex.RUnlock()
+	return z.secondary
+}
+
+// SetExpired marks a secondary copy as too old to be served, queries are then answered with
+// SERVFAIL until it's refreshed (RFC 1035 section 4.3.5)
+func (z *Zone) SetExpired(expired bool) {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	z.expired = expired
+}
+
+func (z *Zone) Expired() bool {
+	z.mutex.RLock()
+	defer z.mutex.RUnlock()
+	return z.expired
+}
+
+// labels returns the labels of name below the origin, from the closest to the origin to the leftmost
+func (z *Zone) labels(name string) ([]string, bool) {
+	if !dns.IsSubdomain(name, z.Origin) {
+		return nil, false
+	}
+	name = strings.ToLower(dns.Fqdn(name))
+	relative := strings.TrimSuffix(strings.TrimSuffix(name, z.Origin), ".")
+	if relative == "" || name == z.Origin {
+		return nil, true
+	}
+	labels := strings.Split(relative, ".")
+	slices.Reverse(labels)
+	return labels, true
+}
+
+// find returns the node of the name, ignoring zone cuts
+func (z *Zone) find(name string) *node {
+	labels, ok := z.labels(name)
+	if !ok {
+		return nil
+	}
+	n := z.root
+	for _, label := range labels {
+		if n = n.children[label]; n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// Insert adds the record to its RRset, a record with the same RDATA replaces the existing one.
+// a SOA replaces the one at the apex, there's only one
+func (z *Zone) Insert(record dns.Answer) error {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	_, err := z.insert(record)
+	return err
+}
+
+// insert returns the records the new one replaced, the caller must hold the mutex
+func (z *Zone) insert(record dns.Answer) ([]dns.Answer, error) {
+	labels, ok := z.labels(record.NAME)
+	if !ok {
+		return nil, fmt.Errorf("failed to insert %s %s in %s, cause: %w", record.NAME, record.TYPE, z.Origin, ErrOutOfZone)
+	}
+	if record.TYPE == "SOA" && len(labels) > 0 {
+		return nil, fmt.Errorf("failed to insert the SOA of %s, it must be at the zone apex %s", record.NAME, z.Origin)
+	}
+
+	n := z.root
+	for _, label := range labels {
+		child, ok := n.children[label]
+		if !ok {
+			child = newNode()
+			n.children[label] = child
+		}
+		n = child
+	}
+
+	_, hasCNAME := n.rrsets["CNAME"]
+	if (record.TYPE == "CNAME" && len(n.rrsets) > 0 && !hasCNAME) || (record.TYPE != "CNAME" && hasCNAME) {
+		z.prune(record.NAME)
+		return nil, fmt.Errorf("failed to insert %s %s, cause: %w", record.NAME, record.TYPE, ErrCNAMEConflict)
+	}
+
+	var replaced []dns.Answer
+	rrset := n.rrsets[record.TYPE]
+	// CNAME and SOA are singletons
+	if record.TYPE == "CNAME" || record.TYPE == "SOA" {
+		replaced, rrset = rrset, nil
+	}
+	// cloned as the slice may be shared with the records returned by RRset and Records
+	rrset = slices.DeleteFunc(slices.Clone(rrset), func(existing dns.Answer) bool {
+		if dns.EqualRData(existing.RDATA, record.RDATA) {
+			replaced = append(replaced, existing)
+			return true
+		}
+		return false
+	})
+	n.rrsets[record.TYPE] = append(rrset, record)
+	return replaced, nil
+}
+
+// Remove deletes the record with the same NAME, TYPE and RDATA, returns false when there is none
+func (z *Zone) Remove(record dns.Answer) bool {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	return z.remove(record)
+}
+
+// the caller must hold the mutex
+func (z *Zone) remove(record dns.Answer) bool {
+	n := z.find(record.NAME)
+	if n == nil {
+		return false
+	}
+	rrset := n.rrsets[record.TYPE]
+	remaining := slices.DeleteFunc(slices.Clone(rrset), func(existing dns.Answer) bool {
+		return dns.EqualRData(existing.RDATA, record.RDATA)
+	})
+	if len(remaining) == len(rrset) {
+		return false
+	}
+	if len(remaining) == 0 {
+		delete(n.rrsets, record.TYPE)
+		z.prune(record.NAME)
+	} else {
+		n.rrsets[record.TYPE] = remaining
+	}
+	return true
+}
+
+// RemoveRRset deletes every record of the type at the name, returns false when there is none
+func (z *Zone) RemoveRRset(name string, recordType string) bool {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	n := z.find(name)
+	if n == nil || len(n.rrsets[recordType]) == 0 {
+		return false
+	}
+	delete(n.rrsets, recordType)
+	z.prune(name)
+	return true
+}
+
+// prune removes the nodes left without records nor children on the path to name,
+// otherwise they would still exist as empty non-terminals
+func (z *Zone) prune(name string) {
+	labels, _ := z.labels(name)
+	path := []*node{z.root}
+	for _, label := range labels {
+		path = append(path, path[len(path)-1].children[label])
+	}
+	for i := len(labels); i > 0; i-- {
+		n := path[i]
+		if len(n.rrsets) > 0 || len(n.children) > 0 {
+			return
+		}
+		delete(path[i-1].children, labels[i-1])
+	}
+}
+
+// RRset returns a copy of the records of the type at the name
+func (z *Zone) RRset(name string, recordType string) []dns.Answer {
+	z.mutex.RLock()
+	defer z.mutex.RUnlock()
+
+	n := z.find(name)
+	if n == nil {
+		return nil
+	}
+	return slices.Clone(n.rrsets[recordType])
+}
+
+// SOA returns the SOA record of the zone apex, false when the zone has none yet
+func (z *Zone) SOA() (dns.Answer, bool) {
+	z.mutex.RLock()
+	defer z.mutex.RUnlock()
+	return z.soa()
+}
+
+func (z *Zone) soa() (dns.Answer, bool) {
+	rrset := z.root.rrsets["SOA"]
+	if len(rrset) == 0 {
+		return dns.Answer{}, false
+	}
+	return rrset[0], true
+}
+
+// Records returns every record of the zone, the SOA first and then
+// the RRsets of each name walking the tree depth first
+func (z *Zone) Records() []dns.Answer {
+	z.mutex.RLock()
+	defer z.mutex.RUnlock()
+	return z.records()
+}
+
+// the caller must hold the mutex
+func (z *Zone) records() []dns.Answer {
+	var records []dns.Answer
+	if soa, ok := z.soa(); ok {
+		records = append(records, soa)
+	}
+	var walk func(n *node)
+	walk = func(n *node) {
+		for _, recordType := range sortedKeys(n.rrsets) {
+			if n == z.root && recordType == "SOA" {
+				continue
+			}
+			records = append(records, n.rrsets[recordType]...)
+		}
+		for _, label := range sortedKeys(n.children) {
+			walk(n.children[label])
+		}
+	}
+	walk(z.root)
+	return records
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}